@@ -0,0 +1,102 @@
+// Copyright 2021 Dalarub & Ettrich GmbH - All Rights Reserved
+// Unauthorized copying of this file, via any medium is strictly prohibited
+// Proprietary and confidential
+// info@dalarub.com
+//
+
+// Command kurbisio shows what a backend configuration would change in a database, without changing it.
+//
+//	kurbisio -schema fleet -config fleet.json
+//
+// The database is specified like for the backend, e.g.
+// POSTGRES="host=localhost port=5432 user=postgres dbname=postgres sslmode=disable"
+// and POSTGRES_PASSWORD="docker"
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/goccy/go-json"
+	"github.com/joeshaw/envdecode"
+	_ "github.com/lib/pq"
+
+	"github.com/relabs-tech/kurbisio/core/backend"
+	"github.com/relabs-tech/kurbisio/core/csql"
+)
+
+// Service holds the database configuration
+type Service struct {
+	Postgres         string `env:"POSTGRES,required" description:"the connection string for the Postgres DB without password"`
+	PostgresPassword string `env:"POSTGRES_PASSWORD,optional" description:"password to the Postgres DB"`
+}
+
+func main() {
+	schema := flag.String("schema", "", "the database schema of the backend")
+	configFile := flag.String("config", "", "the configuration json file")
+	asJSON := flag.Bool("json", false, "print the plan as json")
+	flag.Parse()
+	if *schema == "" || *configFile == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	service := &Service{}
+	if err := envdecode.Decode(service); err != nil {
+		fail(err)
+	}
+	config, err := os.ReadFile(*configFile)
+	if err != nil {
+		fail(err)
+	}
+
+	db := csql.OpenWithSchema(service.Postgres, service.PostgresPassword, *schema)
+	defer db.Close()
+
+	plan, err := backend.DryRun(&backend.Builder{
+		Config: string(config),
+		DB:     db,
+	})
+	if err != nil {
+		fail(err)
+	}
+
+	if *asJSON {
+		jsonData, _ := json.MarshalIndent(plan, "", "  ")
+		fmt.Println(string(jsonData))
+		return
+	}
+	printChanges("table", plan.Tables)
+	printChanges("column", plan.Columns)
+	printChanges("index", plan.Indices)
+	printChanges("route", plan.Routes)
+	if len(plan.Migrations) > 0 {
+		fmt.Println("pending migrations:")
+		for _, version := range plan.Migrations {
+			fmt.Println("  ", version)
+		}
+	}
+	if len(plan.SQL) == 0 {
+		fmt.Println("no schema changes")
+		return
+	}
+	fmt.Println("sql:")
+	for _, statement := range plan.SQL {
+		fmt.Println(statement)
+	}
+}
+
+func printChanges(what string, changes backend.Changes) {
+	for _, object := range changes.Added {
+		fmt.Printf("+ %s %s\n", what, object)
+	}
+	for _, object := range changes.Orphaned {
+		fmt.Printf("- %s %s (orphaned)\n", what, object)
+	}
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "kurbisio:", err)
+	os.Exit(1)
+}
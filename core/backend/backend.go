@@ -49,11 +49,16 @@ const InternalDatabaseSchemaVersion = 2
 // Backend is the generic rest backend
 type Backend struct {
 	config              Configuration
+	configJSON          string
 	db                  *csql.DB
 	router              *mux.Router
 	publicURL           string
 	collectionFunctions map[string]*collectionFunctions
 	relations           map[string]string
+	batchRoutes         map[string]batchRoute
+	softDeletes         map[string]time.Duration // soft deleted resources with their retention
+	logColumns          map[string][]string      // resources with log and the columns of their items
+	housekeeping        map[string][]housekeepingTask
 	// Registry is the JSON object registry for this backend's schema
 	Registry             registry.Registry
 	authorizationEnabled bool
@@ -65,6 +70,7 @@ type Backend struct {
 	interceptors             map[string]requestHandler
 
 	pipelineConcurrency int
+	idempotencyWindow   time.Duration
 
 	jobsInsertQuery, jobsInsertIfNotExistQuery, jobsCancelQuery,
 	jobsUpdateQuery, jobsDeleteQuery, jobsResetImplicitScheduleQuery, jobsUpdateScheduleQuery, rateLimitQuery string
@@ -76,6 +82,10 @@ type Backend struct {
 
 	jsonValidator *schema.Validator
 	KssDriver     kss.Driver
+
+	// in a dry run, schema updates are recorded in schemaStatements instead of being executed
+	dryRun           bool
+	schemaStatements []string
 }
 
 // Builder is a builder helper for the Backend
@@ -112,6 +122,10 @@ type Builder struct {
 
 	// Defines the configuration for the KSS service
 	KssConfiguration kss.Configuration
+
+	// IdempotencyWindow is how long responses to POST requests with an Idempotency-Key header
+	// are kept for retries. Default is 24 hours.
+	IdempotencyWindow time.Duration
 }
 
 // New realizes the actual backend. It creates the sql relations (if they
@@ -138,6 +152,11 @@ func New(bb *Builder) *Backend {
 		pipelineConcurrency = bb.PipelineConcurrency
 	}
 
+	idempotencyWindow := defaultIdempotencyWindow
+	if bb.IdempotencyWindow > 0 {
+		idempotencyWindow = bb.IdempotencyWindow
+	}
+
 	jsonValidator, err := schema.NewValidator([]string{ConfigSchemaJSON}, nil)
 	if err != nil {
 		log.Fatalf("Cannot created json Validator %v", err)
@@ -150,11 +169,16 @@ func New(bb *Builder) *Backend {
 	bb.Router.UseEncodedPath()
 	b := &Backend{
 		config:                   config,
+		configJSON:               bb.Config,
 		db:                       bb.DB,
 		router:                   bb.Router,
 		publicURL:                bb.PublicURL,
 		collectionFunctions:      make(map[string]*collectionFunctions),
 		relations:                make(map[string]string),
+		batchRoutes:              make(map[string]batchRoute),
+		softDeletes:              make(map[string]time.Duration),
+		logColumns:               make(map[string][]string),
+		housekeeping:             make(map[string][]housekeepingTask),
 		Registry:                 registry.New(bb.DB),
 		authorizationEnabled:     bb.AuthorizationEnabled,
 		callbacks:                make(map[string]jobHandler),
@@ -162,6 +186,7 @@ func New(bb *Builder) *Backend {
 		interceptors:             make(map[string]requestHandler),
 		collectionsAndSingletons: make(map[string]bool),
 		pipelineConcurrency:      pipelineConcurrency,
+		idempotencyWindow:        idempotencyWindow,
 		updateSchema:             bb.UpdateSchema,
 	}
 
@@ -231,12 +256,20 @@ func New(bb *Builder) *Backend {
 	logger.AddRequestID(b.router)
 	b.handleCORS()
 	access.HandleAuthorizationRoute(b.router)
+	migrations := b.pendingMigrations()
+	b.migrate(migrations, migrationPhaseSchema)
 	b.handleResourceRoutes()
+	b.migrate(migrations, migrationPhaseData)
 	b.handleStatistics(b.router)
 	b.handleVersion(b.router)
 	b.handleJobs(b.router)
+	b.handleHousekeeping()
+	b.handleIdempotency()
+	b.handleBatch(b.router)
+	b.handleDryRun(b.router)
 	if b.updateSchema {
 		registry.Write("schema_version", newVersion)
+		registry.Write("configuration", json.RawMessage(bb.Config))
 		_, err = b.db.Exec(fmt.Sprintf("SELECT pg_advisory_unlock(%d);", advisoryLock))
 		if err != nil {
 			logger.Default().Fatalf("Cannot release schema update advisory lock %v", err)
@@ -353,6 +386,30 @@ func (b *Backend) handleResourceRoutes() {
 		}
 	}
 
+	// soft deletes apply to a collection and all its children, with the retention of the closest configuration
+	for _, rc := range allResources {
+		if rc.collection == nil || !rc.collection.SoftDelete {
+			continue
+		}
+		retention := defaultSoftDeleteRetention
+		if rc.collection.SoftDeleteRetentionDays > 0 {
+			retention = time.Duration(rc.collection.SoftDeleteRetentionDays) * 24 * time.Hour
+		}
+		for _, rrc := range allResources {
+			var resource string
+			if rrc.collection != nil {
+				resource = rrc.collection.Resource
+			} else if rrc.singleton != nil {
+				resource = rrc.singleton.Resource
+			} else {
+				continue
+			}
+			if resource == rc.collection.Resource || strings.HasPrefix(resource, rc.collection.Resource+"/") {
+				b.softDeletes[resource] = retention
+			}
+		}
+	}
+
 	for _, rc := range allResources {
 		if rc.collection != nil {
 			b.createCollectionResource(router, *rc.collection, false)
@@ -449,6 +506,26 @@ func bytesPlusTotalCountToEtag(b []byte, t int) string {
 	return fmt.Sprintf("\"%x%x\"", sha1.Sum(b), t)
 }
 
+// hasPreconditions returns true if a write request carries If-Match or If-Unmodified-Since
+func hasPreconditions(r *http.Request) bool {
+	return r.Header.Get("If-Match") != "" || r.Header.Get("If-Unmodified-Since") != ""
+}
+
+// preconditionFailed evaluates If-Match and If-Unmodified-Since of a write request against the
+// current etag and modification time of a resource. An empty etag means the resource does not
+// exist, which fails any If-Match. If-Unmodified-Since is ignored when If-Match is present.
+func preconditionFailed(r *http.Request, etag string, lastModified time.Time) bool {
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" {
+		return etag == "" || !ifNoneMatchFound(ifMatch, etag)
+	}
+	if ifUnmodifiedSince := r.Header.Get("If-Unmodified-Since"); ifUnmodifiedSince != "" && etag != "" {
+		t, err := http.ParseTime(ifUnmodifiedSince)
+		// http dates have a resolution of seconds
+		return err == nil && lastModified.Truncate(time.Second).After(t)
+	}
+	return false
+}
+
 // clever recursive function to patch a generic json object.
 func patchObject(object map[string]interface{}, patch map[string]interface{}) {
 
@@ -1215,6 +1215,21 @@ func TestRequestInterceptors(t *testing.T) {
 
 	}
 
+	// the export applies the list interceptor, too
+	var data []byte
+	_, err = client.RawGet("/interceptions/export", &data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	assert.Equal(t, len(list), len(lines))
+	for _, line := range lines {
+		var object Interception
+		if err = json.Unmarshal([]byte(line), &object); err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, "Kilroy was here!", object["interceptor_list"])
+	}
 }
 
 func TestResourceDefaults(t *testing.T) {
@@ -1463,6 +1478,114 @@ func TestPaginationCollection(t *testing.T) {
 
 }
 
+func TestPaginationCursor(t *testing.T) {
+	jsonConfig := `{
+	"collections": [
+	  {
+		"resource": "a"
+	  }
+	]
+  }
+`
+	testService := CreateTestService(jsonConfig, t.Name())
+	defer testService.Db.Close()
+
+	// many items share the same timestamp, so the cursor must break ties on the id
+	numberOfElements := 25
+	timestamp := time.Now().UTC().Round(time.Millisecond)
+	for i := 0; i < numberOfElements; i++ {
+		aNew := A{Foo: strconv.Itoa(i), Timestamp: timestamp.Add(time.Duration(i%3) * time.Second)}
+		if _, err := testService.client.RawPost("/as", &aNew, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, order := range []string{"asc", "desc"} {
+		received := map[uuid.UUID]bool{}
+		path := "/as?limit=10&order=" + order
+		pages := 0
+		var last time.Time
+		for path != "" {
+			var as []A
+			status, h, err := testService.client.RawGetWithHeader(path, map[string]string{}, &as)
+			if err != nil || status != http.StatusOK {
+				t.Fatal("error: ", err, "status: ", status)
+			}
+			pages++
+			for _, a := range as {
+				if received[a.AID] {
+					t.Fatalf("Received the same UUID: %s multiple times", a.AID)
+				}
+				received[a.AID] = true
+				if !last.IsZero() && ((order == "asc" && a.Timestamp.Before(last)) || (order == "desc" && a.Timestamp.After(last))) {
+					t.Fatal("wrong order", a.Timestamp, last)
+				}
+				last = a.Timestamp
+			}
+			if pages > 1 && h.Get("Pagination-Total-Count") != "" {
+				t.Fatal("cursor pages must not carry a total count")
+			}
+			path = ""
+			if cursor := h.Get("Pagination-Next-Cursor"); cursor != "" {
+				path = "/as?limit=10&order=" + order + "&cursor=" + cursor
+			}
+		}
+		if len(received) != numberOfElements {
+			t.Fatalf("Did not get %d elements, only got %d", numberOfElements, len(received))
+		}
+		if pages != 3 {
+			t.Fatalf("Expected 3 pages, got %d", pages)
+		}
+	}
+
+	// the client follows the cursors transparently, and stops after the first short page
+	var all []A
+	pages := 0
+	for page := testService.client.Collection("a").WithParameter("limit", "10").FirstPage(); page.HasData(); page = page.Next() {
+		var onePage []A
+		if _, err := page.Get(&onePage); err != nil {
+			t.Fatal(err)
+		}
+		if len(onePage) == 0 {
+			t.Fatal("unexpected empty page", pages+1)
+		}
+		all = append(all, onePage...)
+		pages++
+	}
+	if len(all) != numberOfElements || pages != 3 {
+		t.Fatalf("Expecting %d items in 3 pages, got %d in %d", numberOfElements, len(all), pages)
+	}
+
+	// skipping the total count
+	_, h, err := testService.client.RawGetWithHeader("/as?count=false", map[string]string{}, &all)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Get("Pagination-Total-Count") != "" || len(all) != numberOfElements {
+		t.Fatal("unexpected response without count")
+	}
+
+	status, _ := testService.client.RawGet("/as?cursor=invalid", &all)
+	if status != http.StatusBadRequest {
+		t.Fatal("expected bad request for invalid cursor, got", status)
+	}
+
+	// a cursor only continues the list it was created for
+	_, h, err = testService.client.RawGetWithHeader("/as?limit=10", map[string]string{}, &all)
+	if err != nil || h.Get("Pagination-Next-Cursor") == "" {
+		t.Fatal("missing cursor", err)
+	}
+	cursor := h.Get("Pagination-Next-Cursor")
+	for _, path := range []string{"/as?limit=10&order=asc&cursor=" + cursor, "/as?limit=10&filter=foo=1&cursor=" + cursor} {
+		if status, _ := testService.client.RawGet(path, &all); status != http.StatusBadRequest {
+			t.Fatal("expected bad request for", path, "got", status)
+		}
+	}
+	if _, err = testService.client.RawGet("/as?limit=5&cursor="+cursor, &all); err != nil {
+		t.Fatal(err)
+	}
+}
+
 func TestPaginationBlob(t *testing.T) {
 	numberOfElements := 10
 	beforeCreation := time.Now().UTC().Add(-time.Second)
@@ -1601,3 +1724,196 @@ func TestScheduleEvents(t *testing.T) {
 	assert.Nil(t, err, "cancel handled event")
 	assert.Equal(t, true, ok, "cancel handled event")
 }
+
+func TestMigrations(t *testing.T) {
+	jsonConfig := `{
+	"collections": [
+	  {
+		"resource": "fleet"
+	  },
+	  {
+		"resource": "fleet/device",
+		"with_log": true,
+		"static_properties": ["sn", "legacy"],
+		"searchable_properties": ["battery"]
+	  },
+	  {
+		"resource": "fleet/device/sensor"
+	  }
+	]
+  }
+`
+	testService := CreateTestService(jsonConfig, t.Name())
+
+	var fleet, device, sensor map[string]interface{}
+	if _, err := testService.client.RawPost("/fleets", map[string]interface{}{}, &fleet); err != nil {
+		t.Fatal(err)
+	}
+	fleetPath := "/fleets/" + fleet["fleet_id"].(string)
+	device = map[string]interface{}{"sn": "1", "legacy": "x", "battery": "42", "model_name": "m1"}
+	if _, err := testService.client.RawPost(fleetPath+"/devices", device, &device); err != nil {
+		t.Fatal(err)
+	}
+	deviceID := device["device_id"].(string)
+	if _, err := testService.client.RawPost(fleetPath+"/devices/"+deviceID+"/sensors", map[string]interface{}{}, &sensor); err != nil {
+		t.Fatal(err)
+	}
+	testService.Db.Close()
+
+	jsonConfig = `{
+	"collections": [
+	  {
+		"resource": "fleet"
+	  },
+	  {
+		"resource": "fleet/thing",
+		"with_log": true,
+		"static_properties": ["serial", "model", "region"],
+		"searchable_properties": [{"name":"battery","type":"integer"}]
+	  },
+	  {
+		"resource": "fleet/thing/sensor"
+	  }
+	],
+	"migrations": [
+	  {
+		"version": 1,
+		"description": "devices become things",
+		"steps": [
+		  {"op": "rename_resource", "resource": "fleet/device", "to": "fleet/thing"},
+		  {"op": "rename_property", "resource": "fleet/thing", "property": "sn", "to": "serial"},
+		  {"op": "retype_property", "resource": "fleet/thing", "property": "battery", "type": "integer"},
+		  {"op": "drop_property", "resource": "fleet/thing", "property": "legacy"}
+		]
+	  },
+	  {
+		"version": 2,
+		"steps": [
+		  {"op": "backfill", "resource": "fleet/thing", "property": "model", "from": "model_name"},
+		  {"op": "backfill", "resource": "fleet/thing", "property": "region", "value": "eu"}
+		]
+	  }
+	]
+  }
+`
+	testService = UpdateTestService(jsonConfig, t.Name())
+	defer testService.Db.Close()
+
+	var thing map[string]interface{}
+	thingPath := fleetPath + "/things/" + deviceID
+	if _, err := testService.client.RawGet(thingPath, &thing); err != nil {
+		t.Fatal(err)
+	}
+	if thing["thing_id"] != deviceID || thing["serial"] != "1" || thing["battery"] != 42.0 ||
+		thing["model"] != "m1" || thing["region"] != "eu" || thing["legacy"] != nil {
+		t.Fatal("unexpected thing:", asJSON(thing))
+	}
+	var sensors []map[string]interface{}
+	if _, err := testService.client.RawGet(thingPath+"/sensors", &sensors); err != nil || len(sensors) != 1 ||
+		sensors[0]["thing_id"] != deviceID || sensors[0]["sensor_id"] != sensor["sensor_id"] {
+		t.Fatal("unexpected sensors:", asJSON(sensors), err)
+	}
+	var log []map[string]interface{}
+	if _, err := testService.client.RawGet(thingPath+"/log", &log); err != nil || len(log) != 1 || log[0]["serial"] != "1" {
+		t.Fatal("unexpected log:", asJSON(log), err)
+	}
+
+	// the indices of the renamed resource are renamed, too, instead of being created a second time
+	rows, err := testService.Db.Query("SELECT indexname FROM pg_indexes WHERE schemaname=$1 AND indexname LIKE '%\\_device\\_%';",
+		strings.ToLower(t.Name()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rows.Next() {
+		var index string
+		rows.Scan(&index)
+		t.Error("index not renamed:", index)
+	}
+	rows.Close()
+	for _, index := range []string{"sort_index_thing_timestamp", "sort_index_thing_log_id", "searchable_property_thing_battery"} {
+		var count int
+		err := testService.Db.QueryRow("SELECT count(*) FROM pg_indexes WHERE schemaname=$1 AND indexname=$2;",
+			strings.ToLower(t.Name()), index).Scan(&count)
+		if err != nil || count != 1 {
+			t.Fatal("unexpected index count:", index, count, err)
+		}
+	}
+
+	// applied migrations are recorded in the registry
+	for _, version := range []string{"1", "2"} {
+		var migration map[string]interface{}
+		timestamp, err := testService.backend.Registry.Accessor("_migrations_").Read(version, &migration)
+		if err != nil || timestamp.IsZero() {
+			t.Fatal("migration not recorded:", version, err)
+		}
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	jsonConfig := `{
+	"collections": [
+	  {
+		"resource": "fleet"
+	  },
+	  {
+		"resource": "fleet/device",
+		"static_properties": ["sn"],
+		"searchable_properties": ["battery"]
+	  }
+	]
+  }
+`
+	testService := CreateTestService(jsonConfig, t.Name())
+	defer testService.Db.Close()
+
+	newConfig := `{
+	"collections": [
+	  {
+		"resource": "fleet"
+	  },
+	  {
+		"resource": "fleet/device",
+		"static_properties": ["sn", "serial"]
+	  },
+	  {
+		"resource": "fleet/gadget",
+		"with_log": true
+	  }
+	]
+  }
+`
+	var plan backend.Plan
+	status, err := testService.client.WithAdminAuthorization().RawPost("/kurbisio/dry-run", []byte(newConfig), &plan)
+	if err != nil || status != http.StatusOK {
+		t.Fatal(status, err)
+	}
+	assert.Equal(t, []string{"fleet/gadget", "fleet/gadget/log"}, plan.Tables.Added)
+	assert.Equal(t, []string{}, plan.Tables.Orphaned)
+	assert.Equal(t, []string{"fleet/device.serial"}, plan.Columns.Added)
+	assert.Equal(t, []string{"fleet/device.battery"}, plan.Columns.Orphaned)
+	assert.Equal(t, []string{}, plan.Indices.Added)
+	assert.Equal(t, []string{"fleet/device.searchable_property_device_battery"}, plan.Indices.Orphaned)
+	assert.Contains(t, plan.Routes.Added, "GET /fleets/{fleet_id}/gadgets")
+	assert.Contains(t, plan.Routes.Added, "POST /fleets/{fleet_id}/gadgets")
+	assert.Equal(t, []int{}, plan.Migrations)
+	var sql []string
+	for _, statement := range plan.SQL {
+		if strings.Contains(statement, "fleet/device") {
+			sql = append(sql, statement)
+		}
+	}
+	assert.Equal(t, []string{`ALTER TABLE TestDryRun."fleet/device" ADD COLUMN IF NOT EXISTS "serial" varchar NOT NULL DEFAULT '';`}, sql)
+
+	// the dry run did not change anything, the Go API compares with the deployed configuration
+	goPlan, err := backend.DryRun(&backend.Builder{Config: newConfig, DB: testService.Db})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, plan, *goPlan)
+
+	// an invalid configuration is rejected
+	status, _ = testService.client.WithAdminAuthorization().RawPost("/kurbisio/dry-run", []byte(`{"collections":[{"resource":"fleet","external_indices":[{"properties":["name"],"scope":"parent"}]}]}`), nil)
+	if status != http.StatusBadRequest {
+		t.Fatal("unexpected status", status)
+	}
+}
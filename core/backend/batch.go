@@ -0,0 +1,253 @@
+// Copyright 2021 Dalarub & Ettrich GmbH - All Rights Reserved
+// Unauthorized copying of this file, via any medium is strictly prohibited
+// Proprietary and confidential
+// info@dalarub.com
+//
+
+package backend
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/goccy/go-json"
+
+	"github.com/gorilla/handlers"
+	"github.com/gorilla/mux"
+	"github.com/relabs-tech/kurbisio/core/logger"
+)
+
+// batchRoute is the kind of route an operation of a batch request can address
+type batchRoute string
+
+const (
+	batchRouteCollection batchRoute = "collection"
+	batchRouteItem       batchRoute = "item"
+	batchRouteRelation   batchRoute = "relation"
+)
+
+// maxBatchOperations limits the number of operations of a single batch request
+const maxBatchOperations = 1000
+
+// batchOperations maps the operations of a batch request to http methods, and to the
+// routes they can be applied to
+var batchOperations = map[string]struct {
+	method string
+	routes []batchRoute
+}{
+	"create":   {http.MethodPost, []batchRoute{batchRouteCollection}},
+	"upsert":   {http.MethodPut, []batchRoute{batchRouteCollection, batchRouteItem}},
+	"patch":    {http.MethodPatch, []batchRoute{batchRouteCollection, batchRouteItem}},
+	"delete":   {http.MethodDelete, []batchRoute{batchRouteItem}},
+	"relate":   {http.MethodPut, []batchRoute{batchRouteRelation}},
+	"unrelate": {http.MethodDelete, []batchRoute{batchRouteRelation}},
+}
+
+// batchReferenceRegexp matches references to the results of previous operations, e.g. {{0.fleet_id}}
+var batchReferenceRegexp = regexp.MustCompile(`\{\{([0-9]+)\.([^{}]+)\}\}`)
+
+// batchOperation is a single operation of a batch request
+type batchOperation struct {
+	Operation string          `json:"operation"`
+	Path      string          `json:"path"`
+	Body      json.RawMessage `json:"body,omitempty"`
+}
+
+// batchResult is the result of a single operation of a batch request
+type batchResult struct {
+	Status int             `json:"status"`
+	Body   json.RawMessage `json:"body,omitempty"`
+}
+
+func (b *Backend) handleBatch(router *mux.Router) {
+	logger.Default().Debugln("batch")
+	logger.Default().Debugln("  handle batch route: /kurbisio/batch POST")
+	router.Handle("/kurbisio/batch", handlers.CompressHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logger.FromContext(r.Context()).Infoln("called route for", r.URL, r.Method)
+		b.batch(w, r)
+	}))).Methods(http.MethodOptions, http.MethodPost)
+}
+
+// batch executes all operations of the request in a single transaction. Each operation is
+// handled by its regular route, hence authorization, validation and interceptors apply as usual.
+func (b *Backend) batch(w http.ResponseWriter, r *http.Request) {
+	rlog := logger.FromContext(r.Context())
+
+	var operations []batchOperation
+	if err := json.NewDecoder(r.Body).Decode(&operations); err != nil {
+		http.Error(w, "invalid json data: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(operations) == 0 || len(operations) > maxBatchOperations {
+		http.Error(w, fmt.Sprintf("a batch needs between 1 and %d operations", maxBatchOperations), http.StatusBadRequest)
+		return
+	}
+
+	// fail reports the failing operation. Nothing of the batch is committed.
+	fail := func(i int, status int, message string) {
+		jsonData, _ := json.MarshalWithOption(map[string]interface{}{
+			"operation": i,
+			"status":    status,
+			"error":     message,
+		}, json.DisableHTMLEscape())
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(status)
+		w.Write(jsonData)
+	}
+
+	tx, err := b.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		rlog.WithError(err).Errorf("Error 4810: BeginTx")
+		http.Error(w, "Error 4810", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+	ctx := contextWithTransaction(r.Context(), tx)
+
+	results := make([]batchResult, 0, len(operations))
+	var objects []interface{} // the decoded response bodies, for references
+	for i, operation := range operations {
+		kind, ok := batchOperations[operation.Operation]
+		if !ok {
+			fail(i, http.StatusBadRequest, "unknown operation '"+operation.Operation+"'")
+			return
+		}
+
+		path, err := substituteBatchReferences(operation.Path, objects, true)
+		if err != nil {
+			fail(i, http.StatusBadRequest, err.Error())
+			return
+		}
+		body := []byte(operation.Body)
+		if len(body) > 0 {
+			var value interface{}
+			decoder := json.NewDecoder(bytes.NewReader(body))
+			decoder.UseNumber()
+			if err = decoder.Decode(&value); err == nil {
+				value, err = substituteBatchValue(value, objects)
+			}
+			if err != nil {
+				fail(i, http.StatusBadRequest, err.Error())
+				return
+			}
+			body, _ = json.MarshalWithOption(value, json.DisableHTMLEscape())
+		}
+
+		pathString := path.(string)
+		if !strings.HasPrefix(pathString, "/") {
+			fail(i, http.StatusBadRequest, "invalid path '"+pathString+"'")
+			return
+		}
+		req, err := http.NewRequestWithContext(ctx, kind.method, pathString, bytes.NewReader(body))
+		if err != nil {
+			fail(i, http.StatusBadRequest, "invalid path '"+pathString+"'")
+			return
+		}
+
+		// only routes which write within the surrounding transaction can be part of a batch
+		var match mux.RouteMatch
+		supported := false
+		if b.router.Match(req, &match) && match.Route != nil {
+			template, _ := match.Route.GetPathTemplate()
+			for _, route := range kind.routes {
+				supported = supported || b.batchRoutes[template] == route
+			}
+		}
+		if !supported {
+			fail(i, http.StatusBadRequest, "operation "+operation.Operation+" is not supported for '"+pathString+"'")
+			return
+		}
+
+		rec := httptest.NewRecorder()
+		b.router.ServeHTTP(rec, req)
+		if rec.Code >= http.StatusMultipleChoices {
+			fail(i, rec.Code, strings.TrimSpace(rec.Body.String()))
+			return
+		}
+
+		result := batchResult{Status: rec.Code}
+		var object interface{}
+		if rec.Body.Len() > 0 {
+			result.Body = rec.Body.Bytes()
+			decoder := json.NewDecoder(bytes.NewReader(result.Body))
+			decoder.UseNumber()
+			decoder.Decode(&object)
+		}
+		results = append(results, result)
+		objects = append(objects, object)
+	}
+
+	if err = tx.Commit(); err != nil {
+		rlog.WithError(err).Errorf("Error 4811: Commit")
+		http.Error(w, "Error 4811", http.StatusInternalServerError)
+		return
+	}
+	// notifications of all operations are committed now
+	b.TriggerJobs()
+
+	jsonData, _ := json.MarshalWithOption(results, json.DisableHTMLEscape())
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonData)
+}
+
+// substituteBatchReferences replaces references like {{0.fleet_id}} in s with the values of
+// the referenced results. If s consists of a single reference, the value is returned as is,
+// otherwise the result is a string. For paths, values are escaped.
+func substituteBatchReferences(s string, objects []interface{}, path bool) (interface{}, error) {
+	var err error
+	resolve := func(match []string) interface{} {
+		n, _ := strconv.Atoi(match[1])
+		if n >= len(objects) {
+			err = fmt.Errorf("reference %s does not point to a previous operation", match[0])
+			return nil
+		}
+		object, _ := objects[n].(map[string]interface{})
+		value := lookupPath(object, strings.Split(match[2], "."))
+		if value == nil {
+			err = fmt.Errorf("reference %s cannot be resolved", match[0])
+		}
+		return value
+	}
+
+	if match := batchReferenceRegexp.FindStringSubmatch(s); !path && match != nil && match[0] == s {
+		value := resolve(match)
+		return value, err
+	}
+	result := batchReferenceRegexp.ReplaceAllStringFunc(s, func(reference string) string {
+		value := csvValue(resolve(batchReferenceRegexp.FindStringSubmatch(reference)))
+		if path {
+			return url.PathEscape(value)
+		}
+		return value
+	})
+	return result, err
+}
+
+// substituteBatchValue replaces references in all strings of a json value
+func substituteBatchValue(value interface{}, objects []interface{}) (interface{}, error) {
+	var err error
+	switch v := value.(type) {
+	case string:
+		return substituteBatchReferences(v, objects, false)
+	case map[string]interface{}:
+		for key, element := range v {
+			if v[key], err = substituteBatchValue(element, objects); err != nil {
+				return nil, err
+			}
+		}
+	case []interface{}:
+		for i, element := range v {
+			if v[i], err = substituteBatchValue(element, objects); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return value, nil
+}
@@ -0,0 +1,153 @@
+// Copyright 2021 Dalarub & Ettrich GmbH - All Rights Reserved
+// Unauthorized copying of this file, via any medium is strictly prohibited
+// Proprietary and confidential
+// info@dalarub.com
+//
+
+package backend_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/relabs-tech/kurbisio/core"
+	"github.com/relabs-tech/kurbisio/core/backend"
+)
+
+func TestBatch(t *testing.T) {
+	jsonConfig := `{
+	"collections": [
+	  {
+		"resource": "fleet",
+		"permits": [
+		  {
+			"role": "userrole",
+			"operations": ["read", "list"]
+		  }
+		]
+	  },
+	  {
+		"resource": "fleet/user"
+	  },
+	  {
+		"resource": "vehicle"
+	  }
+	],
+	"relations": [
+	  {
+		"left": "vehicle",
+		"right": "fleet"
+	  }
+	]
+  }
+`
+	testService := CreateTestService(jsonConfig, t.Name())
+	defer testService.Db.Close()
+
+	var notifications int
+	testService.backend.HandleResourceNotification("fleet", func(ctx context.Context, n backend.Notification) error {
+		notifications++
+		return nil
+	}, core.OperationCreate)
+
+	type result struct {
+		Status int                    `json:"status"`
+		Body   map[string]interface{} `json:"body"`
+	}
+	var results []result
+
+	vehicleID := uuid.New()
+	operations := []map[string]interface{}{
+		{"operation": "create", "path": "/fleets", "body": map[string]interface{}{"name": "f1", "size": 1}},
+		{"operation": "create", "path": "/fleets/{{0.fleet_id}}/users", "body": map[string]interface{}{
+			"name": "u1", "fleet_name": "fleet {{0.name}}", "fleet_size": "{{0.size}}"}},
+		{"operation": "upsert", "path": "/vehicles", "body": map[string]interface{}{"vehicle_id": vehicleID}},
+		{"operation": "relate", "path": "/vehicles/{{2.vehicle_id}}/fleets/{{0.fleet_id}}"},
+		{"operation": "patch", "path": "/fleets/{{0.fleet_id}}", "body": map[string]interface{}{"name": "f2"}},
+	}
+	_, err := testService.client.RawPost("/kurbisio/batch", operations, &results)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 5 || results[0].Status != 201 || results[3].Status != 201 || results[4].Status != 200 {
+		t.Fatal("unexpected results:", asJSON(results))
+	}
+	fleetID := results[0].Body["fleet_id"].(string)
+	if results[1].Body["fleet_id"] != fleetID || results[1].Body["fleet_name"] != "fleet f1" || results[1].Body["fleet_size"] != 1.0 {
+		t.Fatal("unexpected references:", asJSON(results[1]))
+	}
+	var fleets []map[string]interface{}
+	if _, err = testService.client.RawGet("/vehicles/"+vehicleID.String()+"/fleets", &fleets); err != nil {
+		t.Fatal(err)
+	}
+	if len(fleets) != 1 || fleets[0]["name"] != "f2" {
+		t.Fatal("unexpected fleets:", asJSON(fleets))
+	}
+
+	// a failing operation reverts the entire batch, including items an upsert has created
+	otherVehicleID := uuid.New()
+	operations = []map[string]interface{}{
+		{"operation": "create", "path": "/fleets", "body": map[string]interface{}{"name": "f3"}},
+		{"operation": "create", "path": "/fleets/{{0.fleet_id}}/users", "body": map[string]interface{}{"name": "u3"}},
+		{"operation": "upsert", "path": "/vehicles", "body": map[string]interface{}{"vehicle_id": otherVehicleID}},
+		{"operation": "unrelate", "path": "/vehicles/" + vehicleID.String() + "/fleets/" + fleetID},
+		{"operation": "delete", "path": "/fleets/" + uuid.New().String()},
+	}
+	status, err := testService.client.RawPost("/kurbisio/batch", operations, nil)
+	if status != http.StatusNotFound {
+		t.Fatal("expected not found, got", status, err)
+	}
+	if _, err = testService.client.RawGet("/fleets", &fleets); err != nil {
+		t.Fatal(err)
+	}
+	if len(fleets) != 1 {
+		t.Fatal("unexpected fleets:", asJSON(fleets))
+	}
+	var users []map[string]interface{}
+	if _, err = testService.client.RawGet("/fleets/all/users", &users); err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 {
+		t.Fatal("unexpected users:", asJSON(users))
+	}
+	if _, err = testService.client.RawGet("/vehicles/"+vehicleID.String()+"/fleets", &fleets); err != nil || len(fleets) != 1 {
+		t.Fatal("unexpected fleets:", asJSON(fleets), err)
+	}
+	if status, _ := testService.client.RawGet("/vehicles/"+otherVehicleID.String(), nil); status != http.StatusNotFound {
+		t.Fatal("expected not found, got", status)
+	}
+
+	// only the notifications of the successful batch are sent
+	testService.backend.ProcessJobsSync(-1)
+	if notifications != 1 {
+		t.Fatal("unexpected notifications:", notifications)
+	}
+
+	// each operation is authorized individually
+	userClient := testService.clientNoAuth.WithRole("userrole")
+	operations = []map[string]interface{}{
+		{"operation": "create", "path": "/fleets", "body": map[string]interface{}{"name": "f4"}},
+	}
+	if status, _ := userClient.RawPost("/kurbisio/batch", operations, nil); status != http.StatusUnauthorized {
+		t.Fatal("expected unauthorized, got", status)
+	}
+
+	for _, operations := range [][]map[string]interface{}{
+		{},
+		{{"operation": "list", "path": "/fleets"}},
+		{{"operation": "create", "path": "/kurbisio/events/test"}},
+		{{"operation": "delete", "path": "/fleets"}},
+		{{"operation": "create", "path": "/fleets/{{0.fleet_id}}/users"}},
+		{{"operation": "create", "path": "/fleets", "body": map[string]string{}}, {"operation": "create", "path": "/fleets/{{0.unknown}}/users"}},
+	} {
+		if status, _ := testService.client.RawPost("/kurbisio/batch", operations, nil); status != http.StatusBadRequest {
+			t.Fatal("expected bad request for", asJSON(operations), "got", status)
+		}
+	}
+	if _, err = testService.client.RawGet("/fleets", &fleets); err != nil || len(fleets) != 1 {
+		t.Fatal("unexpected fleets:", asJSON(fleets), err)
+	}
+}
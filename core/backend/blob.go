@@ -104,16 +104,29 @@ func (b *Backend) createBlobResource(router *mux.Router, rc blobConfiguration) {
 
 	propertiesEndIndex := len(columns) // where properties end
 
-	// an external index is a mandatory and unique varchar property.
-	if len(rc.ExternalIndex) > 0 {
-		name := rc.ExternalIndex
-		createPropertiesQuery += fmt.Sprintf("ALTER TABLE %s.\"%s\" ADD COLUMN IF NOT EXISTS \"%s\" varchar NOT NULL DEFAULT '';", schema, resource, name)
+	// external indices are mandatory and unique varchar properties, or unique combinations of them. An
+	// external index scoped to the parent is unique within the parent only.
+	externalIndices := externalIndicesOf(rc.ExternalIndex, rc.ExternalIndices)
+	for _, externalIndex := range externalIndices {
+		if err := externalIndex.validate(columns[:propertiesEndIndex], len(dependencies) > 0); err != nil {
+			nillog.Errorf("%s: %v", resource, err)
+			panic("invalid configuration")
+		}
+	external_index_loop:
+		for _, name := range externalIndex.Properties {
+			for i := propertiesEndIndex; i < len(columns); i++ {
+				if columns[i] == name { // shared with another external index
+					continue external_index_loop
+				}
+			}
+			createPropertiesQuery += fmt.Sprintf("ALTER TABLE %s.\"%s\" ADD COLUMN IF NOT EXISTS \"%s\" varchar NOT NULL DEFAULT '';", schema, resource, name)
+			columns = append(columns, name)
+			jsonToHeader[name] = core.PropertyNameToCanonicalHeader(name)
+			searchableColumns = append(searchableColumns, name)
+		}
 		createIndicesQuery = createIndicesQuery + fmt.Sprintf("CREATE UNIQUE index IF NOT EXISTS %s ON %s.\"%s\"(%s);",
-			"external_index_"+this+"_"+name,
-			schema, resource, name)
-		columns = append(columns, name)
-		jsonToHeader[name] = core.PropertyNameToCanonicalHeader(name)
-		searchableColumns = append(searchableColumns, name)
+			"external_index_"+this+"_"+externalIndex.name(),
+			schema, resource, strings.Join(externalIndex.indexColumns(dependencies), ","))
 	}
 
 	// the actual blob data as bytes
@@ -124,7 +137,7 @@ func (b *Backend) createBlobResource(router *mux.Router, rc blobConfiguration) {
 
 	var err error
 	if b.updateSchema {
-		_, err = b.db.Exec(createQuery)
+		err = b.execSchema(createQuery)
 		if err != nil {
 			panic(err)
 		}
@@ -139,6 +152,9 @@ func (b *Backend) createBlobResource(router *mux.Router, rc blobConfiguration) {
 
 	nillog.Debugln("  handle blob routes:", listRoute, "GET,POST,DELETE")
 	nillog.Debugln("  handle blob routes:", itemRoute, "GET,PUT, DELETE")
+	for _, externalIndex := range externalIndices {
+		nillog.Debugln("  handle blob routes:", listRoute+"/"+externalIndex.route(), "GET")
+	}
 
 	readQuery := "SELECT " + strings.Join(columns, ", ") + fmt.Sprintf(", timestamp, blob FROM %s.\"%s\" ", schema, resource)
 	readQueryMeta := "SELECT " + strings.Join(columns, ", ") + fmt.Sprintf(", timestamp FROM %s.\"%s\" ", schema, resource)
@@ -154,6 +170,28 @@ func (b *Backend) createBlobResource(router *mux.Router, rc blobConfiguration) {
 	sqlWhereAll += fmt.Sprintf("($%d OR timestamp<=$%d) AND ($%d OR timestamp>=$%d) ",
 		propertiesIndex, propertiesIndex+1, propertiesIndex+2, propertiesIndex+3)
 
+	// blobs of soft deleted items are hidden together with the items. They come back when the items are restored,
+	// and they are removed when the items are purged.
+	var (
+		parentDeletedQuery string
+		parentIndex        int
+	)
+	if parentResource := strings.Join(dependencies, "/"); len(dependencies) > 0 {
+		if _, ok := b.softDeletes[parentResource]; ok {
+			parentColumn := dependencies[len(dependencies)-1] + "_id"
+			for i := range columns[:propertiesIndex] {
+				if columns[i] == parentColumn {
+					parentIndex = i
+				}
+			}
+			sqlParentNotDeleted := fmt.Sprintf("AND %s NOT IN (SELECT %s FROM %s.\"%s\" WHERE deleted_at IS NOT NULL) ",
+				parentColumn, parentColumn, schema, parentResource)
+			sqlWhereOne += " " + sqlParentNotDeleted
+			sqlWhereAll += sqlParentNotDeleted
+			parentDeletedQuery = fmt.Sprintf("SELECT deleted_at IS NOT NULL FROM %s.\"%s\" WHERE %s=$1;", schema, parentResource, parentColumn)
+		}
+	}
+
 	sqlPagination := fmt.Sprintf("ORDER BY timestamp DESC, %s  DESC LIMIT $%d OFFSET $%d;", columns[0], propertiesIndex+4, propertiesIndex+5)
 
 	sqlWhereAllPlusOneExternalIndex := sqlWhereAll + fmt.Sprintf("AND %%s = $%d ", propertiesIndex+6)
@@ -501,6 +539,60 @@ func (b *Backend) createBlobResource(router *mux.Router, rc blobConfiguration) {
 		read(w, r, nil)
 	}
 
+	// lookupWithAuth reads the blob with the external index values in the path
+	lookupWithAuth := func(w http.ResponseWriter, r *http.Request, externalIndex externalIndexConfiguration) {
+		params := mux.Vars(r)
+		rlog := logger.FromContext(r.Context())
+		if b.authorizationEnabled {
+			auth := access.AuthorizationFromContext(r.Context())
+			if !auth.IsAuthorized(resources, core.OperationRead, params, rc.Permits) {
+				http.Error(w, "not authorized", http.StatusUnauthorized)
+				return
+			}
+		}
+		if externalIndex.Scope == externalIndexScopeParent {
+			parent := dependencies[len(dependencies)-1]
+			if params[parent+"_id"] == "all" {
+				http.Error(w, "all is not a valid "+parent+"_id for a lookup of "+strings.Join(externalIndex.Properties, ","), http.StatusBadRequest)
+				return
+			}
+		}
+
+		sqlQuery := fmt.Sprintf("SELECT %s FROM %s.\"%s\" WHERE ", columns[0], schema, resource)
+		queryParameters := []interface{}{}
+		for i := 1; i < propertiesIndex; i++ {
+			queryParameters = append(queryParameters, params[columns[i]])
+		}
+		if propertiesIndex > 1 {
+			sqlQuery += compareIDsString(columns[1:propertiesIndex]) + " AND "
+		}
+		var compares []string
+		for _, property := range externalIndex.Properties {
+			queryParameters = append(queryParameters, params[property])
+			compares = append(compares, fmt.Sprintf("\"%s\"=$%d", property, len(queryParameters)))
+		}
+		sqlQuery += strings.Join(compares, " AND ") + " LIMIT 1;"
+
+		var id uuid.UUID
+		err := b.db.QueryRowContext(r.Context(), sqlQuery, queryParameters...).Scan(&id)
+		if err == sql.ErrNoRows {
+			http.Error(w, "no such "+this, http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			rlog.WithError(err).Errorf("Error 4820: lookup external index")
+			http.Error(w, "Error 4820", http.StatusInternalServerError)
+			return
+		}
+
+		vars := map[string]string{}
+		for i := 1; i < propertiesIndex; i++ {
+			vars[columns[i]] = params[columns[i]]
+		}
+		vars[columns[0]] = id.String()
+		readWithAuth(w, mux.SetURLVars(r, vars))
+	}
+
 	createWithAuth := func(w http.ResponseWriter, r *http.Request) {
 		rlog := logger.FromContext(r.Context())
 		params := mux.Vars(r)
@@ -602,6 +694,16 @@ func (b *Backend) createBlobResource(router *mux.Router, rc blobConfiguration) {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		if parentDeletedQuery != "" {
+			// blobs of soft deleted items cannot be written
+			var parentDeleted bool
+			tx.QueryRow(parentDeletedQuery, values[parentIndex]).Scan(&parentDeleted)
+			if parentDeleted {
+				tx.Rollback()
+				http.Error(w, "no such "+dependencies[len(dependencies)-1], http.StatusNotFound)
+				return
+			}
+		}
 		var id uuid.UUID
 		err = tx.QueryRow(insertQuery, values...).Scan(&id)
 		if err != nil {
@@ -770,6 +872,40 @@ func (b *Backend) createBlobResource(router *mux.Router, rc blobConfiguration) {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		if parentDeletedQuery != "" {
+			// blobs of soft deleted items cannot be written
+			var parentDeleted bool
+			tx.QueryRow(parentDeletedQuery, values[parentIndex]).Scan(&parentDeleted)
+			if parentDeleted {
+				tx.Rollback()
+				http.Error(w, "no such "+dependencies[len(dependencies)-1], http.StatusNotFound)
+				return
+			}
+		}
+
+		if hasPreconditions(r) {
+			// the etag of a blob is based on its timestamp
+			var current time.Time
+			currentValues, _ := createScanValuesAndObject(&current)
+			err = tx.QueryRow(readQueryMeta+sqlWhereOne+" FOR UPDATE;", values[:propertiesIndex]...).Scan(currentValues...)
+			if err != nil && err != sql.ErrNoRows {
+				tx.Rollback()
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			etag := ""
+			if err == nil {
+				etag = timeToEtag(current)
+			}
+			if preconditionFailed(r, etag, current) {
+				tx.Rollback()
+				if etag != "" {
+					w.Header().Set("Etag", etag)
+				}
+				http.Error(w, "precondition failed", http.StatusPreconditionFailed)
+				return
+			}
+		}
 
 		var primaryID uuid.UUID
 		query := updateQuery
@@ -1036,6 +1172,12 @@ func (b *Backend) createBlobResource(router *mux.Router, rc blobConfiguration) {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		if etag := timeToEtag(timestamp); preconditionFailed(r, etag, timestamp) {
+			tx.Rollback()
+			w.Header().Set("Etag", etag)
+			http.Error(w, "precondition failed", http.StatusPreconditionFailed)
+			return
+		}
 
 		if rc.needsKSS && b.KssDriver != nil {
 			var key string
@@ -1075,6 +1217,15 @@ func (b *Backend) createBlobResource(router *mux.Router, rc blobConfiguration) {
 		createWithAuth(w, r)
 	}).Methods(http.MethodOptions, http.MethodPost)
 
+	// LOOKUP BY EXTERNAL INDEX, must come before READ
+	for _, externalIndex := range externalIndices {
+		externalIndex := externalIndex
+		router.HandleFunc(listRoute+"/"+externalIndex.route(), func(w http.ResponseWriter, r *http.Request) {
+			logger.FromContext(r.Context()).Infoln("called route for", r.URL, r.Method)
+			lookupWithAuth(w, r, externalIndex)
+		}).Methods(http.MethodOptions, http.MethodGet)
+	}
+
 	// READ
 	router.HandleFunc(itemRoute, func(w http.ResponseWriter, r *http.Request) {
 		logger.FromContext(r.Context()).Infoln("called route for", r.URL, r.Method)
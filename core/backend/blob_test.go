@@ -189,6 +189,43 @@ func TestEtagBlobRegenerated(t *testing.T) {
 
 }
 
+// TestIfMatchBlob checks that updates and deletes of a mutable blob obey If-Match
+func TestIfMatchBlob(t *testing.T) {
+	blobData, err := os.ReadFile("./testdata/dalarubettrich.png")
+	header := map[string]string{
+		"Content-Type":       "image/png",
+		"Kurbisio-Meta-Data": `{"hello":"world"}`,
+	}
+	b := Blob{}
+	if _, err = testService.client.RawPostBlob("/blobs", header, blobData, &b); err != nil {
+		t.Fatal(err)
+	}
+	path := "/blobs/" + b.BlobID.String()
+	_, h, err := testService.client.RawGetBlobWithHeader(path, map[string]string{}, &[]byte{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	etag := h.Get("ETag")
+
+	header["If-Match"] = etag
+	if _, err = testService.client.RawPutBlob(path, header, blobData, &b); err != nil {
+		t.Fatal(err)
+	}
+	// the etag has changed with the update
+	if status, _ := testService.client.RawPutBlob(path, header, blobData, &b); status != http.StatusPreconditionFailed {
+		t.Fatal("expected precondition failed, got", status)
+	}
+	if status, _ := testService.client.RawDeleteWithHeader(path, map[string]string{"If-Match": etag}); status != http.StatusPreconditionFailed {
+		t.Fatal("expected precondition failed, got", status)
+	}
+	if _, h, err = testService.client.RawGetBlobWithHeader(path, map[string]string{}, &[]byte{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = testService.client.RawDeleteWithHeader(path, map[string]string{"If-Match": h.Get("ETag")}); err != nil {
+		t.Fatal(err)
+	}
+}
+
 // TestEtagBlobCollectionRegenerated checks that if another element is added to a collection through
 // a POST request, then ETag is modified
 func TestEtagBlobCollectionRegenerated(t *testing.T) {
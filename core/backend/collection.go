@@ -7,8 +7,16 @@
 package backend
 
 import (
+	"bufio"
+	"bytes"
 	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/csv"
 	"fmt"
+	"io"
+	"mime"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -31,6 +39,10 @@ import (
 	"github.com/relabs-tech/kurbisio/core/logger"
 )
 
+// logOperationUpdateProperty is the operation of log entries written by the update of a single static property.
+// All other log entries use the create, update and delete operations.
+const logOperationUpdateProperty = "update_property"
+
 func (b *Backend) createCollectionResource(router *mux.Router, rc collectionConfiguration, singleton bool) {
 	schema := b.db.Schema
 	resource := rc.Resource
@@ -79,6 +91,14 @@ func (b *Backend) createCollectionResource(router *mux.Router, rc collectionConf
 	}
 	dependencies := resources[:len(resources)-1]
 
+	// soft deleted items stay in the table until they are purged, but they are hidden from all
+	// routes except restore and admins asking for include_deleted
+	retention, softDelete := b.softDeletes[resource]
+	sqlNotDeleted := ""
+	if softDelete {
+		sqlNotDeleted = " AND deleted_at IS NULL "
+	}
+
 	createQuery := fmt.Sprintf("CREATE table IF NOT EXISTS %s.\"%s\"", schema, resource)
 	createQueryLog := fmt.Sprintf("CREATE table IF NOT EXISTS %s.\"%s/log\"", schema, resource)
 	var createColumns, createColumnsLog []string
@@ -135,46 +155,149 @@ func (b *Backend) createCollectionResource(router *mux.Router, rc collectionConf
 	createIndicesQueryLog += fmt.Sprintf("CREATE index IF NOT EXISTS %s ON %s.\"%s/log\"(timestamp);",
 		"sort_index_"+primary+"_log_timestamp",
 		schema, resource)
+	// the log records who changed an item, within which request, and how
+	for _, column := range []string{"author", "request_id", "operation"} {
+		createIndicesQueryLog += fmt.Sprintf("ALTER TABLE %s.\"%s/log\" ADD COLUMN IF NOT EXISTS %s varchar NOT NULL DEFAULT '';", schema, resource, column)
+	}
+	createIndicesQueryLog += fmt.Sprintf("CREATE index IF NOT EXISTS %s ON %s.\"%s/log\"(author);",
+		"sort_index_"+primary+"_log_author",
+		schema, resource)
 	propertiesIndex := len(columns) // where properties start
 	columns = append(columns, "properties")
 
 	createPropertiesQuery := ""
+	createPropertiesQueryLog := ""
+
+	// columns which the backend adds for the log, soft delete and full text search cannot be properties
+	reservedColumns := map[string]bool{}
+	if rc.WithLog {
+		for _, column := range []string{"author", "request_id", "operation"} {
+			reservedColumns[column] = true
+		}
+	}
+	if softDelete {
+		reservedColumns["deleted_at"] = true
+	}
+	if len(rc.FullText) > 0 {
+		reservedColumns["fulltext"] = true
+	}
+
+	// propertyTypes holds the type of each typed static or searchable property
+	propertyTypes := map[string]string{}
+	addPropertyColumn := func(property propertyConfiguration) {
+		if reservedColumns[property.Name] {
+			nillog.Errorf("%s: property %s is reserved", resource, property.Name)
+			panic("invalid configuration")
+		}
+		sqlColumn, err := property.sqlColumn()
+		if err != nil {
+			nillog.Errorf("%s: %v", resource, err)
+			panic("invalid configuration")
+		}
+		if property.typed() {
+			propertyTypes[property.Name] = property.Type
+		}
+		createPropertiesQuery += fmt.Sprintf("ALTER TABLE %s.\"%s\" ADD COLUMN IF NOT EXISTS \"%s\" %s;", schema, resource, property.Name, sqlColumn)
+		createPropertiesQueryLog += fmt.Sprintf("ALTER TABLE %s.\"%s/log\" ADD COLUMN IF NOT EXISTS \"%s\" %s;", schema, resource, property.Name, sqlColumn)
+		columns = append(columns, property.Name)
+	}
 
 	staticPropertiesIndex := len(columns) // where static properties start
-	// static properties are varchars
+	// static properties are varchars, unless they declare a type
 	for _, property := range rc.StaticProperties {
-		createPropertiesQuery += fmt.Sprintf("ALTER TABLE %s.\"%s\" ADD COLUMN IF NOT EXISTS \"%s\" varchar NOT NULL DEFAULT '';", schema, resource, property)
-		columns = append(columns, property)
+		addPropertyColumn(property)
 	}
 
-	// static searchable properties are varchars with a non-unique index
+	// static searchable properties are static properties with a non-unique index
 	for _, property := range rc.SearchableProperties {
-		createPropertiesQuery += fmt.Sprintf("ALTER TABLE %s.\"%s\" ADD COLUMN IF NOT EXISTS \"%s\" varchar NOT NULL DEFAULT '';", schema, resource, property)
+		addPropertyColumn(property)
+		name := property.Name
 		createIndicesQuery += fmt.Sprintf("CREATE index IF NOT EXISTS %s ON %s.\"%s\"(%s);",
-			"searchable_property_"+this+"_"+property,
-			schema, resource, property)
+			"searchable_property_"+this+"_"+name,
+			schema, resource, name)
 		createIndicesQueryLog += fmt.Sprintf("CREATE index IF NOT EXISTS %s ON %s.\"%s/log\"(%s);",
-			"searchable_property_"+this+"_"+property,
-			schema, resource, property)
-		columns = append(columns, property)
-		searchableColumns = append(searchableColumns, property)
+			"searchable_property_"+this+"_"+name,
+			schema, resource, name)
+		searchableColumns = append(searchableColumns, name)
 	}
 
 	propertiesEndIndex := len(columns) // where properties end
 
-	// an external index is a unique varchar property.
-	if len(rc.ExternalIndex) > 0 {
-		name := rc.ExternalIndex
-		createPropertiesQuery += fmt.Sprintf("ALTER TABLE %s.\"%s\" ADD COLUMN IF NOT EXISTS \"%s\" varchar NOT NULL DEFAULT '';", schema, resource, name)
-		createIndicesQuery += fmt.Sprintf("CREATE UNIQUE index IF NOT EXISTS %s ON %s.\"%s\"(%s) WHERE %s <> '';",
-			"external_index_"+this+"_"+name,
-			schema, resource, name, name)
+	// soft deleted items are marked with their time of deletion. The column comes first, because the
+	// external indices refer to it.
+	if softDelete {
+		createIndicesQuery += fmt.Sprintf("ALTER TABLE %s.\"%s\" ADD COLUMN IF NOT EXISTS deleted_at timestamp;", schema, resource)
+		createIndicesQuery += fmt.Sprintf("CREATE index IF NOT EXISTS %s ON %s.\"%s\"(deleted_at) WHERE deleted_at IS NOT NULL;",
+			"soft_delete_"+this,
+			schema, resource)
+	}
+
+	// external indices are unique varchar properties, or unique combinations of them. An external
+	// index scoped to the parent is unique within the parent only. Empty values are not indexed, and
+	// neither are soft deleted items, so that their values can be used again.
+	externalIndices := externalIndicesOf(rc.ExternalIndex, rc.ExternalIndices)
+	externalIndexPredicate := func(externalIndex externalIndexConfiguration) string {
+		if softDelete {
+			return externalIndex.nonEmpty() + " AND deleted_at IS NULL"
+		}
+		return externalIndex.nonEmpty()
+	}
+	var replacedExternalIndices []string
+	for _, externalIndex := range externalIndices {
+		if err := externalIndex.validate(columns[:propertiesEndIndex], len(dependencies) > 0); err != nil {
+			nillog.Errorf("%s: %v", resource, err)
+			panic("invalid configuration")
+		}
+	external_index_loop:
+		for _, name := range externalIndex.Properties {
+			if reservedColumns[name] {
+				nillog.Errorf("%s: external index property %s is reserved", resource, name)
+				panic("invalid configuration")
+			}
+			for i := propertiesEndIndex; i < len(columns); i++ {
+				if columns[i] == name { // shared with another external index
+					continue external_index_loop
+				}
+			}
+			createPropertiesQuery += fmt.Sprintf("ALTER TABLE %s.\"%s\" ADD COLUMN IF NOT EXISTS \"%s\" varchar NOT NULL DEFAULT '';", schema, resource, name)
+			createPropertiesQueryLog += fmt.Sprintf("ALTER TABLE %s.\"%s/log\" ADD COLUMN IF NOT EXISTS \"%s\" varchar NOT NULL DEFAULT '';", schema, resource, name)
+			columns = append(columns, name)
+			searchableColumns = append(searchableColumns, name)
+		}
+		indexColumns := strings.Join(externalIndex.indexColumns(dependencies), ",")
+		createIndicesQuery += fmt.Sprintf("CREATE UNIQUE index IF NOT EXISTS %s ON %s.\"%s\"(%s) WHERE %s;",
+			"external_index_"+this+"_"+externalIndex.name(),
+			schema, resource, indexColumns, externalIndexPredicate(externalIndex))
+		if softDelete {
+			replacedExternalIndices = append(replacedExternalIndices, "external_index_"+this+"_"+externalIndex.name())
+		}
 		// the log index is not unique
 		createIndicesQueryLog += fmt.Sprintf("CREATE index IF NOT EXISTS %s ON %s.\"%s/log\"(%s);",
-			"external_index_"+this+"_"+name,
-			schema, resource, name)
-		columns = append(columns, name)
-		searchableColumns = append(searchableColumns, name)
+			"external_index_"+this+"_"+externalIndex.name(),
+			schema, resource, indexColumns)
+	}
+
+	// full text search uses a generated tsvector column with a GIN index. The column is not part
+	// of columns, hence it is neither read nor written explicitly. It only exists in the main table.
+	if len(rc.FullText) > 0 {
+		var documents []string
+		for _, property := range rc.FullText {
+			document := "properties->>'" + strings.ReplaceAll(property, "'", "''") + "'"
+			for i := staticPropertiesIndex; i < len(columns); i++ {
+				if columns[i] == property {
+					document = "\"" + property + "\""
+					if propertyTypes[property] != "" {
+						document = propertyText(propertyTypes[property], document)
+					}
+				}
+			}
+			documents = append(documents, "coalesce("+document+",'')")
+		}
+		createIndicesQuery += fmt.Sprintf("ALTER TABLE %s.\"%s\" ADD COLUMN IF NOT EXISTS fulltext tsvector GENERATED ALWAYS AS (to_tsvector('simple'::regconfig, %s)) STORED;",
+			schema, resource, strings.Join(documents, " || ' ' || "))
+		createIndicesQuery += fmt.Sprintf("CREATE index IF NOT EXISTS %s ON %s.\"%s\" USING GIN (fulltext);",
+			"full_text_"+this,
+			schema, resource)
 	}
 
 	// the "device" collection gets an additional UUID column for the web token
@@ -186,12 +309,21 @@ func (b *Backend) createCollectionResource(router *mux.Router, rc collectionConf
 	createQuery += "(" + strings.Join(createColumns, ", ") + ");" + createPropertiesQuery + createIndicesQuery
 
 	if rc.WithLog {
-		createQuery += createQueryLog + "(" + strings.Join(createColumnsLog, ", ") + ");" + createPropertiesQuery + createIndicesQueryLog
+		createQuery += createQueryLog + "(" + strings.Join(createColumnsLog, ", ") + ");" + createPropertiesQueryLog + createIndicesQueryLog
 	}
 
 	var err error
 	if b.updateSchema {
-		_, err = b.db.Exec(createQuery)
+		// an external index created before soft delete was configured also covers deleted items, it is replaced
+		for _, index := range replacedExternalIndices {
+			var definition string
+			err = b.db.QueryRow("SELECT indexdef FROM pg_indexes WHERE schemaname=$1 AND tablename=$2 AND indexname=$3;",
+				strings.ToLower(schema), resource, strings.ToLower(index)).Scan(&definition)
+			if err == nil && !strings.Contains(definition, "deleted_at") {
+				createQuery = fmt.Sprintf("DROP index %s.%s;", schema, index) + createQuery
+			}
+		}
+		err = b.execSchema(createQuery)
 		if err != nil {
 			nillog.WithError(err).Errorf("Error while updating schema when running: %s", createQuery)
 			panic(fmt.Sprintf("invalid configuration updating: err: %v", err))
@@ -230,18 +362,42 @@ func (b *Backend) createCollectionResource(router *mux.Router, rc collectionConf
 	logRoute := itemRoute + "/log"
 	singletonLogRoute := singletonRoute + "/log"
 
+	// items can be created, updated and deleted within batch requests
+	if singleton {
+		b.batchRoutes[singletonRoute] = batchRouteItem
+	} else {
+		b.batchRoutes[listRoute] = batchRouteCollection
+	}
+	b.batchRoutes[itemRoute] = batchRouteItem
+
 	if singleton {
 		nillog.Debugln("  handle singleton routes:", singletonRoute, "GET,PUT,PATCH,DELETE")
 		nillog.Debugln("  handle singleton routes:", listRoute, "GET,PUT,PATCH,DELETE")
 		nillog.Debugln("  handle singleton routes:", itemRoute, "GET,PUT,PATCH,DELETE")
 		if rc.WithLog {
 			nillog.Debugln("  handle singleton log route:", singletonLogRoute, "GET")
+			nillog.Debugln("  handle singleton log route:", singletonLogRoute+"/diff", "GET")
+			nillog.Debugln("  handle singleton log route:", singletonLogRoute+"/{revision}/restore", "POST")
+		}
+		if softDelete {
+			nillog.Debugln("  handle singleton restore route:", singletonRoute+"/restore", "POST")
 		}
 	} else {
 		nillog.Debugln("  handle collection routes:", listRoute, "GET,POST,PUT,PATCH,DELETE")
 		nillog.Debugln("  handle collection routes:", itemRoute, "GET,PUT,PATCH,DELETE")
+		nillog.Debugln("  handle collection routes:", listRoute+"/aggregate", "GET")
+		nillog.Debugln("  handle collection routes:", listRoute+"/export", "GET")
+		nillog.Debugln("  handle collection routes:", listRoute+"/import", "POST")
+		for _, externalIndex := range externalIndices {
+			nillog.Debugln("  handle collection routes:", listRoute+"/"+externalIndex.route(), "GET,PUT,PATCH")
+		}
 		if rc.WithLog {
 			nillog.Debugln("  handle collection log route:", logRoute, "GET")
+			nillog.Debugln("  handle collection log route:", logRoute+"/diff", "GET")
+			nillog.Debugln("  handle collection log route:", logRoute+"/{revision}/restore", "POST")
+		}
+		if softDelete {
+			nillog.Debugln("  handle collection restore route:", itemRoute+"/restore", "POST")
 		}
 	}
 
@@ -252,10 +408,19 @@ func (b *Backend) createCollectionResource(router *mux.Router, rc collectionConf
 		fmt.Sprintf(", timestamp, revision, count(*) OVER() AS full_count FROM %s.\"%s\" ", schema, resource)
 	readQueryMetaWithTotal := "SELECT " + strings.Join(columns[:propertiesIndex], ", ") +
 		fmt.Sprintf(", timestamp, revision, count(*) OVER() AS full_count FROM %s.\"%s\" ", schema, resource)
+	readQueryMeta := "SELECT " + strings.Join(columns[:propertiesIndex], ", ") +
+		fmt.Sprintf(", timestamp, revision FROM %s.\"%s\" ", schema, resource)
+	sqlFrom := fmt.Sprintf("FROM %s.\"%s\" ", schema, resource)
+	// the latest revisions before a point in time. Deleted items end with a tombstone, hence they are skipped
+	sqlFromAsOf := fmt.Sprintf("FROM (SELECT * FROM (SELECT DISTINCT ON (%s) * FROM %s.\"%s/log\" WHERE timestamp<=$%%d ORDER BY %s,timestamp DESC,revision DESC) AS latest WHERE operation<>'%s') AS history ",
+		columns[0], schema, resource, columns[0], core.OperationDelete)
+	readQueryLog := "SELECT " + strings.Join(columns, ", ") + fmt.Sprintf(", timestamp, revision FROM %s.\"%s/log\" ", schema, resource)
+	// tombstones are the last state of deleted items, they are not revisions which can be read
+	sqlNotTombstone := fmt.Sprintf(" AND operation<>'%s'", core.OperationDelete)
 	readQueryWithTotalLog := "SELECT " + strings.Join(columns, ", ") +
-		fmt.Sprintf(", timestamp, revision, count(*) OVER() AS full_count FROM %s.\"%s/log\" ", schema, resource)
+		fmt.Sprintf(", timestamp, revision, author, request_id, operation, count(*) OVER() AS full_count FROM %s.\"%s/log\" ", schema, resource)
 	readQueryMetaWithTotalLog := "SELECT " + strings.Join(columns[:propertiesIndex], ", ") +
-		fmt.Sprintf(", timestamp, revision, count(*) OVER() AS full_count FROM %s.\"%s/log\" ", schema, resource)
+		fmt.Sprintf(", timestamp, revision, author, request_id, operation, count(*) OVER() AS full_count FROM %s.\"%s/log\" ", schema, resource)
 	sqlWhereAll := "WHERE "
 	if propertiesIndex > ownerIndex {
 		sqlWhereAll += compareIDsString(columns[ownerIndex:propertiesIndex]) + " AND "
@@ -268,6 +433,48 @@ func (b *Backend) createCollectionResource(router *mux.Router, rc collectionConf
 	sqlPaginationAsc := fmt.Sprintf("ORDER BY timestamp ASC,%s ASC,revision ASC LIMIT $%d OFFSET $%d;",
 		columns[0], propertiesIndex-ownerIndex+1+4, propertiesIndex-ownerIndex+1+5)
 
+	// the list query builds its parameters dynamically, hence it needs the order clauses
+	// without the limit and offset parameters
+	sqlOrderDesc := fmt.Sprintf("ORDER BY timestamp DESC,%s DESC,revision DESC ", columns[0])
+	sqlOrderAsc := fmt.Sprintf("ORDER BY timestamp ASC,%s ASC,revision ASC ", columns[0])
+
+	// identifiers, static and searchable properties and the external index can be
+	// filtered directly, only searchable columns can be searched
+	columnFilter := &filterCompiler{columns: map[string]string{}, searchable: map[string]bool{}, propertyTypes: propertyTypes}
+	for i, column := range columns {
+		switch {
+		case i < propertiesIndex:
+			columnFilter.columns[column] = "uuid"
+		case i > propertiesIndex && propertyTypes[column] != "":
+			columnFilter.columns[column] = propertySQLTypes[propertyTypes[column]]
+		case i > propertiesIndex:
+			columnFilter.columns[column] = "varchar"
+		}
+	}
+	for _, column := range searchableColumns {
+		columnFilter.searchable[column] = true
+	}
+
+	// a projection with the fields query parameter always keeps the identifiers, the timestamp and the revision
+	keepFields := map[string]bool{"timestamp": true, "revision": true}
+	for i := 0; i < propertiesIndex; i++ {
+		keepFields[columns[i]] = true
+	}
+	// log entries also keep who changed them, and how
+	keepLogFields := map[string]bool{"_log": true}
+	for field := range keepFields {
+		keepLogFields[field] = true
+	}
+
+	// all columns besides properties can be sorted on directly, everything else is
+	// sorted on the first level of the properties json
+	sortableColumns := map[string]bool{"timestamp": true, "revision": true}
+	for i, column := range columns {
+		if i != propertiesIndex {
+			sortableColumns[column] = true
+		}
+	}
+
 	clearQuery := fmt.Sprintf("DELETE FROM %s.\"%s\" ", schema, resource)
 
 	deleteQuery := fmt.Sprintf("DELETE FROM %s.\"%s\" ", schema, resource)
@@ -276,10 +483,68 @@ func (b *Backend) createCollectionResource(router *mux.Router, rc collectionConf
 
 	insertQuery := fmt.Sprintf("INSERT INTO %s.\"%s\" ", schema, resource) + "(" + strings.Join(columns, ", ") + ", timestamp)"
 	insertQuery += "VALUES(" + parameterString(len(columns)+1) + ")"
+	// an insert by external index does nothing on a conflict with that index, the upsert then updates the existing item instead
+	insertQueryByExternalIndex := map[string]string{}
+	for _, externalIndex := range externalIndices {
+		insertQueryByExternalIndex[externalIndex.name()] = insertQuery + fmt.Sprintf(" ON CONFLICT (%s) WHERE %s DO NOTHING RETURNING %s_id;",
+			strings.Join(externalIndex.indexColumns(dependencies), ","), externalIndexPredicate(externalIndex), primary)
+	}
 	insertQuery += " RETURNING " + primary + "_id;"
 
-	insertQueryLog := fmt.Sprintf("INSERT INTO %s.\"%s/log\" ", schema, resource) + "(" + strings.Join(columns, ", ") + ", timestamp, revision)"
-	insertQueryLog += "VALUES(" + parameterString(len(columns)+2) + ")"
+	insertQueryLog := fmt.Sprintf("INSERT INTO %s.\"%s/log\" ", schema, resource) + "(" + strings.Join(columns, ", ") + ", timestamp, revision, author, request_id, operation)"
+	insertQueryLog += "VALUES(" + parameterString(len(columns)+5) + ")"
+
+	// logValues appends the author, the request id and the operation to the scanned values of an item
+	logValues := func(ctx context.Context, values []interface{}, operation string) []interface{} {
+		return append(values[:len(values):len(values)], access.IdentityFromContext(ctx), logger.RequestIDFromContext(ctx), operation)
+	}
+
+	// a deleted item leaves a tombstone in the log, which is its last state with the next revision.
+	// The query is completed with a where clause selecting the deleted items.
+	tombstoneQueryLog := fmt.Sprintf("INSERT INTO %s.\"%s/log\" ", schema, resource) + "(" + strings.Join(columns, ", ") + ", timestamp, revision, author, request_id, operation) "
+	tombstoneQueryLog += "SELECT " + strings.Join(columns, ", ") + fmt.Sprintf(", $%%d, revision + 1, $%%d, $%%d, '%s' FROM %s.\"%s\" ", core.OperationDelete, schema, resource)
+
+	if rc.WithLog {
+		b.logColumns[resource] = columns
+	}
+
+	// loggedChildren returns the children with log. Children are created after their parent, hence they are
+	// looked up when needed.
+	loggedChildren := func() []string {
+		var children []string
+		for child := range b.logColumns {
+			if strings.HasPrefix(child, resource+"/") {
+				children = append(children, child)
+			}
+		}
+		sort.Strings(children)
+		return children
+	}
+
+	// writeTombstones writes the tombstones for the items selected by where and queryParameters, and for
+	// their children with log. Children which are soft deleted already have their tombstone.
+	writeTombstones := func(ctx context.Context, tx transaction, where string, queryParameters []interface{}) error {
+		n := len(queryParameters)
+		parameters := append(queryParameters[:n:n], time.Now().UTC(), access.IdentityFromContext(ctx), logger.RequestIDFromContext(ctx))
+		if rc.WithLog {
+			if _, err := tx.Exec(fmt.Sprintf(tombstoneQueryLog, n+1, n+2, n+3)+where, parameters...); err != nil {
+				return err
+			}
+		}
+		for _, child := range loggedChildren() {
+			childColumns := strings.Join(b.logColumns[child], ", ")
+			query := fmt.Sprintf("INSERT INTO %s.\"%s/log\" (%s, timestamp, revision, author, request_id, operation) ", schema, child, childColumns) +
+				fmt.Sprintf("SELECT %s, $%d, revision + 1, $%d, $%d, '%s' FROM %s.\"%s\" ", childColumns, n+1, n+2, n+3, core.OperationDelete, schema, child) +
+				fmt.Sprintf("WHERE %s_id IN (SELECT %s_id FROM %s.\"%s\" %s)", primary, primary, schema, resource, where)
+			if _, ok := b.softDeletes[child]; ok {
+				query += " AND deleted_at IS NULL"
+			}
+			if _, err := tx.Exec(query, parameters...); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
 
 	updateQuery := fmt.Sprintf("UPDATE %s.\"%s\" SET ", schema, resource)
 	sets := make([]string, len(columns)-propertiesIndex)
@@ -287,15 +552,54 @@ func (b *Backend) createCollectionResource(router *mux.Router, rc collectionConf
 		sets[i-propertiesIndex] = columns[i] + " = $" + strconv.Itoa(i+1)
 	}
 	updateQuery += strings.Join(sets, ", ") + ", timestamp = $" + strconv.Itoa(len(columns)+1)
-	updateQuery += ", revision = revision + 1 " + sqlWhereOne + " RETURNING " + primary + "_id;"
+	updateQuery += ", revision = revision + 1 " + sqlWhereOne + sqlNotDeleted + " RETURNING " + primary + "_id;"
 
 	updatePropertyQuery := fmt.Sprintf("UPDATE %s.\"%s\" SET ", schema, resource)
 	updatePropertyQuery += " %s = $" + strconv.Itoa(propertiesIndex+1)
-	updatePropertyQuery += ", revision = revision + 1 " + sqlWhereOne + " RETURNING " + primary + "_id;"
+	updatePropertyQuery += ", revision = revision + 1 " + sqlWhereOne + sqlNotDeleted + " RETURNING " + primary + "_id;"
 
 	var singletonParentExistsQuery string
 	if singleton {
-		singletonParentExistsQuery = fmt.Sprintf("SELECT %s_id FROM %s.\"%s\" WHERE %s_id = $1;", owner, schema, ownerResource, owner)
+		singletonParentExistsQuery = fmt.Sprintf("SELECT %s_id FROM %s.\"%s\" WHERE %s_id = $1%s;", owner, schema, ownerResource, owner, sqlNotDeleted)
+	}
+
+	// soft deletes mark the item, and all children which are not deleted yet, with the same time.
+	// Restore brings back the children which have been deleted together with the item. Both
+	// deletion and restore of the item and its children are new revisions, matching the entries
+	// in the log.
+	var (
+		softDeleteQuery           string
+		softDeleteChildrenQueries []string
+		restoreQueries            []string
+		softDeletedChildren       []string
+		parentDeletedQuery        string
+		purgeQuery                string
+	)
+	if softDelete {
+		softDeleteQuery = fmt.Sprintf("UPDATE %s.\"%s\" SET deleted_at=$%%d, revision = revision + 1 ", schema, resource)
+		var children []string
+		for child := range b.softDeletes {
+			if strings.HasPrefix(child, resource+"/") {
+				children = append(children, child)
+			}
+		}
+		sort.Strings(children)
+		for _, child := range children {
+			softDeleteChildrenQueries = append(softDeleteChildrenQueries,
+				fmt.Sprintf("UPDATE %s.\"%s\" SET deleted_at=$1, revision = revision + 1 WHERE deleted_at IS NULL AND %s_id IN (SELECT %s_id FROM %s.\"%s\" WHERE deleted_at=$1);",
+					schema, child, primary, primary, schema, resource))
+		}
+		softDeletedChildren = children
+		restoreQueries = []string{
+			fmt.Sprintf("SELECT deleted_at FROM %s.\"%s\" ", schema, resource) + sqlWhereOne + " AND deleted_at IS NOT NULL FOR UPDATE;",
+			fmt.Sprintf("UPDATE %s.\"%s\" SET deleted_at=NULL, revision = revision + 1 ", schema, resource) + sqlWhereOne + sqlReturnObject + ";",
+		}
+		parentResource := strings.Join(dependencies, "/")
+		if _, ok := b.softDeletes[parentResource]; ok && len(dependencies) > 0 {
+			parentColumn := dependencies[len(dependencies)-1] + "_id"
+			parentDeletedQuery = fmt.Sprintf("SELECT deleted_at IS NOT NULL FROM %s.\"%s\" WHERE %s=$1;", schema, parentResource, parentColumn)
+		}
+		purgeQuery = fmt.Sprintf("DELETE FROM %s.\"%s\" WHERE deleted_at < $1", schema, resource)
 	}
 
 	createScanValuesAndObject := func(timestamp *time.Time, revision *int, extra ...interface{}) ([]interface{}, map[string]interface{}) {
@@ -311,10 +615,13 @@ func (b *Backend) createCollectionResource(router *mux.Router, rc collectionConf
 		i++
 
 		for ; i < len(columns); i++ {
-			str := ""
-			values[i] = &str
+			if propertyTypes[columns[i]] != "" {
+				values[i] = &propertyValue{}
+			} else {
+				str := ""
+				values[i] = &str
+			}
 			object[columns[i]] = values[i]
-
 		}
 
 		values[i] = timestamp
@@ -371,34 +678,85 @@ func (b *Backend) createCollectionResource(router *mux.Router, rc collectionConf
 		}
 	}
 
+	// itemEtag returns the Etag the read route returns for object, which must have merged properties.
+	// Writes need it to evaluate If-Match.
+	itemEtag := func(ctx context.Context, object map[string]interface{}, selectors map[string]string) (string, error) {
+		primaryID := *object[columns[0]].(*uuid.UUID)
+		if rc.Default != nil {
+			var defaultJSON map[string]interface{}
+			json.Unmarshal(rc.Default, &defaultJSON)
+			patchObject(defaultJSON, object)
+			object = defaultJSON
+		}
+		jsonData, _ := json.MarshalWithOption(object, json.DisableHTMLEscape())
+		data, err := b.intercept(ctx, resource, core.OperationRead, primaryID, selectors, nil, jsonData)
+		if err != nil {
+			return "", err
+		}
+		if data != nil {
+			jsonData = data
+		}
+		return bytesToEtag(jsonData), nil
+	}
+
+	// parseIncludeDeleted parses the include_deleted query parameter of read and list
+	parseIncludeDeleted := func(value string) (bool, error) {
+		includeDeleted, err := strconv.ParseBool(value)
+		if err == nil && includeDeleted && !softDelete {
+			err = fmt.Errorf("no soft delete configured for %s", this)
+		}
+		return includeDeleted, err
+	}
+
+	// includeDeletedAuthorized returns true if the request may see soft deleted items, which is reserved for admins
+	includeDeletedAuthorized := func(r *http.Request) bool {
+		return !b.authorizationEnabled || access.AuthorizationFromContext(r.Context()).HasRole("admin")
+	}
+
+	// withDeletedAt adds the deletion time of soft deleted items to the properties selected by query
+	withDeletedAt := func(query string) string {
+		return strings.Replace(query, ", properties", `, CASE WHEN deleted_at IS NULL THEN properties ELSE
+(properties::jsonb || jsonb_build_object('deleted_at', to_char(deleted_at, 'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')))::json END`, 1)
+	}
+
 	list := func(w http.ResponseWriter, r *http.Request, relation *relationInjection) {
 		var (
-			queryParameters     []interface{}
-			sqlQuery            string
-			limit               int = 100
-			page                int = 1
-			until               time.Time
-			from                time.Time
-			externalColumns     []string
-			externalValues      []string
-			externalOperators   []string
-			filterJSONColumns   []string
-			filterJSONValues    []string
-			filterJSONOperators []string
-			ascendingOrder      bool
-			metaonly            bool
-			err                 error
+			queryParameters []interface{}
+			sqlQuery        string
+			limit           int = 100
+			page            int = 1
+			until           time.Time
+			from            time.Time
+			filter          collectionFilter
+			ascendingOrder  bool
+			metaonly        bool
+			withTotalCount  bool = true
+			cursor          *paginationCursor
+			sortTerms       []sortTerm
+			fields          [][]string
+			fullTextQuery   string
+			includeDeleted  bool
+			asOf            time.Time
+			err             error
 		)
 		urlQuery := r.URL.Query()
 		parameters := map[string]string{}
 		var withCompanionUrls bool
 		for key, array := range urlQuery {
-			if key != "filter" && len(array) > 1 {
+			isFilter, err := filter.parse(key, array)
+			if err != nil {
+				http.Error(w, "parameter '"+key+"': "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			if isFilter {
+				parameters[key] = array[0]
+				continue
+			}
+			if len(array) > 1 {
 				http.Error(w, "illegal parameter array '"+key+"'", http.StatusBadRequest)
 				return
 			}
 			value := array[0]
-		switchStatement:
 			switch key {
 			case "limit":
 				limit, err = strconv.Atoi(value)
@@ -416,45 +774,6 @@ func (b *Backend) createCollectionResource(router *mux.Router, rc collectionConf
 			case "from":
 				from, err = time.Parse(time.RFC3339, value)
 
-			case "filter", "search":
-				for _, value := range array {
-					var operator string
-					i := strings.IndexRune(value, '=')
-					if i < 0 {
-						i = strings.IndexRune(value, '~')
-						if i < 0 {
-							err = fmt.Errorf("cannot parse filter, must be of type property=value or property~value")
-							break
-						} else {
-							operator = " LIKE "
-						}
-					} else {
-						operator = "="
-					}
-					filterKey := value[:i]
-					filterValue := value[i+1:]
-
-					found := false
-					for _, searchableColumn := range searchableColumns {
-						if filterKey == searchableColumn {
-							externalValues = append(externalValues, filterValue)
-							externalColumns = append(externalColumns, filterKey)
-							found = true
-							externalOperators = append(externalOperators, operator)
-							break
-						}
-					}
-					// This was not a search inside a columns, then we try to search in the json document
-					if !found {
-						if key == "search" {
-							err = fmt.Errorf("unknown search property '%s'", filterKey)
-							break switchStatement
-						}
-						filterJSONValues = append(filterJSONValues, filterValue)
-						filterJSONColumns = append(filterJSONColumns, filterKey)
-						filterJSONOperators = append(filterJSONOperators, operator)
-					}
-				}
 			case "order":
 				if value != "asc" && value != "desc" {
 					err = fmt.Errorf("order must be asc or desc")
@@ -462,6 +781,26 @@ func (b *Backend) createCollectionResource(router *mux.Router, rc collectionConf
 				}
 				ascendingOrder = (value == "asc")
 
+			case "sort":
+				sortTerms, err = parseSortTerms(value)
+
+			case "fields":
+				fields, err = parseFields(value)
+
+			case "cursor":
+				cursor, err = decodePaginationCursor(value)
+
+			case "count":
+				withTotalCount, err = strconv.ParseBool(value)
+
+			case "q":
+				fullTextQuery = value
+				if len(rc.FullText) == 0 {
+					err = fmt.Errorf("no full text search configured for %s", this)
+				} else if strings.TrimSpace(value) == "" {
+					err = fmt.Errorf("empty query")
+				}
+
 			case "metaonly":
 				metaonly, err = strconv.ParseBool(array[0])
 				if err != nil {
@@ -476,6 +815,15 @@ func (b *Backend) createCollectionResource(router *mux.Router, rc collectionConf
 					return
 				}
 
+			case "include_deleted":
+				includeDeleted, err = parseIncludeDeleted(value)
+
+			case "as_of":
+				asOf, err = time.Parse(time.RFC3339, value)
+				if err == nil && !rc.WithLog {
+					err = fmt.Errorf("%s has no log", this)
+				}
+
 			default:
 				err = fmt.Errorf("unknown")
 			}
@@ -487,31 +835,76 @@ func (b *Backend) createCollectionResource(router *mux.Router, rc collectionConf
 				return
 			}
 		}
+		if includeDeleted && !includeDeletedAuthorized(r) {
+			http.Error(w, "not authorized", http.StatusUnauthorized)
+			return
+		}
+		if !asOf.IsZero() {
+			// the log has neither deleted items nor a full text index
+			for _, key := range []string{"include_deleted", "q"} {
+				if _, ok := parameters[key]; ok {
+					http.Error(w, "parameter '"+key+"': cannot be combined with as_of", http.StatusBadRequest)
+					return
+				}
+			}
+		}
+		scope := paginationScope(mux.Vars(r), urlQuery)
+		if cursor != nil {
+			if _, ok := parameters["page"]; ok {
+				http.Error(w, "parameter 'cursor': cannot be combined with page", http.StatusBadRequest)
+				return
+			}
+			if cursor.Ascending != ascendingOrder {
+				http.Error(w, "parameter 'cursor': the order does not match the cursor", http.StatusBadRequest)
+				return
+			}
+			if cursor.Scope != scope {
+				http.Error(w, "parameter 'cursor': the filters do not match the cursor", http.StatusBadRequest)
+				return
+			}
+			// a total count relative to a cursor position makes no sense
+			withTotalCount = false
+		}
+		if len(sortTerms) > 0 {
+			// cursors and order are bound to the default (timestamp, id, revision) ordering
+			for _, key := range []string{"cursor", "order"} {
+				if _, ok := parameters[key]; ok {
+					http.Error(w, "parameter '"+key+"': cannot be combined with sort", http.StatusBadRequest)
+					return
+				}
+			}
+		}
+		if fullTextQuery != "" {
+			// results are ordered by relevance, which does not match cursors or the timestamp order
+			for _, key := range []string{"cursor", "order"} {
+				if _, ok := parameters[key]; ok {
+					http.Error(w, "parameter '"+key+"': cannot be combined with q", http.StatusBadRequest)
+					return
+				}
+			}
+		}
 		params := mux.Vars(r)
 		selectors := map[string]string{}
 		for i := ownerIndex; i < propertiesIndex; i++ { // skip ID
 			selectors[columns[i]] = params[columns[i]]
 		}
-		if metaonly {
+		switch {
+		case metaonly && withTotalCount:
 			sqlQuery = readQueryMetaWithTotal
-		} else {
+		case metaonly:
+			sqlQuery = readQueryMeta
+		case withTotalCount:
 			sqlQuery = readQueryWithTotal
+		default:
+			sqlQuery = readQuery
 		}
 		sqlQuery += sqlWhereAll
-		if len(externalValues) == 0 && len(filterJSONValues) == 0 { // no filter(s), get entire collection
-			queryParameters = make([]interface{}, propertiesIndex-ownerIndex+6)
-		} else {
-			queryParameters = make([]interface{}, propertiesIndex-ownerIndex+6+len(externalValues)+len(filterJSONValues))
-			for i := range externalValues {
-				sqlQuery += fmt.Sprintf("AND (%s%s$%d) ", externalColumns[i], externalOperators[i], propertiesIndex-ownerIndex+7+i)
-				queryParameters[propertiesIndex-ownerIndex+6+i] = externalValues[i]
-			}
-			for i := range filterJSONValues {
-				sqlQuery += fmt.Sprintf("AND (properties->>'%s'%s$%d) ", filterJSONColumns[i], filterJSONOperators[i], propertiesIndex-ownerIndex+7+i+len(externalValues))
-				queryParameters[propertiesIndex-ownerIndex+6+i+len(externalValues)] = filterJSONValues[i]
-			}
+		if includeDeleted {
+			sqlQuery = withDeletedAt(sqlQuery)
+		} else if asOf.IsZero() {
+			sqlQuery += sqlNotDeleted
 		}
-
+		queryParameters = make([]interface{}, propertiesIndex-ownerIndex+4)
 		for i := ownerIndex; i < propertiesIndex; i++ { // skip ID
 			queryParameters[i-ownerIndex] = params[columns[i]]
 		}
@@ -519,8 +912,38 @@ func (b *Backend) createCollectionResource(router *mux.Router, rc collectionConf
 		queryParameters[propertiesIndex-ownerIndex+1] = until.UTC()
 		queryParameters[propertiesIndex-ownerIndex+2] = from.IsZero()
 		queryParameters[propertiesIndex-ownerIndex+3] = from.UTC()
-		queryParameters[propertiesIndex-ownerIndex+4] = limit
-		queryParameters[propertiesIndex-ownerIndex+5] = (page - 1) * limit
+
+		conditions, err := filter.compile(columnFilter, &queryParameters)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		sqlQuery += conditions
+
+		if !asOf.IsZero() {
+			// the state at as_of is the latest revision of each item which was written before as_of
+			queryParameters = append(queryParameters, asOf.UTC())
+			sqlQuery = strings.Replace(sqlQuery, sqlFrom, fmt.Sprintf(sqlFromAsOf, len(queryParameters)), 1)
+		}
+
+		fullTextRank := ""
+		if fullTextQuery != "" {
+			queryParameters = append(queryParameters, fullTextQuery)
+			tsQuery := fmt.Sprintf("websearch_to_tsquery('simple'::regconfig, $%d)", len(queryParameters))
+			sqlQuery += "AND (fulltext @@ " + tsQuery + ") "
+			fullTextRank = "ts_rank(fulltext, " + tsQuery + ")"
+		}
+
+		if cursor != nil {
+			// keyset pagination: continue right after the item the cursor points to
+			operator := "<"
+			if ascendingOrder {
+				operator = ">"
+			}
+			queryParameters = append(queryParameters, cursor.Timestamp, cursor.ID, cursor.Revision)
+			n := len(queryParameters)
+			sqlQuery += fmt.Sprintf("AND ((timestamp,%s,revision)%s($%d,$%d,$%d)) ", columns[0], operator, n-2, n-1, n)
+		}
 
 		if relation != nil {
 			// inject subquery for relation
@@ -529,12 +952,41 @@ func (b *Backend) createCollectionResource(router *mux.Router, rc collectionConf
 			queryParameters = append(queryParameters, relation.queryParameters...)
 		}
 
-		if ascendingOrder {
-			sqlQuery += sqlPaginationAsc
-
+		if len(sortTerms) > 0 {
+			var orderBy []string
+			for _, term := range sortTerms {
+				var expression string
+				if sortableColumns[term.property] {
+					expression = term.property
+				} else {
+					// jsonb compares numbers numerically and strings lexically
+					expression, err = jsonProperty(term.property, false, &queryParameters)
+					if err != nil {
+						http.Error(w, "parameter 'sort': "+err.Error(), http.StatusBadRequest)
+						return
+					}
+					expression = "(" + expression + ")::jsonb"
+				}
+				if term.descending {
+					expression += " DESC"
+				} else {
+					expression += " ASC"
+				}
+				orderBy = append(orderBy, expression)
+			}
+			// the primary id makes the order stable
+			sqlQuery += "ORDER BY " + strings.Join(orderBy, ",") + "," + columns[0] + " ASC "
+		} else if fullTextRank != "" {
+			// most relevant first, the primary id makes the order stable
+			sqlQuery += "ORDER BY " + fullTextRank + " DESC," + columns[0] + " ASC "
+		} else if ascendingOrder {
+			sqlQuery += sqlOrderAsc
 		} else {
-			sqlQuery += sqlPaginationDesc
+			sqlQuery += sqlOrderDesc
 		}
+		queryParameters = append(queryParameters, limit, (page-1)*limit)
+		limitIndex := len(queryParameters) - 2
+		sqlQuery += fmt.Sprintf("LIMIT $%d OFFSET $%d;", limitIndex+1, limitIndex+2)
 
 		// fmt.Printf("\n\nQUERY %#v parameters: %#v\n\n", sqlQuery, queryParameters)
 		rows, err := b.db.Query(sqlQuery, queryParameters...)
@@ -547,15 +999,22 @@ func (b *Backend) createCollectionResource(router *mux.Router, rc collectionConf
 		response := []interface{}{}
 		defer rows.Close()
 		var totalCount int
+		var nextCursor paginationCursor
 		for rows.Next() {
 			var timestamp time.Time
-			values, object := createScanValuesAndObjectWithMeta(metaonly, &timestamp, new(int), &totalCount)
+			var revision int
+			var extra []interface{}
+			if withTotalCount {
+				extra = append(extra, &totalCount)
+			}
+			values, object := createScanValuesAndObjectWithMeta(metaonly, &timestamp, &revision, extra...)
 			err := rows.Scan(values...)
 			if err != nil {
 				nillog.WithError(err).Errorf("Error 4725: cannot scan values")
 				http.Error(w, "Error 4725", http.StatusInternalServerError)
 				return
 			}
+			nextCursor = paginationCursor{Timestamp: timestamp, ID: *values[0].(*uuid.UUID), Revision: revision, Ascending: ascendingOrder, Scope: scope}
 			if !metaonly {
 				var uploadURL string
 				if rc.WithCompanionFile && withCompanionUrls && b.KssDriver != nil {
@@ -606,12 +1065,20 @@ func (b *Backend) createCollectionResource(router *mux.Router, rc collectionConf
 		if data != nil {
 			jsonData = data
 		}
+		if fields != nil {
+			jsonData, err = projectJSON(jsonData, fields, keepFields)
+			if err != nil {
+				nillog.WithError(err).Errorf("Error 4801: cannot project fields")
+				http.Error(w, "Error 4801", http.StatusInternalServerError)
+				return
+			}
+		}
 
-		if page > 0 && totalCount == 0 {
+		if withTotalCount && page > 0 && totalCount == 0 {
 			// sql does not return total count if we ask beyond limits, hence
 			// we need a second query
-			queryParameters[propertiesIndex-ownerIndex+4] = 1
-			queryParameters[propertiesIndex-ownerIndex+5] = 0
+			queryParameters[limitIndex] = 1
+			queryParameters[limitIndex+1] = 0
 			rows, err := b.db.Query(sqlQuery, queryParameters...)
 			if err != nil {
 				nillog.WithError(err).Errorf("Error 4722: cannot execute query `%s` %v", sqlQuery, queryParameters)
@@ -621,7 +1088,7 @@ func (b *Backend) createCollectionResource(router *mux.Router, rc collectionConf
 			defer rows.Close()
 			for rows.Next() {
 				var timestamp time.Time
-				values, _ := createScanValuesAndObject(&timestamp, new(int), &totalCount)
+				values, _ := createScanValuesAndObjectWithMeta(metaonly, &timestamp, new(int), &totalCount)
 				err := rows.Scan(values...)
 				if err != nil {
 					nillog.WithError(err).Errorf("Error 4725: cannot scan values")
@@ -633,9 +1100,17 @@ func (b *Backend) createCollectionResource(router *mux.Router, rc collectionConf
 
 		w.Header().Set("Content-Type", "application/json; charset=utf-8")
 		w.Header().Set("Pagination-Limit", strconv.Itoa(limit))
-		w.Header().Set("Pagination-Total-Count", strconv.Itoa(totalCount))
-		w.Header().Set("Pagination-Page-Count", strconv.Itoa(((totalCount-1)/limit)+1))
-		w.Header().Set("Pagination-Current-Page", strconv.Itoa(page))
+		if withTotalCount {
+			w.Header().Set("Pagination-Total-Count", strconv.Itoa(totalCount))
+			w.Header().Set("Pagination-Page-Count", strconv.Itoa(((totalCount-1)/limit)+1))
+		}
+		if cursor == nil {
+			w.Header().Set("Pagination-Current-Page", strconv.Itoa(page))
+		}
+		if len(response) == limit && len(sortTerms) == 0 && fullTextQuery == "" {
+			// a full page, there might be more
+			w.Header().Set("Pagination-Next-Cursor", nextCursor.encode())
+		}
 		if !from.IsZero() {
 			w.Header().Set("Pagination-Until", from.Format(time.RFC3339Nano))
 		}
@@ -673,9 +1148,11 @@ func (b *Backend) createCollectionResource(router *mux.Router, rc collectionConf
 			until           time.Time
 			from            time.Time
 			externalColumns []string
-			externalValues  []string
+			externalValues  []interface{}
 			ascendingOrder  bool
 			metaonly        bool
+			fields          [][]string
+			author          string
 		)
 		urlQuery := r.URL.Query()
 		parameters := map[string]string{}
@@ -687,6 +1164,8 @@ func (b *Backend) createCollectionResource(router *mux.Router, rc collectionConf
 			}
 			value := array[0]
 			switch key {
+			case "author":
+				author = value
 			case "limit":
 				limit, err = strconv.Atoi(value)
 				if err == nil && (limit < 1 || limit > 100) {
@@ -716,12 +1195,19 @@ func (b *Backend) createCollectionResource(router *mux.Router, rc collectionConf
 					found := false
 					for _, searchableColumn := range searchableColumns {
 						if filterKey == searchableColumn {
-							externalValues = append(externalValues, filterValue)
+							var externalValue interface{} = filterValue
+							if propertyType := propertyTypes[filterKey]; propertyType != "" {
+								externalValue, err = parseProperty(propertyType, filterKey, filterValue)
+							}
+							externalValues = append(externalValues, externalValue)
 							externalColumns = append(externalColumns, searchableColumn)
 							found = true
 							break
 						}
 					}
+					if err != nil {
+						break
+					}
 					if !found {
 						err = fmt.Errorf("unknown filter property '%s'", filterKey)
 						break
@@ -734,6 +1220,9 @@ func (b *Backend) createCollectionResource(router *mux.Router, rc collectionConf
 				}
 				ascendingOrder = (value == "asc")
 
+			case "fields":
+				fields, err = parseFields(value)
+
 			case "metaonly":
 				metaonly, err = strconv.ParseBool(array[0])
 				if err != nil {
@@ -768,6 +1257,10 @@ func (b *Backend) createCollectionResource(router *mux.Router, rc collectionConf
 				queryParameters[propertiesIndex-ownerIndex+6+i] = externalValues[i]
 			}
 		}
+		if author != "" {
+			queryParameters = append(queryParameters, author)
+			sqlQuery += fmt.Sprintf("AND (author=$%d) ", len(queryParameters))
+		}
 
 		for i := ownerIndex; i < propertiesIndex; i++ { // skip ID
 			queryParameters[i-ownerIndex] = params[columns[i]]
@@ -805,7 +1298,8 @@ func (b *Backend) createCollectionResource(router *mux.Router, rc collectionConf
 		var totalCount int
 		for rows.Next() {
 			var timestamp time.Time
-			values, object := createScanValuesAndObjectWithMeta(metaonly, &timestamp, new(int), &totalCount)
+			var author, requestID, operation string
+			values, object := createScanValuesAndObjectWithMeta(metaonly, &timestamp, new(int), &author, &requestID, &operation, &totalCount)
 			err := rows.Scan(values...)
 			if err != nil {
 				nillog.WithError(err).Errorf("Error 4725: cannot scan values")
@@ -815,6 +1309,12 @@ func (b *Backend) createCollectionResource(router *mux.Router, rc collectionConf
 			if !metaonly {
 				mergeProperties(object)
 			}
+			// the change is described in an envelope, which cannot clash with properties
+			object["_log"] = map[string]string{
+				"author":     author,
+				"request_id": requestID,
+				"operation":  operation,
+			}
 			// if we did not have from, take it from the first object
 			if from.IsZero() {
 				from = timestamp
@@ -823,6 +1323,14 @@ func (b *Backend) createCollectionResource(router *mux.Router, rc collectionConf
 		}
 
 		jsonData, _ := json.Marshal(response)
+		if fields != nil {
+			jsonData, err = projectJSON(jsonData, fields, keepLogFields)
+			if err != nil {
+				nillog.WithError(err).Errorf("Error 4801: cannot project fields")
+				http.Error(w, "Error 4801", http.StatusInternalServerError)
+				return
+			}
+		}
 
 		if page > 0 && totalCount == 0 {
 			// sql does not return total count if we ask beyond limits, hence
@@ -838,7 +1346,7 @@ func (b *Backend) createCollectionResource(router *mux.Router, rc collectionConf
 			defer rows.Close()
 			for rows.Next() {
 				var timestamp time.Time
-				values, _ := createScanValuesAndObject(&timestamp, new(int), &totalCount)
+				values, _ := createScanValuesAndObjectWithMeta(metaonly, &timestamp, new(int), new(string), new(string), new(string), &totalCount)
 				err := rows.Scan(values...)
 				if err != nil {
 					rlog.WithError(err).Errorf("Error 4725: cannot scan values")
@@ -885,6 +1393,11 @@ func (b *Backend) createCollectionResource(router *mux.Router, rc collectionConf
 
 		params := mux.Vars(r)
 		noIntercept := false
+		includeDeleted := false
+		var asOf time.Time
+		var revision int
+		var fields [][]string
+		keep := keepFields
 		urlQuery := r.URL.Query()
 		for key, array := range urlQuery {
 			switch key {
@@ -894,8 +1407,47 @@ func (b *Backend) createCollectionResource(router *mux.Router, rc collectionConf
 					http.Error(w, "parameter '"+key+"': "+err.Error(), http.StatusBadRequest)
 					return
 				}
+			case "fields":
+				fields, err = parseFields(array[0])
+				if err != nil {
+					http.Error(w, "parameter '"+key+"': "+err.Error(), http.StatusBadRequest)
+					return
+				}
+			case "include_deleted":
+				includeDeleted, err = parseIncludeDeleted(array[0])
+				if err != nil {
+					http.Error(w, "parameter '"+key+"': "+err.Error(), http.StatusBadRequest)
+					return
+				}
+				if includeDeleted && !includeDeletedAuthorized(r) {
+					http.Error(w, "not authorized", http.StatusUnauthorized)
+					return
+				}
+			case "as_of", "revision":
+				if !rc.WithLog {
+					http.Error(w, "parameter '"+key+"': "+this+" has no log", http.StatusBadRequest)
+					return
+				}
+				if key == "as_of" {
+					asOf, err = time.Parse(time.RFC3339, array[0])
+				} else if revision, err = strconv.Atoi(array[0]); err == nil && revision < 1 {
+					err = fmt.Errorf("out of range")
+				}
+				if err != nil {
+					http.Error(w, "parameter '"+key+"': "+err.Error(), http.StatusBadRequest)
+					return
+				}
 			case "children":
-				break
+				// requested children are part of the projection
+				keep = map[string]bool{}
+				for k := range keepFields {
+					keep[k] = true
+				}
+				for _, children := range array {
+					for _, child := range strings.Split(children, ",") {
+						keep[child] = true
+					}
+				}
 			default:
 				http.Error(w, "parameter '"+key+"': unknown query parameter", http.StatusBadRequest)
 				return
@@ -938,10 +1490,28 @@ func (b *Backend) createCollectionResource(router *mux.Router, rc collectionConf
 			queryParameters = append(queryParameters, relation.queryParameters...)
 		}
 
+		historic := !asOf.IsZero() || revision > 0
+		if historic && (includeDeleted || !asOf.IsZero() && revision > 0) {
+			http.Error(w, "parameters 'as_of', 'revision' and 'include_deleted' are mutually exclusive", http.StatusBadRequest)
+			return
+		}
+
+		sqlQuery := readQuery + sqlWhereOne + sqlNotDeleted + subQuery + ";"
+		switch {
+		case includeDeleted:
+			sqlQuery = withDeletedAt(readQuery+sqlWhereOne) + subQuery + ";"
+		case !asOf.IsZero():
+			// the latest revision which was written before as_of
+			queryParameters = append(queryParameters, asOf.UTC())
+			sqlQuery = strings.Replace(readQuery, sqlFrom, fmt.Sprintf(sqlFromAsOf, len(queryParameters)), 1) + sqlWhereOne + subQuery + ";"
+		case revision > 0:
+			queryParameters = append(queryParameters, revision)
+			sqlQuery = readQueryLog + sqlWhereOne + subQuery + sqlNotTombstone + fmt.Sprintf(" AND revision=$%d LIMIT 1;", len(queryParameters))
+		}
 		values, object := createScanValuesAndObject(&time.Time{}, new(int))
-		err = b.db.QueryRow(readQuery+sqlWhereOne+subQuery+";", queryParameters...).Scan(values...)
+		err = b.db.QueryRow(sqlQuery, queryParameters...).Scan(values...)
 		if err == csql.ErrNoRows {
-			if singleton {
+			if singleton && !historic {
 				var jsonData []byte
 				// apply defaults if applicable
 				primaryID, _ := uuid.Parse(params[owner+"_id"])
@@ -977,6 +1547,14 @@ func (b *Backend) createCollectionResource(router *mux.Router, rc collectionConf
 						jsonData = data
 					}
 				}
+				if jsonData != nil && fields != nil {
+					jsonData, err = projectJSON(jsonData, fields, keep)
+					if err != nil {
+						nillog.WithError(err).Errorf("Error 4801: cannot project fields")
+						http.Error(w, "Error 4801", http.StatusInternalServerError)
+						return
+					}
+				}
 				if jsonData != nil {
 					etag := bytesToEtag(jsonData)
 					w.Header().Set("Etag", etag)
@@ -1053,7 +1631,7 @@ func (b *Backend) createCollectionResource(router *mux.Router, rc collectionConf
 		// add children if requested
 		for key, array := range urlQuery {
 			switch key {
-			case "nointercept":
+			case "nointercept", "fields", "include_deleted", "as_of", "revision":
 				break
 			case "children":
 				if data != nil { // data was changed in interceptor
@@ -1076,6 +1654,14 @@ func (b *Backend) createCollectionResource(router *mux.Router, rc collectionConf
 				return
 			}
 		}
+		if fields != nil {
+			jsonData, err = projectJSON(jsonData, fields, keep)
+			if err != nil {
+				nillog.WithError(err).Errorf("Error 4801: cannot project fields")
+				http.Error(w, "Error 4801", http.StatusInternalServerError)
+				return
+			}
+		}
 
 		etag := bytesToEtag(jsonData)
 		w.Header().Set("Etag", etag)
@@ -1101,6 +1687,80 @@ func (b *Backend) createCollectionResource(router *mux.Router, rc collectionConf
 		read(w, r, nil)
 	}
 
+	// externalIndexID returns the primary identifier of the item with the external index values in params, or
+	// an empty string if there is no such item. The parent identifiers in params may be "all", unless the index
+	// is scoped to the parent.
+	externalIndexID := func(tx transaction, params map[string]string, externalIndex externalIndexConfiguration) (string, error) {
+		sqlQuery := fmt.Sprintf("SELECT %s FROM %s.\"%s\" WHERE ", columns[0], schema, resource)
+		queryParameters := []interface{}{}
+		for i := 1; i < propertiesIndex; i++ {
+			queryParameters = append(queryParameters, params[columns[i]])
+		}
+		if propertiesIndex > 1 {
+			sqlQuery += compareIDsString(columns[1:propertiesIndex]) + " AND "
+		}
+		var compares []string
+		for _, property := range externalIndex.Properties {
+			queryParameters = append(queryParameters, params[property])
+			compares = append(compares, fmt.Sprintf("\"%s\"=$%d", property, len(queryParameters)))
+		}
+		sqlQuery += strings.Join(compares, " AND ") + sqlNotDeleted + " LIMIT 1;"
+
+		var id uuid.UUID
+		err := tx.QueryRow(sqlQuery, queryParameters...).Scan(&id)
+		if err == csql.ErrNoRows {
+			return "", nil
+		}
+		if err != nil {
+			return "", err
+		}
+		return id.String(), nil
+	}
+
+	// lookupWithAuth reads the item with the external index values in the path
+	lookupWithAuth := func(w http.ResponseWriter, r *http.Request, externalIndex externalIndexConfiguration) {
+		params := mux.Vars(r)
+		rlog := logger.FromContext(r.Context())
+		if b.authorizationEnabled {
+			auth := access.AuthorizationFromContext(r.Context())
+			if !auth.IsAuthorized(resources, core.OperationRead, params, rc.Permits) {
+				http.Error(w, "not authorized", http.StatusUnauthorized)
+				return
+			}
+		}
+		if externalIndex.Scope == externalIndexScopeParent {
+			parent := dependencies[len(dependencies)-1]
+			if params[parent+"_id"] == "all" {
+				http.Error(w, "all is not a valid "+parent+"_id for a lookup of "+strings.Join(externalIndex.Properties, ","), http.StatusBadRequest)
+				return
+			}
+		}
+		tx, err := b.beginTx(r.Context())
+		if err != nil {
+			rlog.WithError(err).Errorf("Error 4820: BeginTx")
+			http.Error(w, "Error 4820", http.StatusInternalServerError)
+			return
+		}
+		id, err := externalIndexID(tx, params, externalIndex)
+		tx.Rollback()
+		if err != nil {
+			rlog.WithError(err).Errorf("Error 4820: lookup external index")
+			http.Error(w, "Error 4820", http.StatusInternalServerError)
+			return
+		}
+		if id == "" {
+			http.Error(w, "no such "+this, http.StatusNotFound)
+			return
+		}
+
+		vars := map[string]string{}
+		for i := 1; i < propertiesIndex; i++ {
+			vars[columns[i]] = params[columns[i]]
+		}
+		vars[columns[0]] = id
+		readWithAuth(w, mux.SetURLVars(r, vars))
+	}
+
 	updatePropertyWithAuth := func(w http.ResponseWriter, r *http.Request, property string) {
 		params := mux.Vars(r)
 
@@ -1148,6 +1808,13 @@ func (b *Backend) createCollectionResource(router *mux.Router, rc collectionConf
 			http.Error(w, fmt.Sprintf("cannot unescape %s, err: %v", value, err), http.StatusBadRequest)
 			return
 		}
+		var sqlValue interface{} = value
+		if propertyType := propertyTypes[property]; propertyType != "" {
+			if sqlValue, err = parseProperty(propertyType, property, value); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
 
 		query := fmt.Sprintf(updatePropertyQuery, property)
 
@@ -1156,9 +1823,9 @@ func (b *Backend) createCollectionResource(router *mux.Router, rc collectionConf
 		for ; i < propertiesIndex; i++ {
 			queryParameters[i] = params[columns[i]]
 		}
-		queryParameters[i] = value
+		queryParameters[i] = sqlValue
 
-		tx, err := b.db.BeginTx(r.Context(), nil)
+		tx, err := b.beginTx(r.Context())
 		if err != nil {
 			nillog.WithError(err).Errorf("Error 4729: cannot BeginTx")
 			http.Error(w, "Error 4729", http.StatusInternalServerError)
@@ -1178,8 +1845,25 @@ func (b *Backend) createCollectionResource(router *mux.Router, rc collectionConf
 			http.Error(w, "Error 4728", http.StatusInternalServerError)
 			return
 		}
-		notification := map[string]string{
-			property: value,
+
+		// write log
+		if rc.WithLog {
+			var timestamp time.Time
+			values, _ := createScanValuesAndObject(&timestamp, new(int))
+			err = tx.QueryRow(readQuery+"WHERE "+primary+"_id = $1;", &primaryID).Scan(values...)
+			if err == nil {
+				timestamp = time.Now().UTC() // the log always uses current time (UTC) as timestamp
+				_, err = tx.Exec(insertQueryLog, logValues(r.Context(), values, logOperationUpdateProperty)...)
+			}
+			if err != nil {
+				tx.Rollback()
+				nillog.WithError(err).Errorf("Error 4818: create log")
+				http.Error(w, "Error 4818", http.StatusInternalServerError)
+				return
+			}
+		}
+		notification := map[string]interface{}{
+			property: sqlValue,
 		}
 		notificationJSON, _ := json.MarshalWithOption(notification, json.DisableHTMLEscape())
 		err = b.commitWithNotification(r.Context(), tx, resource, core.OperationUpdate, primaryID, notificationJSON)
@@ -1241,16 +1925,37 @@ func (b *Backend) createCollectionResource(router *mux.Router, rc collectionConf
 			queryParameters[i] = params[columns[i]]
 		}
 
-		tx, err := b.db.BeginTx(r.Context(), nil)
+		tx, err := b.beginTx(r.Context())
 		if err != nil {
 			rlog.WithError(err).Errorf("Error 4729: cannot BeginTx")
 			http.Error(w, "Error 4729", http.StatusInternalServerError)
 			return
 		}
 
-		var timestamp time.Time
-		values, object := createScanValuesAndObject(&timestamp, new(int))
-		err = tx.QueryRow(deleteQuery+sqlWhereOne+sqlReturnObject, queryParameters...).Scan(values...)
+		err = writeTombstones(r.Context(), tx, sqlWhereOne+sqlNotDeleted, queryParameters)
+		if err != nil {
+			tx.Rollback()
+			rlog.WithError(err).Errorf("Error 4819: write tombstone")
+			http.Error(w, "Error 4819", http.StatusInternalServerError)
+			return
+		}
+
+		var (
+			timestamp time.Time
+			revision  int
+		)
+		values, object := createScanValuesAndObject(&timestamp, &revision)
+		if softDelete {
+			deletedAt := time.Now().UTC().Truncate(time.Microsecond)
+			queryParameters = append(queryParameters, deletedAt)
+			err = tx.QueryRow(fmt.Sprintf(softDeleteQuery, len(queryParameters))+sqlWhereOne+sqlNotDeleted+sqlReturnObject, queryParameters...).Scan(values...)
+			for i := 0; err == nil && i < len(softDeleteChildrenQueries); i++ {
+				_, err = tx.Exec(softDeleteChildrenQueries[i], deletedAt)
+			}
+			revision-- // the soft delete is a new revision
+		} else {
+			err = tx.QueryRow(deleteQuery+sqlWhereOne+sqlReturnObject, queryParameters...).Scan(values...)
+		}
 		if err == csql.ErrNoRows {
 			tx.Rollback()
 			w.WriteHeader(http.StatusNotFound)
@@ -1262,7 +1967,27 @@ func (b *Backend) createCollectionResource(router *mux.Router, rc collectionConf
 			http.Error(w, "Error 4730", http.StatusInternalServerError)
 			return
 		}
-		if rc.needsKSS && b.KssDriver != nil {
+		mergeProperties(object)
+
+		// the returned object is the state before deletion, hence we can still evaluate preconditions
+		if hasPreconditions(r) {
+			etag, err := itemEtag(r.Context(), object, selectors)
+			if err != nil {
+				tx.Rollback()
+				rlog.WithError(err).Errorf("Error 4812: interceptor")
+				http.Error(w, "Error 4812", http.StatusInternalServerError)
+				return
+			}
+			if preconditionFailed(r, etag, timestamp) {
+				tx.Rollback()
+				w.Header().Set("Etag", etag)
+				http.Error(w, "precondition failed", http.StatusPreconditionFailed)
+				return
+			}
+		}
+
+		// companion files of soft deleted items are deleted when the items are purged
+		if rc.needsKSS && b.KssDriver != nil && !softDelete {
 			var key string
 			for i := 0; i < propertiesIndex; i++ {
 				key += "/" + resources[i] + "_id/" + values[propertiesIndex-i-1].(*uuid.UUID).String()
@@ -1274,7 +1999,6 @@ func (b *Backend) createCollectionResource(router *mux.Router, rc collectionConf
 			}
 		}
 
-		mergeProperties(object)
 		jsonData, _ := json.MarshalWithOption(object, json.DisableHTMLEscape())
 
 		var silent bool
@@ -1322,6 +2046,7 @@ func (b *Backend) createCollectionResource(router *mux.Router, rc collectionConf
 			from            time.Time
 			externalColumn  string
 			externalValue   string
+			sqlValue        interface{}
 		)
 		parameters := map[string]string{}
 		urlQuery := r.URL.Query()
@@ -1356,6 +2081,11 @@ func (b *Backend) createCollectionResource(router *mux.Router, rc collectionConf
 				}
 				if !found {
 					err = fmt.Errorf("unknown filter property '%s'", filterKey)
+					break
+				}
+				sqlValue = filterValue
+				if propertyType := propertyTypes[filterKey]; propertyType != "" {
+					sqlValue, err = parseProperty(propertyType, filterKey, filterValue)
 				}
 
 			default:
@@ -1376,7 +2106,7 @@ func (b *Backend) createCollectionResource(router *mux.Router, rc collectionConf
 			return
 		}
 
-		tx, err := b.db.BeginTx(r.Context(), nil)
+		tx, err := b.beginTx(r.Context())
 		if err != nil {
 			rlog.WithError(err).Errorf("Error 4731: BeginTx")
 			http.Error(w, "Error 4731", http.StatusInternalServerError)
@@ -1395,7 +2125,7 @@ func (b *Backend) createCollectionResource(router *mux.Router, rc collectionConf
 			for i := ownerIndex; i < propertiesIndex; i++ { // skip ID
 				queryParameters[i-ownerIndex] = params[columns[i]]
 			}
-			queryParameters[propertiesIndex-ownerIndex+4] = externalValue
+			queryParameters[propertiesIndex-ownerIndex+4] = sqlValue
 		}
 
 		// add before and after and pagination
@@ -1404,32 +2134,56 @@ func (b *Backend) createCollectionResource(router *mux.Router, rc collectionConf
 		queryParameters[propertiesIndex-ownerIndex+2] = from.IsZero()
 		queryParameters[propertiesIndex-ownerIndex+3] = from.UTC()
 
-		rows, err := tx.Query(sqlQuery+sqlReturnMeta, queryParameters...)
+		err = writeTombstones(r.Context(), tx, strings.TrimPrefix(sqlQuery, clearQuery)+sqlNotDeleted, queryParameters)
 		if err != nil {
 			tx.Rollback()
-			rlog.WithError(err).Errorf("Error 4732: sqlQuery `%s`", sqlQuery)
-			http.Error(w, "Error 4732", http.StatusInternalServerError)
+			rlog.WithError(err).Errorf("Error 4819: write tombstones")
+			http.Error(w, "Error 4819", http.StatusInternalServerError)
 			return
 		}
-		defer rows.Close()
 
-		if rc.needsKSS && b.KssDriver != nil {
-			for rows.Next() {
-				var timestamp time.Time
-				values, _ := createScanValuesAndObjectWithMeta(true, &timestamp, nil)
-				err := rows.Scan(values...)
-				if err != nil {
-					rlog.WithError(err).Errorf("Error 4725: cannot scan values")
-					http.Error(w, "Error 4725", http.StatusInternalServerError)
-					return
-				}
-				var key string
-				for i := 0; i < propertiesIndex; i++ {
-					key += "/" + resources[i] + "_id/" + values[propertiesIndex-i-1].(*uuid.UUID).String()
-				}
-				err = b.KssDriver.DeleteAllWithPrefix(key)
-				if err != nil {
-					rlog.WithError(err).Error("Could not delete key ", key)
+		if softDelete {
+			deletedAt := time.Now().UTC().Truncate(time.Microsecond)
+			queryParameters = append(queryParameters, deletedAt)
+			sqlQuery = fmt.Sprintf(softDeleteQuery, len(queryParameters)) + strings.TrimPrefix(sqlQuery, clearQuery) + sqlNotDeleted
+			_, err = tx.Exec(sqlQuery, queryParameters...)
+			for i := 0; err == nil && i < len(softDeleteChildrenQueries); i++ {
+				_, err = tx.Exec(softDeleteChildrenQueries[i], deletedAt)
+			}
+			if err != nil {
+				tx.Rollback()
+				rlog.WithError(err).Errorf("Error 4732: sqlQuery `%s`", sqlQuery)
+				http.Error(w, "Error 4732", http.StatusInternalServerError)
+				return
+			}
+		} else {
+			rows, err := tx.Query(sqlQuery+sqlReturnMeta, queryParameters...)
+			if err != nil {
+				tx.Rollback()
+				rlog.WithError(err).Errorf("Error 4732: sqlQuery `%s`", sqlQuery)
+				http.Error(w, "Error 4732", http.StatusInternalServerError)
+				return
+			}
+			defer rows.Close()
+
+			if rc.needsKSS && b.KssDriver != nil {
+				for rows.Next() {
+					var timestamp time.Time
+					values, _ := createScanValuesAndObjectWithMeta(true, &timestamp, nil)
+					err := rows.Scan(values...)
+					if err != nil {
+						rlog.WithError(err).Errorf("Error 4725: cannot scan values")
+						http.Error(w, "Error 4725", http.StatusInternalServerError)
+						return
+					}
+					var key string
+					for i := 0; i < propertiesIndex; i++ {
+						key += "/" + resources[i] + "_id/" + values[propertiesIndex-i-1].(*uuid.UUID).String()
+					}
+					err = b.KssDriver.DeleteAllWithPrefix(key)
+					if err != nil {
+						rlog.WithError(err).Error("Could not delete key ", key)
+					}
 				}
 			}
 		}
@@ -1452,7 +2206,8 @@ func (b *Backend) createCollectionResource(router *mux.Router, rc collectionConf
 		w.WriteHeader(http.StatusNoContent)
 	}
 
-	create := func(w http.ResponseWriter, r *http.Request, bodyJSON map[string]interface{}) {
+	// create creates a new item. The upsert passes the body json, and the external index it upserts by, if any.
+	create := func(w http.ResponseWriter, r *http.Request, bodyJSON map[string]interface{}, externalIndex *externalIndexConfiguration) {
 		var err error
 
 		rlog := logger.FromContext(r.Context())
@@ -1614,6 +2369,14 @@ func (b *Backend) createCollectionResource(router *mux.Router, rc collectionConf
 		// static properties and external indices, non mandatory
 		for ; i < len(columns); i++ {
 			value, ok := bodyJSON[columns[i]]
+			if propertyType := propertyTypes[columns[i]]; propertyType != "" {
+				values[i], err = propertyToSQL(propertyType, columns[i], value)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				continue
+			}
 			if !ok {
 				value = ""
 			}
@@ -1637,16 +2400,34 @@ func (b *Backend) createCollectionResource(router *mux.Router, rc collectionConf
 		values[i] = &timestamp
 		i++
 
-		tx, err := b.db.BeginTx(r.Context(), nil)
+		tx, err := b.beginTx(r.Context())
 		if err != nil {
 			rlog.WithError(err).Errorf("Error 4733: BeginTx")
 			http.Error(w, "Error 4733", http.StatusInternalServerError)
 			return
 		}
+		if parentDeletedQuery != "" {
+			// children of soft deleted items cannot be created
+			var parentDeleted bool
+			err = tx.QueryRow(parentDeletedQuery, values[ownerIndex]).Scan(&parentDeleted)
+			if parentDeleted {
+				tx.Rollback()
+				http.Error(w, "no such "+dependencies[len(dependencies)-1], http.StatusNotFound)
+				return
+			}
+		}
+		query := insertQuery
+		if externalIndex != nil {
+			query = insertQueryByExternalIndex[externalIndex.name()]
+		}
 		var id uuid.UUID
-		err = tx.QueryRow(insertQuery, values...).Scan(&id)
+		err = tx.QueryRow(query, values...).Scan(&id)
 		if err == csql.ErrNoRows {
 			tx.Rollback()
+			if externalIndex != nil {
+				http.Error(w, this+" with this "+strings.Join(externalIndex.Properties, ",")+" already exists", http.StatusUnprocessableEntity)
+				return
+			}
 			http.Error(w, "singleton "+this+" already exists", http.StatusUnprocessableEntity)
 			return
 		} else if err != nil {
@@ -1714,7 +2495,7 @@ func (b *Backend) createCollectionResource(router *mux.Router, rc collectionConf
 		// write log
 		if rc.WithLog {
 			timestamp = now // the log always uses current time (UTC) as timestamp
-			_, err = tx.Exec(insertQueryLog, values...)
+			_, err = tx.Exec(insertQueryLog, logValues(r.Context(), values, string(core.OperationCreate))...)
 			if err != nil {
 				tx.Rollback()
 				rlog.WithError(err).Errorf("Error 4736: create log")
@@ -1755,10 +2536,12 @@ func (b *Backend) createCollectionResource(router *mux.Router, rc collectionConf
 			}
 		}
 
-		create(w, r, nil)
+		create(w, r, nil, nil)
 	}
 
-	upsertWithAuth := func(w http.ResponseWriter, r *http.Request) {
+	// upsertWithAuth updates or creates an item. With an external index, or with the query parameter "by" naming
+	// one, the item is identified by the values of the external index instead of its primary identifier.
+	upsertWithAuth := func(w http.ResponseWriter, r *http.Request, externalIndex *externalIndexConfiguration) {
 		var err error
 
 		rlog := logger.FromContext(r.Context())
@@ -1787,16 +2570,89 @@ func (b *Backend) createCollectionResource(router *mux.Router, rc collectionConf
 			}
 		}
 
+		// PATCH also accepts json patch and json merge patch documents
+		var patchType string
+		if r.Method == http.MethodPatch {
+			patchType, _, _ = mime.ParseMediaType(r.Header.Get("Content-Type"))
+		}
+
 		var bodyJSON map[string]interface{}
-		err = json.NewDecoder(body).Decode(&bodyJSON)
+		var jsonPatch []jsonPatchOperation
+		if patchType == jsonPatchContentType {
+			// a json patch is a list of operations, the identifiers come from the path only
+			err = json.NewDecoder(body).Decode(&jsonPatch)
+			bodyJSON = map[string]interface{}{}
+		} else {
+			err = json.NewDecoder(body).Decode(&bodyJSON)
+		}
 		if err != nil {
 			http.Error(w, "invalid json data: "+err.Error(), http.StatusBadRequest)
 			return
 		}
 
-		// primary id can come from parameter (fully qualified put) or from body json (collection put).
+		if by := r.URL.Query().Get("by"); by != "" && externalIndex == nil {
+			for i := range externalIndices {
+				if strings.Join(externalIndices[i].Properties, ",") == by {
+					externalIndex = &externalIndices[i]
+				}
+			}
+			if externalIndex == nil {
+				http.Error(w, "parameter 'by': unknown external index '"+by+"'", http.StatusBadRequest)
+				return
+			}
+		}
+
+		// primary id can come from parameter (fully qualified put), from body json (collection put) or
+		// from the external index.
 		primaryID := params[columns[0]]
-		if len(primaryID) == 0 || primaryID == "all" {
+		if externalIndex != nil {
+			// the values of the external index come from the path or from the body json
+			for _, property := range externalIndex.Properties {
+				value, ok := params[property]
+				if !ok {
+					value, _ = bodyJSON[property].(string)
+				} else if v, ok := bodyJSON[property]; ok && v != value {
+					http.Error(w, "illegal "+property, http.StatusBadRequest)
+					return
+				}
+				if value == "" {
+					http.Error(w, "missing "+property, http.StatusBadRequest)
+					return
+				}
+				params[property] = value
+				if patchType != jsonPatchContentType {
+					bodyJSON[property] = value
+				}
+			}
+			if externalIndex.Scope == externalIndexScopeParent {
+				parent := dependencies[len(dependencies)-1]
+				if params[parent+"_id"] == "all" {
+					http.Error(w, "all is not a valid "+parent+"_id for an upsert by "+strings.Join(externalIndex.Properties, ","), http.StatusBadRequest)
+					return
+				}
+			}
+			tx, err := b.beginTx(r.Context())
+			if err != nil {
+				rlog.WithError(err).Errorf("Error 4821: BeginTx")
+				http.Error(w, "Error 4821", http.StatusInternalServerError)
+				return
+			}
+			primaryID, err = externalIndexID(tx, params, *externalIndex)
+			tx.Rollback()
+			if err != nil {
+				rlog.WithError(err).Errorf("Error 4821: lookup external index")
+				http.Error(w, "Error 4821", http.StatusInternalServerError)
+				return
+			}
+			if primaryID == "" {
+				// the item does not exist yet, it gets the primary identifier from the body json or a new one
+				var ok bool
+				if primaryID, ok = bodyJSON[columns[0]].(string); !ok {
+					primaryID = uuid.New().String()
+				}
+			}
+			params[columns[0]] = primaryID
+		} else if len(primaryID) == 0 || primaryID == "all" {
 			var ok bool
 			primaryID, ok = bodyJSON[columns[0]].(string)
 			if !ok {
@@ -1826,7 +2682,7 @@ func (b *Backend) createCollectionResource(router *mux.Router, rc collectionConf
 			revision = int(r)
 		}
 
-		tx, err := b.db.BeginTx(r.Context(), nil)
+		tx, err := b.beginTx(r.Context())
 		if err != nil {
 			rlog.WithError(err).Errorf("Error 4736: Update of resource `%s`", resource)
 			http.Error(w, "Error 4736", http.StatusInternalServerError)
@@ -1837,8 +2693,33 @@ func (b *Backend) createCollectionResource(router *mux.Router, rc collectionConf
 		var currentRevision int
 		retried := false
 	Retry:
+		if retried && externalIndex != nil {
+			// somebody else has created an item with this external index right now, we update that one instead
+			primaryID, err = externalIndexID(tx, params, *externalIndex)
+			if err != nil {
+				tx.Rollback()
+				rlog.WithError(err).Errorf("Error 4821: lookup external index")
+				http.Error(w, "Error 4821", http.StatusInternalServerError)
+				return
+			}
+			if primaryID == "" {
+				// the conflicting item was deleted right now
+				tx.Rollback()
+				http.Error(w, "conflicting "+this+" with this "+strings.Join(externalIndex.Properties, ","), http.StatusConflict)
+				return
+			}
+			params[columns[0]] = primaryID
+			if b.authorizationEnabled {
+				auth := access.AuthorizationFromContext(r.Context())
+				if !auth.IsAuthorized(resources, core.OperationUpdate, params, rc.Permits) {
+					tx.Rollback()
+					http.Error(w, "not authorized", http.StatusUnauthorized)
+					return
+				}
+			}
+		}
 		current, object := createScanValuesAndObject(&timestamp, &currentRevision)
-		err = tx.QueryRow(readQuery+"WHERE "+primary+"_id = $1 FOR UPDATE;", &primaryID).Scan(current...)
+		err = tx.QueryRow(readQuery+"WHERE "+primary+"_id = $1"+sqlNotDeleted+" FOR UPDATE;", &primaryID).Scan(current...)
 		if err == csql.ErrNoRows {
 			// item does not exist yet.
 			if singleton {
@@ -1848,7 +2729,30 @@ func (b *Backend) createCollectionResource(router *mux.Router, rc collectionConf
 				tx.Rollback()
 				http.Error(w, "no such "+this, http.StatusNotFound)
 				return
-			} else if b.authorizationEnabled {
+			}
+			if softDelete {
+				// a soft deleted item keeps its identifier until it is purged
+				var deleted bool
+				err = tx.QueryRow(fmt.Sprintf("SELECT true FROM %s.\"%s\" WHERE %s_id = $1;", schema, resource, primary), &primaryID).Scan(&deleted)
+				if err != nil && err != csql.ErrNoRows {
+					tx.Rollback()
+					rlog.WithError(err).Errorf("Error 4815: cannot check soft delete")
+					http.Error(w, "Error 4815", http.StatusInternalServerError)
+					return
+				}
+				if deleted {
+					tx.Rollback()
+					http.Error(w, this+" is deleted, restore it first", http.StatusConflict)
+					return
+				}
+			}
+			if preconditionFailed(r, "", time.Time{}) {
+				// the client expected an existing object
+				tx.Rollback()
+				http.Error(w, "precondition failed", http.StatusPreconditionFailed)
+				return
+			}
+			if !singleton && b.authorizationEnabled {
 				// normal upsert, check whether we can create the object
 				auth := access.AuthorizationFromContext(r.Context())
 				if !auth.IsAuthorized(resources, core.OperationCreate, params, rc.Permits) {
@@ -1858,11 +2762,33 @@ func (b *Backend) createCollectionResource(router *mux.Router, rc collectionConf
 				}
 			}
 
+			// a patched singleton which does not exist yet is created from an empty object
+			createJSON := bodyJSON
+			switch patchType {
+			case jsonPatchContentType:
+				var status int
+				if createJSON, status, err = applyJSONPatch(map[string]interface{}{}, jsonPatch); err != nil {
+					tx.Rollback()
+					http.Error(w, "cannot apply json patch: "+err.Error(), status)
+					return
+				}
+			case mergePatchContentType:
+				createJSON = map[string]interface{}{}
+				mergePatch(createJSON, bodyJSON)
+			}
+
+			// create runs within our transaction, as a savepoint. If it fails, it only rolls back to its
+			// savepoint and our transaction stays intact, also within a surrounding batch.
 			rec := httptest.NewRecorder()
-			create(rec, r, bodyJSON)
+			create(rec, r.WithContext(contextWithTransaction(r.Context(), sqlTx(tx))), createJSON, externalIndex)
 			if rec.Code == http.StatusCreated {
-				// all is good, we are done, we can rollback this transaction
-				tx.Rollback()
+				// all is good, we are done
+				if err = tx.Commit(); err != nil {
+					rlog.WithError(err).Error("Error 4737: Commit")
+					http.Error(w, "Error 4737", http.StatusInternalServerError)
+					return
+				}
+				b.TriggerJobs()
 				w.Header().Set("Content-Type", "application/json; charset=utf-8")
 				w.WriteHeader(http.StatusCreated)
 				w.Write(rec.Body.Bytes())
@@ -1872,7 +2798,7 @@ func (b *Backend) createCollectionResource(router *mux.Router, rc collectionConf
 				retried = true
 				goto Retry
 			}
-			err = tx.Rollback()
+			tx.Rollback()
 			http.Error(w, rec.Body.String(), rec.Code)
 			return
 		}
@@ -1894,6 +2820,22 @@ func (b *Backend) createCollectionResource(router *mux.Router, rc collectionConf
 		}
 		mergeProperties(object)
 
+		if hasPreconditions(r) {
+			etag, err := itemEtag(r.Context(), object, selectors)
+			if err != nil {
+				tx.Rollback()
+				rlog.WithError(err).Errorf("Error 4812: interceptor")
+				http.Error(w, "Error 4812", http.StatusInternalServerError)
+				return
+			}
+			if preconditionFailed(r, etag, timestamp) {
+				tx.Rollback()
+				w.Header().Set("Etag", etag)
+				http.Error(w, "precondition failed", http.StatusPreconditionFailed)
+				return
+			}
+		}
+
 		primaryUUID := *current[0].(*uuid.UUID)
 		primaryID = primaryUUID.String()
 
@@ -1906,7 +2848,19 @@ func (b *Backend) createCollectionResource(router *mux.Router, rc collectionConf
 			json.Unmarshal(body, &objectJSON)
 
 			// now bodyJSON from the request becomes a patch
-			patchObject(objectJSON, bodyJSON)
+			switch patchType {
+			case jsonPatchContentType:
+				var status int
+				if objectJSON, status, err = applyJSONPatch(objectJSON, jsonPatch); err != nil {
+					tx.Rollback()
+					http.Error(w, "cannot apply json patch: "+err.Error(), status)
+					return
+				}
+			case mergePatchContentType:
+				mergePatch(objectJSON, bodyJSON)
+			default:
+				patchObject(objectJSON, bodyJSON)
+			}
 
 			// rewrite this put request to contain the entire (patched) object
 			bodyJSON = objectJSON
@@ -2019,6 +2973,14 @@ func (b *Backend) createCollectionResource(router *mux.Router, rc collectionConf
 				http.Error(w, "missing property or index "+columns[i], http.StatusBadRequest)
 				return
 			}
+			if propertyType := propertyTypes[columns[i]]; propertyType != "" {
+				value, err = propertyToSQL(propertyType, columns[i], value)
+				if err != nil {
+					tx.Rollback()
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+			}
 			values[i] = value
 		}
 
@@ -2064,7 +3026,7 @@ func (b *Backend) createCollectionResource(router *mux.Router, rc collectionConf
 		// write log
 		if rc.WithLog {
 			timestamp = time.Now().UTC() // the log always uses current time (UTC) as timestamp
-			_, err = tx.Exec(insertQueryLog, values...)
+			_, err = tx.Exec(insertQueryLog, logValues(r.Context(), values, string(core.OperationUpdate))...)
 			if err != nil {
 				tx.Rollback()
 				rlog.WithError(err).Errorf("Error 4741: create log")
@@ -2116,42 +3078,1205 @@ func (b *Backend) createCollectionResource(router *mux.Router, rc collectionConf
 		w.Write(jsonData)
 	}
 
-	// store the collection functions  for later usage in relations
-	b.collectionFunctions[resource] = &collectionFunctions{
-		permits: rc.Permits,
-		list:    list,
-		read:    read,
-	}
-
-	// CREATE
-	if !singleton {
-		router.Handle(listRoute, handlers.CompressHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			logger.FromContext(r.Context()).Infoln("called route for", r.URL, r.Method)
-			createWithAuth(w, r)
-		}))).Methods(http.MethodOptions, http.MethodPost)
-	}
-
-	// UPDATE/CREATE with id in json
-	router.Handle(listRoute, handlers.CompressHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		logger.FromContext(r.Context()).Infoln("called route for", r.URL, r.Method)
-		upsertWithAuth(w, r)
-	}))).Methods(http.MethodOptions, http.MethodPut, http.MethodPatch)
-
-	// UPDATE/CREATE with fully qualified path
-	router.Handle(itemRoute, handlers.CompressHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		logger.FromContext(r.Context()).Infoln("called route for", r.URL, r.Method)
-		upsertWithAuth(w, r)
-	}))).Methods(http.MethodOptions, http.MethodPut, http.MethodPatch)
-
-	// READ
-	router.Handle(itemRoute, handlers.CompressHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		logger.FromContext(r.Context()).Infoln("called route for", r.URL, r.Method)
-		readWithAuth(w, r)
-	}))).Methods(http.MethodOptions, http.MethodGet)
-
-	// PUT FOR STATIC PROPERTIES
-	for i := staticPropertiesIndex; i < len(columns); i++ {
-		property := columns[i]
+	aggregate := func(w http.ResponseWriter, r *http.Request) {
+		var (
+			until      time.Time
+			from       time.Time
+			filter     collectionFilter
+			groupBy    []string
+			withCount  bool
+			aggregates [][2]string // pairs of function and property
+		)
+		urlQuery := r.URL.Query()
+		for key, array := range urlQuery {
+			isFilter, err := filter.parse(key, array)
+			if err != nil {
+				http.Error(w, "parameter '"+key+"': "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			if isFilter {
+				continue
+			}
+			if len(array) > 1 {
+				http.Error(w, "illegal parameter array '"+key+"'", http.StatusBadRequest)
+				return
+			}
+			value := array[0]
+			switch key {
+			case "until":
+				until, err = time.Parse(time.RFC3339, value)
+			case "from":
+				from, err = time.Parse(time.RFC3339, value)
+			case "count":
+				withCount = true
+				if value != "" {
+					withCount, err = strconv.ParseBool(value)
+				}
+			case "group_by", "sum", "min", "max", "avg":
+				for _, property := range strings.Split(value, ",") {
+					property = strings.TrimSpace(property)
+					if property == "" {
+						err = fmt.Errorf("empty property in '%s'", value)
+						break
+					}
+					if key == "group_by" {
+						groupBy = append(groupBy, property)
+					} else {
+						aggregates = append(aggregates, [2]string{key, property})
+					}
+				}
+			default:
+				err = fmt.Errorf("unknown")
+			}
+			if err != nil {
+				http.Error(w, "parameter '"+key+"': "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		if len(aggregates) == 0 {
+			withCount = true
+		}
+
+		params := mux.Vars(r)
+		queryParameters := make([]interface{}, propertiesIndex-ownerIndex+4)
+		for i := ownerIndex; i < propertiesIndex; i++ { // skip ID
+			queryParameters[i-ownerIndex] = params[columns[i]]
+		}
+		queryParameters[propertiesIndex-ownerIndex+0] = until.IsZero()
+		queryParameters[propertiesIndex-ownerIndex+1] = until.UTC()
+		queryParameters[propertiesIndex-ownerIndex+2] = from.IsZero()
+		queryParameters[propertiesIndex-ownerIndex+3] = from.UTC()
+
+		// propertyType returns the type of a property for aggregation: the configured type of typed
+		// properties, "uuid" for identifiers, "string" for string columns and "json" for everything else
+		propertyType := func(property string) string {
+			switch property {
+			case "timestamp":
+				return "timestamp"
+			case "revision":
+				return "integer"
+			}
+			sqlType, ok := columnFilter.columns[property]
+			switch {
+			case !ok:
+				return "json"
+			case sqlType == "uuid":
+				return "uuid"
+			case propertyTypes[property] != "":
+				return propertyTypes[property]
+			}
+			return "string"
+		}
+
+		// expression returns the SQL expression for a property. JSON properties are compared as jsonb,
+		// so numbers group and sort as numbers. For sum and avg the expression is cast to numeric.
+		expression := func(property string, numeric bool) (string, error) {
+			e := "\"" + property + "\""
+			switch propertyType(property) {
+			case "json":
+				var err error
+				if e, err = jsonProperty(property, numeric, &queryParameters); err != nil {
+					return "", err
+				}
+				if !numeric {
+					return "(" + e + ")::jsonb", nil
+				}
+			case "string":
+				if !numeric {
+					return e, nil
+				}
+			case "uuid":
+				if !numeric {
+					return e + "::text", nil
+				}
+				return "", fmt.Errorf("cannot sum or average identifier %s", property)
+			case "integer", "numeric":
+				if numeric {
+					return e + "::numeric", nil
+				}
+				return e, nil
+			default:
+				if !numeric {
+					return e, nil
+				}
+				return "", fmt.Errorf("cannot sum or average %s property %s", propertyType(property), property)
+			}
+			// values which are not numbers are ignored
+			return fmt.Sprintf("(CASE WHEN %s ~ %s THEN (%s)::numeric END)", e, numericRegexp, e), nil
+		}
+
+		var selects, positions []string
+		for i, property := range groupBy {
+			e, err := expression(property, false)
+			if err != nil {
+				http.Error(w, "parameter 'group_by': "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			selects = append(selects, e)
+			positions = append(positions, strconv.Itoa(i+1))
+		}
+		if withCount {
+			selects = append(selects, "count(*)")
+		}
+		for _, aggregate := range aggregates {
+			function, property := aggregate[0], aggregate[1]
+			numeric := function == "sum" || function == "avg"
+			e, err := expression(property, numeric)
+			if err != nil {
+				http.Error(w, "parameter '"+function+"': "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			switch {
+			case numeric:
+			case propertyType(property) == "json":
+				// jsonb has no min and max, take the first value in order and skip json nulls
+				order := "ASC"
+				if function == "max" {
+					order = "DESC"
+				}
+				selects = append(selects, fmt.Sprintf("(array_agg(%s ORDER BY %s %s) FILTER (WHERE jsonb_typeof(%s) <> 'null'))[1]", e, e, order, e))
+				continue
+			case propertyType(property) == "boolean":
+				function = map[string]string{"min": "bool_and", "max": "bool_or"}[function]
+			}
+			selects = append(selects, function+"("+e+")")
+		}
+
+		conditions, err := filter.compile(columnFilter, &queryParameters)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		sqlQuery := "SELECT " + strings.Join(selects, ", ") + fmt.Sprintf(" FROM %s.\"%s\" ", schema, resource) +
+			sqlWhereAll + sqlNotDeleted + conditions
+		if len(positions) > 0 {
+			sqlQuery += "GROUP BY " + strings.Join(positions, ",") + " ORDER BY " + strings.Join(positions, ",") +
+				fmt.Sprintf(" LIMIT %d", maxAggregateGroups+1) // one more, to detect too many groups
+		}
+		sqlQuery += ";"
+
+		rows, err := b.db.Query(sqlQuery, queryParameters...)
+		if err != nil {
+			if err, ok := err.(*pq.Error); ok && err.Code == "22P02" {
+				http.Error(w, "invalid uuid", http.StatusBadRequest)
+				return
+			}
+			nillog.WithError(err).Errorf("Error 4802: cannot execute query `%s` %+v", sqlQuery, queryParameters)
+			http.Error(w, "Error 4802", http.StatusInternalServerError)
+			return
+		}
+		defer rows.Close()
+		response := []map[string]interface{}{}
+		for rows.Next() {
+			// typed columns scan into their json types, numeric and jsonb values as raw json
+			groups := make([]propertyValue, len(groupBy))
+			results := make([]propertyValue, len(aggregates))
+			var count int64
+			var values []interface{}
+			for i := range groups {
+				values = append(values, &groups[i])
+			}
+			if withCount {
+				values = append(values, &count)
+			}
+			for i := range results {
+				values = append(values, &results[i])
+			}
+			if err := rows.Scan(values...); err != nil {
+				nillog.WithError(err).Errorf("Error 4803: cannot scan values")
+				http.Error(w, "Error 4803", http.StatusInternalServerError)
+				return
+			}
+			object := map[string]interface{}{}
+			for i, property := range groupBy {
+				object[property] = groups[i]
+			}
+			if withCount {
+				object["count"] = count
+			}
+			for i, aggregate := range aggregates {
+				object[aggregate[0]+"_"+aggregate[1]] = results[i]
+			}
+			response = append(response, object)
+		}
+		if len(response) > maxAggregateGroups {
+			http.Error(w, fmt.Sprintf("more than %d groups, narrow the aggregation with filters", maxAggregateGroups), http.StatusUnprocessableEntity)
+			return
+		}
+
+		jsonData, _ := json.MarshalWithOption(response, json.DisableHTMLEscape())
+		etag := bytesToEtag(jsonData)
+		w.Header().Set("Etag", etag)
+		if ifNoneMatchFound(r.Header.Get("If-None-Match"), etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Write(jsonData)
+	}
+
+	aggregateWithAuth := func(w http.ResponseWriter, r *http.Request) {
+		params := mux.Vars(r)
+		if b.authorizationEnabled {
+			auth := access.AuthorizationFromContext(r.Context())
+			if !auth.IsAuthorized(resources, core.OperationList, params, rc.Permits) {
+				http.Error(w, "not authorized", http.StatusUnauthorized)
+				return
+			}
+		}
+
+		aggregate(w, r)
+	}
+
+	export := func(w http.ResponseWriter, r *http.Request) {
+		var (
+			until          time.Time
+			from           time.Time
+			filter         collectionFilter
+			ascendingOrder bool
+			fields         [][]string
+			format         = "ndjson"
+		)
+		urlQuery := r.URL.Query()
+		parameters := map[string]string{}
+		for key, array := range urlQuery {
+			parameters[key] = array[0]
+			isFilter, err := filter.parse(key, array)
+			if err != nil {
+				http.Error(w, "parameter '"+key+"': "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			if isFilter {
+				continue
+			}
+			if len(array) > 1 {
+				http.Error(w, "illegal parameter array '"+key+"'", http.StatusBadRequest)
+				return
+			}
+			value := array[0]
+			switch key {
+			case "format":
+				format = value
+				if format != "ndjson" && format != "csv" {
+					err = fmt.Errorf("format must be ndjson or csv")
+				}
+			case "until":
+				until, err = time.Parse(time.RFC3339, value)
+			case "from":
+				from, err = time.Parse(time.RFC3339, value)
+			case "order":
+				if value != "asc" && value != "desc" {
+					err = fmt.Errorf("order must be asc or desc")
+					break
+				}
+				ascendingOrder = (value == "asc")
+			case "fields":
+				fields, err = parseFields(value)
+			default:
+				err = fmt.Errorf("unknown")
+			}
+			if err != nil {
+				http.Error(w, "parameter '"+key+"': "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		params := mux.Vars(r)
+		selectors := map[string]string{}
+		queryParameters := make([]interface{}, propertiesIndex-ownerIndex+4)
+		for i := ownerIndex; i < propertiesIndex; i++ { // skip ID
+			selectors[columns[i]] = params[columns[i]]
+			queryParameters[i-ownerIndex] = params[columns[i]]
+		}
+		queryParameters[propertiesIndex-ownerIndex+0] = until.IsZero()
+		queryParameters[propertiesIndex-ownerIndex+1] = until.UTC()
+		queryParameters[propertiesIndex-ownerIndex+2] = from.IsZero()
+		queryParameters[propertiesIndex-ownerIndex+3] = from.UTC()
+
+		conditions, err := filter.compile(columnFilter, &queryParameters)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		sqlQuery := readQuery + sqlWhereAll + sqlNotDeleted + conditions
+		if ascendingOrder {
+			sqlQuery += sqlOrderAsc
+		} else {
+			sqlQuery += sqlOrderDesc
+		}
+
+		// a server-side cursor keeps the memory footprint flat, no matter how large the collection is.
+		// Cursors only live within a transaction.
+		tx, err := b.db.BeginTx(r.Context(), &sql.TxOptions{ReadOnly: true})
+		if err != nil {
+			nillog.WithError(err).Errorf("Error 4804: cannot BeginTx")
+			http.Error(w, "Error 4804", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+		_, err = tx.Exec("DECLARE export NO SCROLL CURSOR FOR "+sqlQuery, queryParameters...)
+		if err != nil {
+			if err, ok := err.(*pq.Error); ok && err.Code == "22P02" {
+				http.Error(w, "invalid uuid", http.StatusBadRequest)
+				return
+			}
+			nillog.WithError(err).Errorf("Error 4805: cannot declare cursor for `%s` %+v", sqlQuery, queryParameters)
+			http.Error(w, "Error 4805", http.StatusInternalServerError)
+			return
+		}
+
+		// csv has a fixed set of columns: the identifiers, timestamp and revision, all static
+		// properties, and either the selected fields or the remaining properties as json
+		var csvWriter *csv.Writer
+		var csvHeader []string
+		if format == "csv" {
+			csvHeader = append(csvHeader, columns[:propertiesIndex]...)
+			csvHeader = append(csvHeader, "timestamp", "revision")
+			csvHeader = append(csvHeader, columns[propertiesIndex+1:]...)
+			if fields != nil {
+				for _, path := range fields {
+					csvHeader = append(csvHeader, strings.Join(path, "."))
+				}
+			} else {
+				csvHeader = append(csvHeader, "properties")
+			}
+			w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+			w.Header().Set("Content-Disposition", "attachment; filename=\""+core.Plural(this)+".csv\"")
+			csvWriter = csv.NewWriter(w)
+			csvWriter.Write(csvHeader)
+		} else {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+		}
+		w.WriteHeader(http.StatusOK)
+
+		fetchQuery := fmt.Sprintf("FETCH %d FROM export;", exportBatchSize)
+		for {
+			rows, err := tx.Query(fetchQuery)
+			if err != nil {
+				// the status code is already sent, all we can do is to stop
+				nillog.WithError(err).Errorf("Error 4806: cannot fetch from cursor")
+				return
+			}
+			batch := []map[string]interface{}{}
+			for rows.Next() {
+				var timestamp time.Time
+				var revision int
+				values, object := createScanValuesAndObject(&timestamp, &revision)
+				if err := rows.Scan(values...); err != nil {
+					rows.Close()
+					nillog.WithError(err).Errorf("Error 4806: cannot scan values")
+					return
+				}
+				mergeProperties(object)
+				if rc.Default != nil {
+					var defaultJSON map[string]interface{}
+					json.Unmarshal(rc.Default, &defaultJSON)
+					patchObject(defaultJSON, object)
+					object = defaultJSON
+				}
+				batch = append(batch, object)
+			}
+			err = rows.Err()
+			rows.Close()
+			if err != nil {
+				nillog.WithError(err).Errorf("Error 4806: cannot fetch from cursor")
+				return
+			}
+
+			// request interceptors for list see each batch like a page of the list route
+			jsonData, _ := json.MarshalWithOption(batch, json.DisableHTMLEscape())
+			data, err := b.intercept(r.Context(), resource, core.OperationList, uuid.UUID{}, selectors, parameters, jsonData)
+			if err != nil {
+				nillog.WithError(err).Errorf("Error 4823: cannot request interceptors")
+				return
+			}
+			if data != nil {
+				jsonData = data
+			}
+			var objects []map[string]interface{}
+			decoder := json.NewDecoder(bytes.NewReader(jsonData))
+			decoder.UseNumber()
+			if err := decoder.Decode(&objects); err != nil {
+				nillog.WithError(err).Errorf("Error 4823: cannot decode intercepted items")
+				return
+			}
+
+			for _, object := range objects {
+				if csvWriter == nil {
+					jsonData, _ := json.MarshalWithOption(object, json.DisableHTMLEscape())
+					if fields != nil {
+						jsonData, _ = projectJSON(jsonData, fields, keepFields)
+					}
+					w.Write(append(jsonData, '\n'))
+					continue
+				}
+
+				// selected fields are looked up before the columns are removed from the object
+				var fieldValues []string
+				for _, path := range fields {
+					fieldValues = append(fieldValues, csvValue(lookupPath(object, path)))
+				}
+				record := make([]string, 0, len(csvHeader))
+				for i := 0; i < propertiesIndex; i++ {
+					record = append(record, csvValue(object[columns[i]]))
+					delete(object, columns[i])
+				}
+				record = append(record, csvValue(object["timestamp"]), csvValue(object["revision"]))
+				delete(object, "timestamp")
+				delete(object, "revision")
+				for i := propertiesIndex + 1; i < len(columns); i++ {
+					record = append(record, csvValue(object[columns[i]]))
+					delete(object, columns[i])
+				}
+				if fields != nil {
+					record = append(record, fieldValues...)
+				} else {
+					record = append(record, csvValue(object))
+				}
+				csvWriter.Write(record)
+			}
+			if csvWriter != nil {
+				csvWriter.Flush()
+			}
+			if flusher, ok := w.(http.Flusher); ok {
+				flusher.Flush()
+			}
+			if len(batch) < exportBatchSize {
+				break
+			}
+		}
+	}
+
+	exportWithAuth := func(w http.ResponseWriter, r *http.Request) {
+		params := mux.Vars(r)
+		if b.authorizationEnabled {
+			auth := access.AuthorizationFromContext(r.Context())
+			if !auth.IsAuthorized(resources, core.OperationExport, params, rc.Permits) {
+				http.Error(w, "not authorized", http.StatusUnauthorized)
+				return
+			}
+		}
+
+		export(w, r)
+	}
+
+	importItems := func(w http.ResponseWriter, r *http.Request) {
+		var err error
+		rlog := logger.FromContext(r.Context())
+
+		notify := false
+		batchSize := 100
+		urlQuery := r.URL.Query()
+		for key, array := range urlQuery {
+			if len(array) > 1 {
+				http.Error(w, "illegal parameter array '"+key+"'", http.StatusBadRequest)
+				return
+			}
+			value := array[0]
+			switch key {
+			case "notify":
+				notify, err = strconv.ParseBool(value)
+			case "batch_size":
+				batchSize, err = strconv.Atoi(value)
+				if err == nil && (batchSize < 1 || batchSize > 1000) {
+					err = fmt.Errorf("out of range")
+				}
+			default:
+				err = fmt.Errorf("unknown")
+			}
+			if err != nil {
+				http.Error(w, "parameter '"+key+"': "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		var body io.Reader = r.Body
+		if r.Header.Get("Content-Encoding") == "gzip" || r.Header.Get("Kurbisio-Content-Encoding") == "gzip" {
+			body, err = gzip.NewReader(r.Body)
+			if err != nil {
+				http.Error(w, "invalid gzipped json data: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		reader := bufio.NewReader(body)
+
+		// the body is either a json array, or newline delimited json (one object per line)
+		var decoder *json.Decoder
+		for {
+			c, err := reader.Peek(1)
+			if err != nil || (c[0] != ' ' && c[0] != '\t' && c[0] != '\r' && c[0] != '\n') {
+				if err == nil && c[0] == '[' {
+					decoder = json.NewDecoder(reader)
+					decoder.Token()
+				}
+				break
+			}
+			reader.ReadByte()
+		}
+
+		// next returns the next item of the body and its index, or io.EOF. The index is the
+		// position of the item in the body starting with 0, empty lines do not count.
+		index := -1
+		next := func() (int, []byte, error) {
+			if decoder != nil {
+				if !decoder.More() {
+					return 0, nil, io.EOF
+				}
+				var raw json.RawMessage
+				index++
+				err := decoder.Decode(&raw)
+				return index, raw, err
+			}
+			for {
+				data, err := reader.ReadBytes('\n')
+				if len(data) == 0 && err != nil {
+					return 0, nil, err
+				}
+				if len(bytes.TrimSpace(data)) > 0 {
+					index++
+					return index, data, nil
+				}
+			}
+		}
+
+		// individual items are created with the regular create handler, within a savepoint of
+		// the batch transaction. Notifications are only sent on request.
+		itemURL := *r.URL
+		itemURL.RawQuery = "silent=" + strconv.FormatBool(!notify)
+
+		type importResult struct {
+			Index  int    `json:"index"`
+			Status int    `json:"status"`
+			ID     string `json:"id,omitempty"`
+			Error  string `json:"error,omitempty"`
+		}
+		results := []importResult{}
+		done := false
+		for !done {
+			tx, err := b.db.BeginTx(r.Context(), nil)
+			if err != nil {
+				rlog.WithError(err).Errorf("Error 4807: BeginTx")
+				http.Error(w, "Error 4807", http.StatusInternalServerError)
+				return
+			}
+			itemRequest := r.Clone(contextWithTransaction(r.Context(), tx))
+			itemRequest.URL = &itemURL
+
+			batchStart := len(results)
+			for n := 0; n < batchSize; n++ {
+				index, data, err := next()
+				if err == io.EOF {
+					done = true
+					break
+				}
+				result := importResult{Index: index, Status: http.StatusBadRequest}
+				if err != nil {
+					// a broken json array cannot be continued
+					result.Error = "invalid json data: " + err.Error()
+					results = append(results, result)
+					done = true
+					break
+				}
+				var bodyJSON map[string]interface{}
+				if err := json.Unmarshal(data, &bodyJSON); err != nil || bodyJSON == nil {
+					result.Error = "invalid json data: expected object"
+					results = append(results, result)
+					continue
+				}
+				var illegal string
+				for i := 0; i < propertiesIndex; i++ {
+					if value, ok := bodyJSON[columns[i]]; ok {
+						if _, ok := value.(string); !ok {
+							illegal = columns[i]
+						}
+					}
+				}
+				if illegal != "" {
+					result.Error = "illegal " + illegal
+					results = append(results, result)
+					continue
+				}
+				// provided primary identifiers are honoured, otherwise we create a new one
+				if id, _ := bodyJSON[columns[0]].(string); id == "" || id == "00000000-0000-0000-0000-000000000000" {
+					bodyJSON[columns[0]] = uuid.New().String()
+				}
+				vars := map[string]string{columns[0]: bodyJSON[columns[0]].(string)}
+				for key, value := range mux.Vars(r) {
+					vars[key] = value
+				}
+
+				rec := httptest.NewRecorder()
+				create(rec, mux.SetURLVars(itemRequest, vars), bodyJSON, nil)
+				result.Status = rec.Code
+				if rec.Code == http.StatusCreated {
+					// the created item is authoritative, interceptors may have changed the request body
+					var created map[string]interface{}
+					json.Unmarshal(rec.Body.Bytes(), &created)
+					result.ID, _ = created[columns[0]].(string)
+				} else {
+					result.Error = strings.TrimSpace(rec.Body.String())
+				}
+				results = append(results, result)
+			}
+
+			if err = tx.Commit(); err != nil {
+				rlog.WithError(err).Errorf("Error 4808: Commit")
+				for i := batchStart; i < len(results); i++ {
+					if results[i].Status == http.StatusCreated {
+						results[i] = importResult{Index: results[i].Index, Status: http.StatusInternalServerError, Error: "Error 4808"}
+					}
+				}
+			} else if notify {
+				b.TriggerJobs()
+			}
+		}
+
+		jsonData, _ := json.MarshalWithOption(results, json.DisableHTMLEscape())
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		w.Write(jsonData)
+	}
+
+	importWithAuth := func(w http.ResponseWriter, r *http.Request) {
+		params := mux.Vars(r)
+		if b.authorizationEnabled {
+			auth := access.AuthorizationFromContext(r.Context())
+			if !auth.IsAuthorized(resources, core.OperationCreate, params, rc.Permits) {
+				http.Error(w, "not authorized", http.StatusUnauthorized)
+				return
+			}
+		}
+
+		importItems(w, r)
+	}
+
+	// readRevision reads a revision of the item addressed by params from the log, tombstones only if requested.
+	// If the revision cannot be read, it writes the error response and returns nil.
+	readRevision := func(w http.ResponseWriter, r *http.Request, params map[string]string, revision int, withTombstone bool) (map[string]interface{}, time.Time) {
+		resourceID := params[this+"_id"]
+		if resourceID == "all" {
+			http.Error(w, "all is not a valid "+this, http.StatusBadRequest)
+			return nil, time.Time{}
+		}
+		if singleton {
+			if params[owner+"_id"] == "all" {
+				if resourceID == "" {
+					http.Error(w, "all is not a valid "+owner+"_id for a single "+this, http.StatusBadRequest)
+					return nil, time.Time{}
+				}
+				params[owner+"_id"] = resourceID
+			} else if resourceID != "" && resourceID != params[owner+"_id"] {
+				http.Error(w, "identifier mismatch for "+this, http.StatusBadRequest)
+				return nil, time.Time{}
+			}
+		}
+
+		queryParameters := make([]interface{}, propertiesIndex, propertiesIndex+1)
+		for i := 0; i < propertiesIndex; i++ {
+			queryParameters[i] = params[columns[i]]
+		}
+		queryParameters = append(queryParameters, revision)
+		sqlQuery := readQueryLog + sqlWhereOne + fmt.Sprintf(" AND revision=$%d LIMIT 1;", len(queryParameters))
+		if !withTombstone {
+			sqlQuery = readQueryLog + sqlWhereOne + sqlNotTombstone + fmt.Sprintf(" AND revision=$%d LIMIT 1;", len(queryParameters))
+		}
+		var timestamp time.Time
+		values, object := createScanValuesAndObject(&timestamp, new(int))
+		err := b.db.QueryRow(sqlQuery, queryParameters...).Scan(values...)
+		if err == csql.ErrNoRows {
+			http.Error(w, fmt.Sprintf("no such revision %d", revision), http.StatusNotFound)
+			return nil, time.Time{}
+		}
+		if err != nil {
+			if err, ok := err.(*pq.Error); ok && err.Code == "22P02" {
+				http.Error(w, "invalid uuid", http.StatusBadRequest)
+				return nil, time.Time{}
+			}
+			logger.FromContext(r.Context()).WithError(err).Errorf("Error 4817: cannot read revision")
+			http.Error(w, "Error 4817", http.StatusInternalServerError)
+			return nil, time.Time{}
+		}
+		mergeProperties(object)
+		return object, timestamp
+	}
+
+	// restoreRevisionWithAuth writes a revision from the log back as a new revision. It is a PUT of the historic
+	// object, hence validation, interceptors and notifications apply as usual. An item which was deleted is
+	// created again, which requires the create operation. Tombstones cannot be restored.
+	restoreRevisionWithAuth := func(w http.ResponseWriter, r *http.Request) {
+		params := mux.Vars(r)
+		if b.authorizationEnabled {
+			auth := access.AuthorizationFromContext(r.Context())
+			if !auth.IsAuthorized(resources, core.OperationRead, params, rc.Permits) ||
+				!auth.IsAuthorized(resources, core.OperationUpdate, params, rc.Permits) {
+				http.Error(w, "not authorized", http.StatusUnauthorized)
+				return
+			}
+		}
+		revision, err := strconv.Atoi(params["revision"])
+		if err != nil || revision < 1 {
+			http.Error(w, "invalid revision", http.StatusBadRequest)
+			return
+		}
+		object, _ := readRevision(w, r, params, revision, false)
+		if object == nil {
+			return
+		}
+		// the restored object keeps its timestamp and gets a new revision
+		delete(object, "timestamp")
+		delete(object, "revision")
+		body, _ := json.MarshalWithOption(object, json.DisableHTMLEscape())
+
+		vars := map[string]string{}
+		for i := 0; i < propertiesIndex; i++ {
+			vars[columns[i]] = params[columns[i]]
+		}
+		req := r.Clone(r.Context())
+		req.Method = http.MethodPut
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		req.Header.Del("Content-Encoding")
+		req.Header.Del("Kurbisio-Content-Encoding")
+		upsertWithAuth(w, mux.SetURLVars(req, vars), nil)
+	}
+
+	// diffWithAuth returns the json patch which turns one revision from the log into another
+	diffWithAuth := func(w http.ResponseWriter, r *http.Request) {
+		params := mux.Vars(r)
+		if b.authorizationEnabled {
+			auth := access.AuthorizationFromContext(r.Context())
+			if !auth.IsAuthorized(resources, core.OperationRead, params, rc.Permits) {
+				http.Error(w, "not authorized", http.StatusUnauthorized)
+				return
+			}
+		}
+		revisions := map[string]int{}
+		for key, array := range r.URL.Query() {
+			if key != "from" && key != "to" {
+				http.Error(w, "parameter '"+key+"': unknown query parameter", http.StatusBadRequest)
+				return
+			}
+			revision, err := strconv.Atoi(array[0])
+			if err == nil && revision < 1 {
+				err = fmt.Errorf("out of range")
+			}
+			if err != nil {
+				http.Error(w, "parameter '"+key+"': "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			revisions[key] = revision
+		}
+		if len(revisions) != 2 {
+			http.Error(w, "parameters 'from' and 'to' are required", http.StatusBadRequest)
+			return
+		}
+
+		from, fromTimestamp := readRevision(w, r, params, revisions["from"], true)
+		if from == nil {
+			return
+		}
+		to, toTimestamp := readRevision(w, r, params, revisions["to"], true)
+		if to == nil {
+			return
+		}
+
+		// timestamp and revision are reported separately, the patch only covers the content
+		var documents [2]interface{}
+		for i, object := range []map[string]interface{}{from, to} {
+			delete(object, "timestamp")
+			delete(object, "revision")
+			data, _ := json.Marshal(object)
+			json.Unmarshal(data, &documents[i])
+		}
+		response := map[string]interface{}{
+			"from":           revisions["from"],
+			"to":             revisions["to"],
+			"from_timestamp": fromTimestamp,
+			"to_timestamp":   toTimestamp,
+			"patch":          diffJSON(documents[0], documents[1]),
+		}
+		jsonData, _ := json.MarshalWithOption(response, json.DisableHTMLEscape())
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		w.Write(jsonData)
+	}
+
+	restoreWithAuth := func(w http.ResponseWriter, r *http.Request) {
+		rlog := logger.FromContext(r.Context())
+		params := mux.Vars(r)
+		if b.authorizationEnabled {
+			auth := access.AuthorizationFromContext(r.Context())
+			if !auth.IsAuthorized(resources, core.OperationDelete, params, rc.Permits) {
+				http.Error(w, "not authorized", http.StatusUnauthorized)
+				return
+			}
+		}
+		resourceID := params[this+"_id"]
+		if resourceID == "all" {
+			http.Error(w, "all is not a valid "+this, http.StatusBadRequest)
+			return
+		}
+		if singleton {
+			if params[owner+"_id"] == "all" {
+				if resourceID == "" {
+					http.Error(w, "all is not a valid "+owner+"_id for restoring a single "+this, http.StatusBadRequest)
+					return
+				}
+				params[owner+"_id"] = resourceID
+			} else if resourceID != "" && resourceID != params[owner+"_id"] {
+				http.Error(w, "identifier mismatch for "+this, http.StatusBadRequest)
+				return
+			}
+		}
+
+		primaryID, err := uuid.Parse(params[columns[0]])
+		if err != nil {
+			http.Error(w, "broken primary identifier", http.StatusBadRequest)
+			return
+		}
+
+		queryParameters := make([]interface{}, propertiesIndex)
+		for i := 0; i < propertiesIndex; i++ {
+			queryParameters[i] = params[columns[i]]
+		}
+
+		tx, err := b.beginTx(r.Context())
+		if err != nil {
+			rlog.WithError(err).Errorf("Error 4816: cannot BeginTx")
+			http.Error(w, "Error 4816", http.StatusInternalServerError)
+			return
+		}
+
+		var deletedAt time.Time
+		err = tx.QueryRow(restoreQueries[0], queryParameters...).Scan(&deletedAt)
+		if err == csql.ErrNoRows {
+			tx.Rollback()
+			http.Error(w, "no such deleted "+this, http.StatusNotFound)
+			return
+		}
+		var timestamp time.Time
+		values, object := createScanValuesAndObject(&timestamp, new(int))
+		if err == nil {
+			err = tx.QueryRow(restoreQueries[1], queryParameters...).Scan(values...)
+		}
+		if err, ok := err.(*pq.Error); ok && err.Code == "23505" {
+			// another item uses the external index values of the deleted item now
+			tx.Rollback()
+			http.Error(w, "cannot restore "+this+": constraint violation", http.StatusConflict)
+			return
+		}
+		if err != nil {
+			tx.Rollback()
+			rlog.WithError(err).Errorf("Error 4816: cannot restore")
+			http.Error(w, "Error 4816", http.StatusInternalServerError)
+			return
+		}
+
+		if parentDeletedQuery != "" {
+			// children of soft deleted items can only be restored together with them
+			var parentDeleted bool
+			err = tx.QueryRow(parentDeletedQuery, values[ownerIndex]).Scan(&parentDeleted)
+			if err == nil && parentDeleted {
+				tx.Rollback()
+				http.Error(w, dependencies[len(dependencies)-1]+" is deleted, restore it first", http.StatusConflict)
+				return
+			}
+		}
+		for _, child := range softDeletedChildren {
+			query := fmt.Sprintf("UPDATE %s.\"%s\" SET deleted_at=NULL, revision = revision + 1 WHERE %s_id=$1 AND deleted_at=$2", schema, child, primary)
+			parameters := []interface{}{&primaryID, deletedAt}
+			if childColumns, ok := b.logColumns[child]; ok {
+				// restored children with log follow their tombstones in the log, like the item
+				names := strings.Join(childColumns, ", ")
+				query = "WITH restored AS (" + query + " RETURNING " + names + ", revision) " +
+					fmt.Sprintf("INSERT INTO %s.\"%s/log\" (%s, timestamp, revision, author, request_id, operation) ", schema, child, names) +
+					fmt.Sprintf("SELECT %s, $3, revision, $4, $5, '%s' FROM restored", names, core.OperationCreate)
+				parameters = append(parameters, time.Now().UTC(), access.IdentityFromContext(r.Context()), logger.RequestIDFromContext(r.Context()))
+			}
+			if _, err = tx.Exec(query+";", parameters...); err != nil {
+				tx.Rollback()
+				if err, ok := err.(*pq.Error); ok && err.Code == "23505" {
+					http.Error(w, "cannot restore "+this+": constraint violation in children", http.StatusConflict)
+					return
+				}
+				rlog.WithError(err).Errorf("Error 4816: cannot restore children")
+				http.Error(w, "Error 4816", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		// the restored item follows its tombstone in the log
+		mergeProperties(object)
+		jsonData, _ := json.MarshalWithOption(object, json.DisableHTMLEscape())
+		if rc.WithLog {
+			timestamp = time.Now().UTC() // the log always uses current time (UTC) as timestamp
+			if _, err = tx.Exec(insertQueryLog, logValues(r.Context(), values, string(core.OperationCreate))...); err != nil {
+				tx.Rollback()
+				rlog.WithError(err).Errorf("Error 4816: create log")
+				http.Error(w, "Error 4816", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		err = b.commitWithNotification(r.Context(), tx, resource, core.OperationCreate, primaryID, jsonData)
+		if err != nil {
+			rlog.WithError(err).Errorf("Error 4816: cannot commit")
+			http.Error(w, "Error 4816", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		w.Write(jsonData)
+	}
+
+	// purge removes soft deleted items after the retention period, children are removed by the database cascade.
+	// The items and their children got their tombstones when they were soft deleted.
+	if softDelete {
+		b.addHousekeeping(resource, func(ctx context.Context) error {
+			purgeBefore := time.Now().UTC().Add(-retention)
+			if !rc.needsKSS || b.KssDriver == nil {
+				_, err := b.db.ExecContext(ctx, purgeQuery+";", purgeBefore)
+				return err
+			}
+			rows, err := b.db.QueryContext(ctx, purgeQuery+sqlReturnMeta+";", purgeBefore)
+			if err != nil {
+				return err
+			}
+			defer rows.Close()
+			for rows.Next() {
+				var timestamp time.Time
+				values, _ := createScanValuesAndObjectWithMeta(true, &timestamp, nil)
+				if err := rows.Scan(values...); err != nil {
+					return err
+				}
+				var key string
+				for i := 0; i < propertiesIndex; i++ {
+					key += "/" + resources[i] + "_id/" + values[propertiesIndex-i-1].(*uuid.UUID).String()
+				}
+				if err := b.KssDriver.DeleteAllWithPrefix(key); err != nil {
+					logger.FromContext(ctx).WithError(err).Error("Could not delete key ", key)
+				}
+			}
+			return rows.Err()
+		})
+	}
+
+	// retention deletes expired items in batches, children are removed by the database cascade
+	if rc.Retention != nil {
+		var expiredConditions []string
+		if rc.Retention.MaxAgeSeconds > 0 {
+			expiredConditions = append(expiredConditions, "timestamp < $1")
+		}
+		if property := rc.Retention.ExpiresAt; property != "" {
+			found := false
+			for i := staticPropertiesIndex; i < propertiesEndIndex; i++ {
+				found = found || columns[i] == property
+			}
+			if !found {
+				nillog.Errorf("retention of %s: expires_at %s is neither a static nor a searchable property", resource, property)
+				panic("invalid configuration")
+			}
+			switch propertyTypes[property] {
+			case "timestamp":
+				expiredConditions = append(expiredConditions, fmt.Sprintf(`"%s" < $%d`, property, len(expiredConditions)+1))
+			case "":
+				// the property is a varchar, hence only valid times can be compared. The case guards the
+				// cast, postgres does not guarantee the evaluation order of and.
+				expiredConditions = append(expiredConditions, fmt.Sprintf(
+					`(CASE WHEN "%s" ~ '^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}:\d{2})$' THEN "%s"::timestamptz END < $%d)`,
+					property, property, len(expiredConditions)+1))
+			default:
+				nillog.Errorf("retention of %s: expires_at %s is of type %s", resource, property, propertyTypes[property])
+				panic("invalid configuration")
+			}
+		}
+		if len(expiredConditions) == 0 {
+			nillog.Errorf("retention of %s needs max_age_seconds or expires_at", resource)
+			panic("invalid configuration")
+		}
+		// a batch is selected and locked first, so that its tombstones and its deletion match
+		expireSelectQuery := fmt.Sprintf("SELECT %s_id FROM %s.\"%s\" WHERE %s LIMIT %d FOR UPDATE;",
+			primary, schema, resource, strings.Join(expiredConditions, " OR "), expireBatchSize)
+		expireWhere := fmt.Sprintf("WHERE %s_id = ANY($1::uuid[])", primary)
+		expireQuery := fmt.Sprintf("DELETE FROM %s.\"%s\" ", schema, resource) + expireWhere + sqlReturnObject + ";"
+
+		// expireBatch deletes one batch of expired items and returns the number of deleted items
+		expireBatch := func(ctx context.Context) (int, error) {
+			now := time.Now().UTC()
+			tx, err := b.db.BeginTx(ctx, nil)
+			if err != nil {
+				return 0, err
+			}
+			defer tx.Rollback()
+			var queryParameters []interface{}
+			if rc.Retention.MaxAgeSeconds > 0 {
+				queryParameters = append(queryParameters, now.Add(-time.Duration(rc.Retention.MaxAgeSeconds)*time.Second))
+			}
+			if rc.Retention.ExpiresAt != "" {
+				queryParameters = append(queryParameters, now)
+			}
+			rows, err := tx.Query(expireSelectQuery, queryParameters...)
+			if err != nil {
+				return 0, err
+			}
+			var ids []string
+			for rows.Next() {
+				var id string
+				if err = rows.Scan(&id); err != nil {
+					rows.Close()
+					return 0, err
+				}
+				ids = append(ids, id)
+			}
+			rows.Close()
+			if err = rows.Err(); err != nil || len(ids) == 0 {
+				return 0, err
+			}
+			// soft deleted items have their tombstone already
+			if err = writeTombstones(ctx, tx, expireWhere+sqlNotDeleted, []interface{}{pq.Array(ids)}); err != nil {
+				return 0, err
+			}
+			rows, err = tx.Query(expireQuery, pq.Array(ids))
+			if err != nil {
+				return 0, err
+			}
+			var objects []map[string]interface{}
+			var keys []string
+			for rows.Next() {
+				var timestamp time.Time
+				values, object := createScanValuesAndObject(&timestamp, new(int))
+				if err = rows.Scan(values...); err != nil {
+					rows.Close()
+					return 0, err
+				}
+				mergeProperties(object)
+				objects = append(objects, object)
+				var key string
+				for i := 0; i < propertiesIndex; i++ {
+					key += "/" + resources[i] + "_id/" + values[propertiesIndex-i-1].(*uuid.UUID).String()
+				}
+				keys = append(keys, key)
+			}
+			rows.Close()
+			if err = rows.Err(); err != nil {
+				return 0, err
+			}
+			if rc.Retention.Notify {
+				for _, object := range objects {
+					jsonData, _ := json.MarshalWithOption(object, json.DisableHTMLEscape())
+					err = b.addNotification(ctx, tx, resource, core.OperationDelete, *object[columns[0]].(*uuid.UUID), jsonData)
+					if err != nil {
+						return 0, err
+					}
+				}
+			}
+			if err = tx.Commit(); err != nil {
+				return 0, err
+			}
+			if rc.Retention.Notify && len(objects) > 0 {
+				b.TriggerJobs()
+			}
+			if rc.needsKSS && b.KssDriver != nil {
+				for _, key := range keys {
+					if err := b.KssDriver.DeleteAllWithPrefix(key); err != nil {
+						logger.FromContext(ctx).WithError(err).Error("Could not delete key ", key)
+					}
+				}
+			}
+			return len(objects), nil
+		}
+
+		b.addHousekeeping(resource, func(ctx context.Context) error {
+			for {
+				n, err := expireBatch(ctx)
+				if err != nil || n < expireBatchSize {
+					return err
+				}
+			}
+		})
+	}
+
+	// store the collection functions  for later usage in relations
+	b.collectionFunctions[resource] = &collectionFunctions{
+		permits: rc.Permits,
+		list:    list,
+		read:    read,
+	}
+
+	// CREATE
+	if !singleton {
+		router.Handle(listRoute, handlers.CompressHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			logger.FromContext(r.Context()).Infoln("called route for", r.URL, r.Method)
+			b.idempotent(w, r, createWithAuth)
+		}))).Methods(http.MethodOptions, http.MethodPost)
+	}
+
+	// UPDATE/CREATE with id in json
+	router.Handle(listRoute, handlers.CompressHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logger.FromContext(r.Context()).Infoln("called route for", r.URL, r.Method)
+		upsertWithAuth(w, r, nil)
+	}))).Methods(http.MethodOptions, http.MethodPut, http.MethodPatch)
+
+	// UPDATE/CREATE by external index, must come before UPDATE/CREATE with fully qualified path
+	if !singleton {
+		for i := range externalIndices {
+			externalIndex := &externalIndices[i]
+			router.Handle(listRoute+"/"+externalIndex.route(), handlers.CompressHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				logger.FromContext(r.Context()).Infoln("called route for", r.URL, r.Method)
+				upsertWithAuth(w, r, externalIndex)
+			}))).Methods(http.MethodOptions, http.MethodPut, http.MethodPatch)
+		}
+	}
+
+	// UPDATE/CREATE with fully qualified path
+	router.Handle(itemRoute, handlers.CompressHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logger.FromContext(r.Context()).Infoln("called route for", r.URL, r.Method)
+		upsertWithAuth(w, r, nil)
+	}))).Methods(http.MethodOptions, http.MethodPut, http.MethodPatch)
+
+	// AGGREGATE, must come before READ
+	if !singleton {
+		router.Handle(listRoute+"/aggregate", handlers.CompressHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			logger.FromContext(r.Context()).Infoln("called route for", r.URL, r.Method)
+			aggregateWithAuth(w, r)
+		}))).Methods(http.MethodOptions, http.MethodGet)
+	}
+
+	// EXPORT, must come before READ
+	if !singleton {
+		router.Handle(listRoute+"/export", handlers.CompressHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			logger.FromContext(r.Context()).Infoln("called route for", r.URL, r.Method)
+			exportWithAuth(w, r)
+		}))).Methods(http.MethodOptions, http.MethodGet)
+	}
+
+	// IMPORT
+	if !singleton {
+		router.Handle(listRoute+"/import", handlers.CompressHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			logger.FromContext(r.Context()).Infoln("called route for", r.URL, r.Method)
+			importWithAuth(w, r)
+		}))).Methods(http.MethodOptions, http.MethodPost)
+	}
+
+	// LOOKUP BY EXTERNAL INDEX, must come before READ
+	if !singleton {
+		for _, externalIndex := range externalIndices {
+			externalIndex := externalIndex
+			router.Handle(listRoute+"/"+externalIndex.route(), handlers.CompressHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				logger.FromContext(r.Context()).Infoln("called route for", r.URL, r.Method)
+				lookupWithAuth(w, r, externalIndex)
+			}))).Methods(http.MethodOptions, http.MethodGet)
+		}
+	}
+
+	// READ
+	router.Handle(itemRoute, handlers.CompressHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logger.FromContext(r.Context()).Infoln("called route for", r.URL, r.Method)
+		readWithAuth(w, r)
+	}))).Methods(http.MethodOptions, http.MethodGet)
+
+	// PUT FOR STATIC PROPERTIES
+	for i := staticPropertiesIndex; i < len(columns); i++ {
+		property := columns[i]
 		propertyRoute := fmt.Sprintf("%s/%s/{%s}", itemRoute, property, property)
 		router.Handle(propertyRoute, handlers.CompressHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			logger.FromContext(r.Context()).Infoln("called route for", r.URL, r.Method)
@@ -2184,12 +4309,28 @@ func (b *Backend) createCollectionResource(router *mux.Router, rc collectionConf
 		clearWithAuth(w, r)
 	}))).Methods(http.MethodOptions, http.MethodDelete)
 
+	// RESTORE
+	if softDelete {
+		router.Handle(itemRoute+"/restore", handlers.CompressHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			logger.FromContext(r.Context()).Infoln("called route for", r.URL, r.Method)
+			restoreWithAuth(w, r)
+		}))).Methods(http.MethodOptions, http.MethodPost)
+	}
+
 	// LOG
 	if rc.WithLog {
 		router.Handle(logRoute, handlers.CompressHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			logger.FromContext(r.Context()).Infoln("called route for", r.URL, r.Method)
 			logWithAuth(w, r, nil)
 		}))).Methods(http.MethodOptions, http.MethodGet)
+		router.Handle(logRoute+"/diff", handlers.CompressHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			logger.FromContext(r.Context()).Infoln("called route for", r.URL, r.Method)
+			diffWithAuth(w, r)
+		}))).Methods(http.MethodOptions, http.MethodGet)
+		router.Handle(logRoute+"/{revision}/restore", handlers.CompressHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			logger.FromContext(r.Context()).Infoln("called route for", r.URL, r.Method)
+			restoreRevisionWithAuth(w, r)
+		}))).Methods(http.MethodOptions, http.MethodPost)
 	}
 
 	if !singleton {
@@ -2206,7 +4347,7 @@ func (b *Backend) createCollectionResource(router *mux.Router, rc collectionConf
 	// UPDATE
 	router.Handle(singletonRoute, handlers.CompressHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		logger.FromContext(r.Context()).Infoln("called route for", r.URL, r.Method)
-		upsertWithAuth(w, r)
+		upsertWithAuth(w, r, nil)
 	}))).Methods(http.MethodOptions, http.MethodPut, http.MethodPatch)
 
 	// DELETE
@@ -2215,12 +4356,28 @@ func (b *Backend) createCollectionResource(router *mux.Router, rc collectionConf
 		deleteWithAuth(w, r)
 	}))).Methods(http.MethodOptions, http.MethodDelete)
 
+	// RESTORE
+	if softDelete {
+		router.Handle(singletonRoute+"/restore", handlers.CompressHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			logger.FromContext(r.Context()).Infoln("called route for", r.URL, r.Method)
+			restoreWithAuth(w, r)
+		}))).Methods(http.MethodOptions, http.MethodPost)
+	}
+
 	// LOG
 	if rc.WithLog {
 		router.Handle(singletonLogRoute, handlers.CompressHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			logger.FromContext(r.Context()).Infoln("called route for", r.URL, r.Method)
 			logWithAuth(w, r, nil)
 		}))).Methods(http.MethodOptions, http.MethodGet)
+		router.Handle(singletonLogRoute+"/diff", handlers.CompressHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			logger.FromContext(r.Context()).Infoln("called route for", r.URL, r.Method)
+			diffWithAuth(w, r)
+		}))).Methods(http.MethodOptions, http.MethodGet)
+		router.Handle(singletonLogRoute+"/{revision}/restore", handlers.CompressHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			logger.FromContext(r.Context()).Infoln("called route for", r.URL, r.Method)
+			restoreRevisionWithAuth(w, r)
+		}))).Methods(http.MethodOptions, http.MethodPost)
 	}
 
 }
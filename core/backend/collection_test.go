@@ -7,13 +7,25 @@
 package backend_test
 
 import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/goccy/go-json"
+
 	"github.com/google/uuid"
+	"github.com/relabs-tech/kurbisio/core"
+	"github.com/relabs-tech/kurbisio/core/access"
+	"github.com/relabs-tech/kurbisio/core/backend"
+	"github.com/relabs-tech/kurbisio/core/client"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -690,28 +702,2226 @@ func TestSearchPattern(t *testing.T) {
 	}
 }
 
-func TestPatch(t *testing.T) {
-	a := A{ExternalID: t.Name()}
-	if _, err := testService.client.RawPost("/as", a, &a); err != nil {
+func TestFilterOperators(t *testing.T) {
+	jsonConfig := `{
+	"collections": [
+	  {
+		"resource": "a",
+		"external_index": "external_id",
+		"searchable_properties": ["searchable_prop"]
+	  }
+	]
+  }
+`
+	testService := CreateTestService(jsonConfig, t.Name())
+	defer testService.Db.Close()
+
+	due := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	numberOfElements := 10
+	for i := 0; i < numberOfElements; i++ {
+		a := map[string]interface{}{
+			"external_id":     "External_" + strconv.Itoa(i),
+			"searchable_prop": "searchable_prop_" + strconv.Itoa(i%2),
+			"count":           i,
+			"due":             due.AddDate(0, 0, i).Format(time.RFC3339),
+		}
+		if i%5 == 0 {
+			a["optional"] = "set"
+		}
+		if i == 9 {
+			a["count"] = "not a number"
+		}
+		if i == 3 {
+			a["equation"] = "x=y"
+		}
+		if _, err := testService.client.RawPost("/as", a, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	testCases := []struct {
+		filter         string
+		expectedStatus int
+		expectedLength int
+	}{
+		{"count>=5", http.StatusOK, 4},
+		{"count<2", http.StatusOK, 2},
+		{"count>2.5", http.StatusOK, 6},
+		{"due>" + due.AddDate(0, 0, 7).Format(time.RFC3339), http.StatusOK, 2},
+		{"due<=" + due.AddDate(0, 0, 1).Format(time.RFC3339), http.StatusOK, 2},
+		{"searchable_prop!=searchable_prop_0", http.StatusOK, 5},
+		{"optional!=set", http.StatusOK, 8},
+		{"external_id~*external_1%", http.StatusOK, 1},
+		{"external_id~external_1%", http.StatusOK, 0},
+		{"external_id IN (External_1,External_2, External_3)", http.StatusOK, 3},
+		{"count in (1,2)", http.StatusOK, 2},
+		{"optional IS NULL", http.StatusOK, 8},
+		{"optional is not null", http.StatusOK, 2},
+		{"searchable_prop IS NULL", http.StatusOK, 0},
+		{"count>abc", http.StatusBadRequest, 0},
+		{"count IN ()", http.StatusBadRequest, 0},
+		{"a_id=invalid", http.StatusBadRequest, 0},
+		{"a_id>5", http.StatusBadRequest, 0},
+		{"=value", http.StatusBadRequest, 0},
+		{"novalue", http.StatusBadRequest, 0},
+		// values may contain '=' after the operators = and !=, anything else is ambiguous
+		{"equation=x=y", http.StatusOK, 1},
+		{"optional!=x=y", http.StatusOK, 10},
+		{"equation~x=y", http.StatusBadRequest, 0},
+		{"count>1=2", http.StatusBadRequest, 0},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.filter, func(t *testing.T) {
+			var collectionResult []A
+			status, _ := testService.client.RawGet("/as?filter="+url.QueryEscape(tc.filter), &collectionResult)
+			if status != tc.expectedStatus {
+				t.Fatalf("Expected status %d, got status: %d", tc.expectedStatus, status)
+			}
+			if len(collectionResult) != tc.expectedLength {
+				t.Fatalf("unexpected number of items in collection, expected %d, got %d: %s", tc.expectedLength, len(collectionResult), asJSON(collectionResult))
+			}
+		})
+	}
+
+	// search only works on searchable properties
+	status, _ := testService.client.RawGet("/as?search="+url.QueryEscape("count>1"), &[]A{})
+	if status != http.StatusBadRequest {
+		t.Fatal("expected bad request when searching a json property, got", status)
+	}
+}
+
+func TestFilterOr(t *testing.T) {
+	jsonConfig := `{
+	"collections": [
+	  {
+		"resource": "a",
+		"searchable_properties": ["searchable_prop"]
+	  }
+	]
+  }
+`
+	testService := CreateTestService(jsonConfig, t.Name())
+	defer testService.Db.Close()
+
+	statuses := []string{"failed", "overdue", "done", "open"}
+	for i := 0; i < 8; i++ {
+		a := map[string]interface{}{
+			"searchable_prop": "searchable_prop_" + strconv.Itoa(i%2),
+			"status":          statuses[i%4],
+		}
+		if _, err := testService.client.RawPost("/as", a, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var collectionResult []A
+	_, err := testService.client.Collection("a").WithFilterOr("status=failed", "status=overdue").List(&collectionResult)
+	if err != nil {
 		t.Fatal(err)
 	}
+	if len(collectionResult) != 4 {
+		t.Fatalf("unexpected number of items in collection, expected 4, got %d", len(collectionResult))
+	}
 
-	var result A
-	status, err := testService.client.RawPatch("/as/"+a.AID.String(),
-		map[string]string{"foo": "new_foo"}, &result)
+	// or-groups are combined with filters and other groups with logical AND
+	_, err = testService.client.Collection("a").
+		WithFilterOr("status=failed", "status=overdue").
+		WithFilterOr("status=overdue", "status=done").
+		WithFilter("searchable_prop", "searchable_prop_1").List(&collectionResult)
 	if err != nil {
 		t.Fatal(err)
 	}
-	if status != http.StatusOK || result.Foo != "new_foo" {
-		t.Fatalf("patch did not work")
+	if len(collectionResult) != 2 || collectionResult[0].SearchableProp != "searchable_prop_1" {
+		t.Fatalf("unexpected items in collection: %s", asJSON(collectionResult))
 	}
 
-	// patching an unknown object should return 404 not found
-	unknownID := uuid.New()
-	status, err = testService.client.RawPatch("/as/"+unknownID.String(),
-		map[string]string{"foo": "new_foo"}, &result)
-	if err == nil || status != http.StatusNotFound {
-		t.Fatalf("patch of unknown object did return %d", status)
+	// the unnamed group
+	_, err = testService.client.RawGet("/as?filter_or=status=open&filter_or="+url.QueryEscape("status IS NULL"), &collectionResult)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(collectionResult) != 2 {
+		t.Fatalf("unexpected number of items in collection, expected 2, got %d", len(collectionResult))
+	}
+
+	status, _ := testService.client.RawGet("/as?filter_or.x=status", &collectionResult)
+	if status != http.StatusBadRequest {
+		t.Fatal("expected bad request for malformed filter, got", status)
+	}
+}
+
+func TestFilterNestedProperties(t *testing.T) {
+	jsonConfig := `{
+	"collections": [
+	  {
+		"resource": "a"
+	  }
+	]
+  }
+`
+	testService := CreateTestService(jsonConfig, t.Name())
+	defer testService.Db.Close()
+
+	for i := 0; i < 6; i++ {
+		a := map[string]interface{}{
+			"hardware": map[string]interface{}{
+				"revision": i,
+				"vendor":   map[string]string{"name": "vendor_" + strconv.Itoa(i%2)},
+			},
+			"tags": []interface{}{"tag_" + strconv.Itoa(i%3), i},
+		}
+		if i == 0 {
+			a["tags"] = []string{"beta"}
+		}
+		if _, err := testService.client.RawPost("/as", a, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	testCases := []struct {
+		query          string
+		expectedStatus int
+		expectedLength int
+	}{
+		{"filter=hardware.revision=3", http.StatusOK, 1},
+		{"filter=hardware.revision>=3", http.StatusOK, 3},
+		{"filter=hardware.vendor.name=vendor_1", http.StatusOK, 3},
+		{"filter=hardware.unknown.name=vendor_1", http.StatusOK, 0},
+		{"filter=" + url.QueryEscape("hardware.vendor IS NOT NULL"), http.StatusOK, 6},
+		{"filter=" + url.QueryEscape("tags@>beta"), http.StatusOK, 1},
+		{"filter=" + url.QueryEscape("tags@>tag_1"), http.StatusOK, 2},
+		{"filter=" + url.QueryEscape("tags@>4"), http.StatusOK, 1},
+		{"filter_or=" + url.QueryEscape("tags@>beta") + "&filter_or=hardware.revision=5", http.StatusOK, 2},
+		{"filter=hardware..revision=3", http.StatusBadRequest, 0},
+		{"sort=-hardware.revision&limit=1", http.StatusOK, 1},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.query, func(t *testing.T) {
+			var collectionResult []map[string]interface{}
+			status, _ := testService.client.RawGet("/as?"+tc.query, &collectionResult)
+			if status != tc.expectedStatus {
+				t.Fatalf("Expected status %d, got status: %d", tc.expectedStatus, status)
+			}
+			if len(collectionResult) != tc.expectedLength {
+				t.Fatalf("unexpected number of items in collection, expected %d, got %d: %s", tc.expectedLength, len(collectionResult), asJSON(collectionResult))
+			}
+		})
+	}
+
+	var collectionResult []map[string]interface{}
+	if _, err := testService.client.RawGet("/as?sort=-hardware.revision&limit=1", &collectionResult); err != nil {
+		t.Fatal(err)
+	}
+	if revision := collectionResult[0]["hardware"].(map[string]interface{})["revision"]; revision != 5.0 {
+		t.Fatal("wrong item after sorting on nested property:", asJSON(collectionResult))
+	}
+}
+
+func TestSort(t *testing.T) {
+	jsonConfig := `{
+	"collections": [
+	  {
+		"resource": "a",
+		"external_index": "external_id",
+		"searchable_properties": ["searchable_prop"]
+	  },
+	  {
+		"resource": "a/b"
+	  },
+	  {
+		"resource": "user"
+	  }
+	],
+	"relations": [
+	  {
+		"left": "a",
+		"right": "user"
+	  }
+	]
+  }
+`
+	testService := CreateTestService(jsonConfig, t.Name())
+	defer testService.Db.Close()
+
+	var user map[string]interface{}
+	if _, err := testService.client.RawPost("/users", map[string]string{}, &user); err != nil {
+		t.Fatal(err)
+	}
+	userPath := "/users/" + user["user_id"].(string)
+
+	numberOfElements := 12
+	for i := 0; i < numberOfElements; i++ {
+		a := A{
+			ExternalID:     "external_id_" + strconv.Itoa(10+i),
+			SearchableProp: "searchable_prop_" + strconv.Itoa(i%3),
+			Foo:            "foo_" + strconv.Itoa(10+(i*7)%numberOfElements),
+		}
+		if _, err := testService.client.RawPost("/as", a, &a); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := testService.client.RawPost("/as/"+a.AID.String()+"/bs", map[string]interface{}{"foo": a.Foo, "rank": i}, nil); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := testService.client.RawPut(userPath+"/as/"+a.AID.String(), nil, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var collectionResult []A
+	_, err := testService.client.RawGet("/as?sort=external_id", &collectionResult)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, a := range collectionResult {
+		if a.ExternalID != "external_id_"+strconv.Itoa(10+i) {
+			t.Fatal("wrong order:", asJSON(collectionResult))
+		}
+	}
+
+	// sort on a json property, descending
+	_, err = testService.client.RawGet("/as?sort=-foo", &collectionResult)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, a := range collectionResult {
+		if a.Foo != "foo_"+strconv.Itoa(10+numberOfElements-1-i) {
+			t.Fatal("wrong order:", asJSON(collectionResult))
+		}
+	}
+
+	// sort on multiple properties, the second one breaks the ties of the first one
+	_, err = testService.client.RawGet("/as?sort=-searchable_prop,%2Bfoo", &collectionResult)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i < len(collectionResult); i++ {
+		previous, current := collectionResult[i-1], collectionResult[i]
+		if previous.SearchableProp < current.SearchableProp ||
+			(previous.SearchableProp == current.SearchableProp && previous.Foo > current.Foo) {
+			t.Fatal("wrong order:", asJSON(collectionResult))
+		}
+	}
+
+	// sort works with pages and with the all wildcard
+	var bs []map[string]interface{}
+	var foos []string
+	for page := 1; page <= 3; page++ {
+		_, err = testService.client.RawGet("/as/all/bs?sort=foo&limit=4&page="+strconv.Itoa(page), &bs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, b := range bs {
+			foos = append(foos, b["foo"].(string))
+		}
+	}
+	if len(foos) != numberOfElements {
+		t.Fatalf("expected %d items, got %d", numberOfElements, len(foos))
+	}
+	for i, foo := range foos {
+		if foo != "foo_"+strconv.Itoa(10+i) {
+			t.Fatal("wrong order:", foos)
+		}
+	}
+
+	// json numbers are sorted numerically, not lexically
+	_, err = testService.client.RawGet("/as/all/bs?sort=-rank", &bs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, b := range bs {
+		if b["rank"] != float64(numberOfElements-1-i) {
+			t.Fatal("wrong order:", asJSON(bs))
+		}
+	}
+
+	// sort works on relation routes
+	_, err = testService.client.RawGet(userPath+"/as?sort=-foo", &collectionResult)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(collectionResult) != numberOfElements {
+		t.Fatalf("expected %d items, got %d", numberOfElements, len(collectionResult))
+	}
+	for i, a := range collectionResult {
+		if a.Foo != "foo_"+strconv.Itoa(10+numberOfElements-1-i) {
+			t.Fatal("wrong order:", asJSON(collectionResult))
+		}
+	}
+
+	status, _ := testService.client.RawGet("/as?sort=foo,", &collectionResult)
+	if status != http.StatusBadRequest {
+		t.Fatal("expected bad request for empty sort property, got", status)
+	}
+	status, _ = testService.client.RawGet("/as?sort=foo&order=asc", &collectionResult)
+	if status != http.StatusBadRequest {
+		t.Fatal("expected bad request for sort combined with order, got", status)
+	}
+}
+
+func TestFields(t *testing.T) {
+	jsonConfig := `{
+	"collections": [
+	  {
+		"resource": "a",
+		"static_properties": ["static_prop"],
+		"with_log": true
+	  }
+	],
+	"singletons": [
+	  {
+		"resource": "a/s"
+	  }
+	]
+  }
+`
+	testService := CreateTestService(jsonConfig, t.Name())
+	defer testService.Db.Close()
+
+	a := map[string]interface{}{
+		"static_prop": "static",
+		"name":        "name",
+		"description": "a long description",
+		"settings":    map[string]string{"locale": "de", "theme": "dark"},
+	}
+	var created A
+	if _, err := testService.client.RawPost("/as", a, &created); err != nil {
+		t.Fatal(err)
+	}
+	itemPath := "/as/" + created.AID.String()
+	if _, err := testService.client.RawPut(itemPath+"/s", map[string]string{"name": "s", "other": "other"}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `{"a_id":"` + created.AID.String() + `","name":"name","revision":1,"settings":{"locale":"de"},"static_prop":"static","timestamp":`
+	check := func(path string, data []byte) {
+		if !strings.HasPrefix(string(data), expected) || strings.Contains(string(data), "description") {
+			t.Fatalf("unexpected projection for %s: %s", path, string(data))
+		}
+	}
+
+	var data []byte
+	_, h, err := testService.client.RawGetWithHeader(itemPath+"?fields=name,static_prop,settings.locale", map[string]string{}, &data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(itemPath, data)
+	etag := h.Get("Etag")
+	_, hFull, err := testService.client.RawGetWithHeader(itemPath, map[string]string{}, &data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if etag == "" || etag == hFull.Get("Etag") {
+		t.Fatal("projected response must have its own etag")
+	}
+	status, _, _ := testService.client.RawGetWithHeader(itemPath+"?fields=name,static_prop,settings.locale", map[string]string{"If-None-Match": etag}, &data)
+	if status != http.StatusNotModified {
+		t.Fatal("expected not modified, got", status)
+	}
+
+	for _, path := range []string{"/as", itemPath + "/log"} {
+		var items []json.RawMessage
+		if _, err := testService.client.RawGet(path+"?fields=name,static_prop,settings.locale", &items); err != nil {
+			t.Fatal(err)
+		}
+		if len(items) != 1 {
+			t.Fatalf("unexpected number of items for %s: %d", path, len(items))
+		}
+		check(path, items[0])
+	}
+
+	// children are kept
+	var withChild map[string]interface{}
+	if _, err := testService.client.RawGet(itemPath+"?children=s&fields=name", &withChild); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := withChild["s"]; !ok || withChild["description"] != nil || withChild["name"] != "name" {
+		t.Fatal("unexpected projection with children:", asJSON(withChild))
+	}
+
+	// singletons
+	var singleton map[string]interface{}
+	if _, err := testService.client.RawGet(itemPath+"/s?fields=name", &singleton); err != nil {
+		t.Fatal(err)
+	}
+	if singleton["name"] != "s" || singleton["other"] != nil || singleton["a_id"] != created.AID.String() || singleton["revision"] == nil {
+		t.Fatal("unexpected projection of singleton:", asJSON(singleton))
+	}
+
+	status, _ = testService.client.RawGet("/as?fields=name,,other", &data)
+	if status != http.StatusBadRequest {
+		t.Fatal("expected bad request for invalid fields, got", status)
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	jsonConfig := `{
+	"collections": [
+	  {
+		"resource": "a",
+		"searchable_properties": ["searchable_prop"]
+	  },
+	  {
+		"resource": "a/b",
+		"static_properties": [{"name": "priority", "type": "integer"}, {"name": "urgent", "type": "boolean"}],
+		"permits": [
+		  {
+			"role": "userrole",
+			"operations": ["list"],
+			"selectors": ["a"]
+		  }
+		]
+	  }
+	]
+  }
+`
+	testService := CreateTestService(jsonConfig, t.Name())
+	defer testService.Db.Close()
+
+	var aIDs []uuid.UUID
+	for i := 0; i < 2; i++ {
+		var a A
+		if _, err := testService.client.RawPost("/as", A{SearchableProp: "searchable_prop_" + strconv.Itoa(i)}, &a); err != nil {
+			t.Fatal(err)
+		}
+		aIDs = append(aIDs, a.AID)
+		for j := 0; j < 6; j++ {
+			b := map[string]interface{}{
+				"status":   []string{"done", "failed", "open"}[j%3],
+				"duration": j * (i + 1),
+				"priority": j % 2,
+				"urgent":   j%2 == 0,
+				"owner":    []string{"carl", "anna", "bert"}[j%3],
+			}
+			if j == 5 {
+				b["duration"] = "unknown"
+			}
+			if _, err := testService.client.RawPost("/as/"+a.AID.String()+"/bs", b, nil); err != nil {
+				t.Fatal(err)
+			}
+		}
 	}
 
+	var result []map[string]interface{}
+	_, err := testService.client.Collection("a/b").WithParameter("group_by", "status").WithParameter("count", "").Aggregate(&result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if asJSON(result) != `[{"count":4,"status":"done"},{"count":4,"status":"failed"},{"count":4,"status":"open"}]` {
+		t.Fatal("unexpected aggregation:", asJSON(result))
+	}
+
+	// per parent with sum, min and max, filtered
+	_, err = testService.client.Collection("a/b").
+		WithParameter("group_by", "a_id").
+		WithParameter("sum", "duration").
+		WithParameter("max", "duration,timestamp").
+		WithFilter("status", "failed").Aggregate(&result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result) != 2 {
+		t.Fatal("unexpected aggregation:", asJSON(result))
+	}
+	for _, group := range result {
+		factor := 1.0
+		if group["a_id"] == aIDs[1].String() {
+			factor = 2.0
+		}
+		// failed are j=1 and j=4, the duration of j=5 is not a number but open
+		if group["sum_duration"] != 5*factor || group["max_duration"] != 4*factor || group["max_timestamp"] == nil || group["count"] != nil {
+			t.Fatal("unexpected aggregation:", asJSON(result))
+		}
+	}
+
+	// typed properties keep their types, min and max work on JSON strings
+	result = nil
+	_, err = testService.client.RawGet("/as/all/bs/aggregate?group_by=priority,urgent", &result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if asJSON(result) != `[{"count":6,"priority":0,"urgent":true},{"count":6,"priority":1,"urgent":false}]` {
+		t.Fatal("unexpected aggregation:", asJSON(result))
+	}
+	result = nil
+	_, err = testService.client.RawGet("/as/all/bs/aggregate?min=owner,urgent,priority&max=owner", &result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if asJSON(result) != `[{"max_owner":"carl","min_owner":"anna","min_priority":0,"min_urgent":false}]` {
+		t.Fatal("unexpected aggregation:", asJSON(result))
+	}
+	if status, _ := testService.client.RawGet("/as/all/bs/aggregate?sum=urgent", &result); status != http.StatusBadRequest {
+		t.Fatal("expected bad request, got", status)
+	}
+
+	// the all wildcard versus a specific parent
+	result = nil
+	_, err = testService.client.RawGet("/as/"+aIDs[0].String()+"/bs/aggregate", &result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if asJSON(result) != `[{"count":6}]` {
+		t.Fatal("unexpected aggregation:", asJSON(result))
+	}
+
+	// the list permit protects the aggregation
+	userClient := testService.clientNoAuth.WithAuthorization(&access.Authorization{
+		Roles:     []string{"userrole"},
+		Selectors: map[string]string{"a_id": aIDs[0].String()},
+	})
+	if status, _ := userClient.RawGet("/as/"+aIDs[0].String()+"/bs/aggregate", &result); status != http.StatusOK {
+		t.Fatal("expected ok, got", status)
+	}
+	if status, _ := userClient.RawGet("/as/"+aIDs[1].String()+"/bs/aggregate", &result); status != http.StatusUnauthorized {
+		t.Fatal("expected unauthorized, got", status)
+	}
+
+	if status, _ := testService.client.RawGet("/as/all/bs/aggregate?group_by=status,", &result); status != http.StatusBadRequest {
+		t.Fatal("expected bad request, got", status)
+	}
+	if status, _ := testService.client.RawGet("/as/all/bs/aggregate?unknown=1", &result); status != http.StatusBadRequest {
+		t.Fatal("expected bad request, got", status)
+	}
+}
+
+func TestFullText(t *testing.T) {
+	jsonConfig := `{
+	"collections": [
+	  {
+		"resource": "article",
+		"static_properties": ["category", {"name": "published_at", "type": "timestamp"}, {"name": "pages", "type": "integer"}],
+		"full_text": ["title", "abstract", "category", "published_at", "pages"]
+	  },
+	  {
+		"resource": "note"
+	  }
+	]
+  }
+`
+	testService := CreateTestService(jsonConfig, t.Name())
+	defer testService.Db.Close()
+
+	type Article struct {
+		ArticleID uuid.UUID `json:"article_id"`
+		Title     string    `json:"title"`
+		Abstract  string    `json:"abstract,omitempty"`
+		Category  string    `json:"category"`
+		Published string    `json:"published_at,omitempty"`
+		Pages     int       `json:"pages,omitempty"`
+	}
+	articles := []Article{
+		{Title: "Postgres internals", Abstract: "How postgres stores postgres tables", Category: "databases"},
+		{Title: "Choosing a database", Abstract: "Postgres or MySQL?", Category: "databases"},
+		{Title: "Gardening", Abstract: "Growing pumpkins", Category: "hobbies", Published: "2021-05-01T08:00:00Z", Pages: 42},
+		{Title: "Pumpkin soup"},
+	}
+	for i := range articles {
+		if _, err := testService.client.RawPost("/articles", articles[i], &articles[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var result []Article
+	_, header, err := testService.client.RawGetWithHeader("/articles?q=postgres&limit=2", map[string]string{}, &result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result) != 2 || result[0].ArticleID != articles[0].ArticleID || result[1].ArticleID != articles[1].ArticleID {
+		t.Fatal("unexpected result:", asJSON(result))
+	}
+	if header.Get("Pagination-Total-Count") != "2" || header.Get("Pagination-Next-Cursor") != "" {
+		t.Fatal("unexpected headers:", header)
+	}
+
+	// web search syntax, static and typed properties and case insensitivity
+	for query, expected := range map[string][]int{
+		"postgres -mysql":       {0},
+		"\"postgres or mysql\"": {1},
+		"PUMPKINS":              {2},
+		"pumpkin or gardening":  {2, 3},
+		"hobbies":               {2},
+		"2021":                  {2},
+		"42":                    {2},
+		"kubernetes":            {},
+	} {
+		_, err = testService.client.Collection("article").WithParameter("q", query).WithParameter("sort", "title").List(&result)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(result) != len(expected) {
+			t.Fatal("unexpected result for", query, ":", asJSON(result))
+		}
+		for i, index := range expected {
+			if result[i].ArticleID != articles[index].ArticleID {
+				t.Fatal("unexpected result for", query, ":", asJSON(result))
+			}
+		}
+	}
+
+	// combined with a filter
+	_, err = testService.client.Collection("article").WithParameter("q", "postgres").WithFilter("title", "Choosing a database").List(&result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result) != 1 || result[0].ArticleID != articles[1].ArticleID {
+		t.Fatal("unexpected result:", asJSON(result))
+	}
+
+	_, header, err = testService.client.RawGetWithHeader("/articles?limit=1", map[string]string{}, &result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{
+		"/notes?q=postgres",
+		"/articles?q=",
+		"/articles?q=postgres&order=asc",
+		"/articles?q=postgres&cursor=" + header.Get("Pagination-Next-Cursor"),
+	} {
+		if status, _ := testService.client.RawGet(path, &result); status != http.StatusBadRequest {
+			t.Fatal("expected bad request for", path, "got", status)
+		}
+	}
+}
+
+func TestExport(t *testing.T) {
+	jsonConfig := `{
+	"collections": [
+	  {
+		"resource": "a",
+		"static_properties": ["static_prop"],
+		"searchable_properties": ["searchable_prop"],
+		"permits": [
+		  {
+			"role": "userrole",
+			"operations": ["list"],
+			"selectors": ["a"]
+		  }
+		]
+	  },
+	  {
+		"resource": "a/b",
+		"permits": [
+		  {
+			"role": "userrole",
+			"operations": ["export"],
+			"selectors": ["a"]
+		  }
+		]
+	  }
+	]
+  }
+`
+	testService := CreateTestService(jsonConfig, t.Name())
+	defer testService.Db.Close()
+
+	// more items than the export fetches in one batch
+	numberOfElements := 1500
+	_, err := testService.Db.Exec(fmt.Sprintf(`INSERT INTO %s."a" (searchable_prop, static_prop, properties, timestamp)
+		SELECT 'bulk', 'static_' || i, json_build_object('n', i, 'nested', json_build_object('foo', 'foo_' || i)), (now() at time zone 'utc') - i * interval '1 second'
+		FROM generate_series(1, %d) i;`, testService.Db.Schema, numberOfElements))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var a A
+	body := map[string]string{"searchable_prop": "api", "static_prop": "static,\"quoted\"", "foo": "bar"}
+	if _, err = testService.client.RawPost("/as", body, &a); err != nil {
+		t.Fatal(err)
+	}
+
+	var data []byte
+	_, err = testService.client.RawGet("/as/export", &data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(lines) != numberOfElements+1 {
+		t.Fatal("unexpected number of lines:", len(lines))
+	}
+	var first A
+	if err = json.Unmarshal([]byte(lines[0]), &first); err != nil {
+		t.Fatal(err)
+	}
+	if first.AID != a.AID || first.Foo != "bar" || first.StaticProp != a.StaticProp {
+		t.Fatal("unexpected first line:", lines[0])
+	}
+	ids := map[string]bool{}
+	for _, line := range lines {
+		var object map[string]interface{}
+		if err = json.Unmarshal([]byte(line), &object); err != nil {
+			t.Fatal(err)
+		}
+		ids[object["a_id"].(string)] = true
+	}
+	if len(ids) != numberOfElements+1 {
+		t.Fatal("duplicate items in export")
+	}
+
+	// filtered, ascending and with projection
+	_, err = testService.client.RawGet("/as/export?format=ndjson&filter=searchable_prop=bulk&order=asc&fields=nested.foo", &data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines = strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(lines) != numberOfElements {
+		t.Fatal("unexpected number of lines:", len(lines))
+	}
+	var oldest map[string]interface{}
+	if err = json.Unmarshal([]byte(lines[0]), &oldest); err != nil {
+		t.Fatal(err)
+	}
+	if oldest["nested"].(map[string]interface{})["foo"] != "foo_1500" || oldest["n"] != nil || oldest["static_prop"] != nil || oldest["a_id"] == nil {
+		t.Fatal("unexpected first line:", lines[0])
+	}
+
+	// csv
+	_, err = testService.client.RawGet("/as/export?format=csv&filter=searchable_prop~a%25", &data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || strings.Join(records[0], ",") != "a_id,timestamp,revision,static_prop,searchable_prop,properties" {
+		t.Fatal("unexpected csv:", string(data))
+	}
+	if records[1][0] != a.AID.String() || records[1][2] != "1" || records[1][3] != a.StaticProp || records[1][5] != `{"foo":"bar"}` {
+		t.Fatal("unexpected csv:", string(data))
+	}
+	_, err = testService.client.RawGet("/as/export?format=csv&fields=n,nested.foo&filter=static_prop=static_7", &data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err = csv.NewReader(bytes.NewReader(data)).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || strings.Join(records[0], ",") != "a_id,timestamp,revision,static_prop,searchable_prop,n,nested.foo" ||
+		records[1][5] != "7" || records[1][6] != "foo_7" {
+		t.Fatal("unexpected csv:", string(data))
+	}
+
+	// exports need the export operation
+	userClient := testService.clientNoAuth.WithAuthorization(&access.Authorization{
+		Roles:     []string{"userrole"},
+		Selectors: map[string]string{"a_id": a.AID.String()},
+	})
+	if status, _ := userClient.RawGet("/as/export", &data); status != http.StatusUnauthorized {
+		t.Fatal("expected unauthorized, got", status)
+	}
+	if _, err = testService.client.RawPost("/as/"+a.AID.String()+"/bs", map[string]string{"foo": "bar"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = userClient.RawGet("/as/"+a.AID.String()+"/bs/export", &data); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(string(data), "\n") != 1 {
+		t.Fatal("unexpected export:", string(data))
+	}
+
+	for _, path := range []string{"/as/export?format=xml", "/as/export?limit=10", "/as/export?filter=unknown"} {
+		if status, _ := testService.client.RawGet(path, &data); status != http.StatusBadRequest {
+			t.Fatal("expected bad request for", path, "got", status)
+		}
+	}
+}
+
+func TestImport(t *testing.T) {
+	jsonConfig := `{
+	"collections": [
+	  {
+		"resource": "a",
+		"external_index": "external_id",
+		"default": {"foo": "default"}
+	  },
+	  {
+		"resource": "a/b"
+	  }
+	]
+  }
+`
+	testService := CreateTestService(jsonConfig, t.Name())
+	defer testService.Db.Close()
+
+	var notifications int
+	testService.backend.HandleResourceNotification("a", func(ctx context.Context, n backend.Notification) error {
+		notifications++
+		return nil
+	}, core.OperationCreate)
+
+	type importResult struct {
+		Index  int       `json:"index"`
+		Status int       `json:"status"`
+		ID     uuid.UUID `json:"id"`
+		Error  string    `json:"error"`
+	}
+
+	// newline delimited json, with an empty line, a broken line and a duplicate external index
+	providedID := uuid.New()
+	ndjson := `{"external_id":"e1","foo":"bar"}
+{"a_id":"` + providedID.String() + `","external_id":"e2"}
+
+{"external_id":"e3",
+{"external_id":"e1"}
+["not an object"]
+{"a_id":"broken","external_id":"e4"}
+{"external_id":"e5"}
+`
+	var results []importResult
+	_, err := testService.client.Collection("a").Import([]byte(ndjson), &results)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []struct {
+		index  int
+		status int
+	}{{0, 201}, {1, 201}, {2, 400}, {3, 409}, {4, 400}, {5, 400}, {6, 201}}
+	if len(results) != len(expected) {
+		t.Fatal("unexpected results:", asJSON(results))
+	}
+	for i, e := range expected {
+		if results[i].Index != e.index || results[i].Status != e.status || (e.status == 201) != (results[i].Error == "") {
+			t.Fatal("unexpected results:", asJSON(results))
+		}
+	}
+	if results[1].ID != providedID {
+		t.Fatal("provided id was not honoured:", asJSON(results))
+	}
+
+	var a map[string]interface{}
+	if _, err = testService.client.RawGet("/as/"+providedID.String(), &a); err != nil {
+		t.Fatal(err)
+	}
+	if a["external_id"] != "e2" || a["foo"] != "default" {
+		t.Fatal("unexpected item:", asJSON(a))
+	}
+	var all []A
+	if _, err = testService.client.RawGet("/as", &all); err != nil || len(all) != 3 {
+		t.Fatal("unexpected items:", asJSON(all), err)
+	}
+	testService.backend.ProcessJobsSync(-1)
+	if notifications != 0 {
+		t.Fatal("unexpected notifications:", notifications)
+	}
+
+	// json array into the children of all parents, in small batches and with notifications
+	var items []map[string]interface{}
+	for i := 0; i < 5; i++ {
+		items = append(items, map[string]interface{}{"a_id": all[i%2].AID, "n": i})
+	}
+	items = append(items, map[string]interface{}{"n": 5})
+	_, err = testService.client.Collection("a/b").WithParameter("batch_size", "2").WithParameter("notify", "true").Import(items, &results)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 6 || results[0].Status != 201 || results[4].Status != 201 || results[5].Status != 400 || results[5].Index != 5 {
+		t.Fatal("unexpected results:", asJSON(results))
+	}
+	var bs []map[string]interface{}
+	if _, err = testService.client.RawGet("/as/"+all[0].AID.String()+"/bs", &bs); err != nil || len(bs) != 3 {
+		t.Fatal("unexpected items:", asJSON(bs), err)
+	}
+
+	_, err = testService.client.Collection("a").WithParameter("notify", "true").Import([]map[string]string{{"external_id": "e6"}}, &results)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testService.backend.ProcessJobsSync(-1)
+	if notifications != 1 {
+		t.Fatal("unexpected notifications:", notifications)
+	}
+
+	if status, _ := testService.client.RawPost("/as/import?batch_size=0", []byte{}, nil); status != http.StatusBadRequest {
+		t.Fatal("expected bad request, got", status)
+	}
+	if status, _ := testService.clientNoAuth.RawPost("/as/import", []byte{}, nil); status != http.StatusUnauthorized {
+		t.Fatal("expected unauthorized, got", status)
+	}
+}
+
+func TestImportWithSchemaValidation(t *testing.T) {
+	var results []map[string]interface{}
+	items := `[{"workouts":"foo"},{"invalid":"foo"}]`
+	_, err := testService.client.RawPost("/with_schemas/import", []byte(items), &results)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[0]["status"] != 201.0 || results[1]["status"] != 400.0 {
+		t.Fatal("unexpected results:", asJSON(results))
+	}
+}
+
+func TestPatch(t *testing.T) {
+	a := A{ExternalID: t.Name()}
+	if _, err := testService.client.RawPost("/as", a, &a); err != nil {
+		t.Fatal(err)
+	}
+
+	var result A
+	status, err := testService.client.RawPatch("/as/"+a.AID.String(),
+		map[string]string{"foo": "new_foo"}, &result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != http.StatusOK || result.Foo != "new_foo" {
+		t.Fatalf("patch did not work")
+	}
+
+	// patching an unknown object should return 404 not found
+	unknownID := uuid.New()
+	status, err = testService.client.RawPatch("/as/"+unknownID.String(),
+		map[string]string{"foo": "new_foo"}, &result)
+	if err == nil || status != http.StatusNotFound {
+		t.Fatalf("patch of unknown object did return %d", status)
+	}
+
+}
+
+func TestJSONPatch(t *testing.T) {
+	jsonConfig := `{
+	"collections": [
+	  {
+		"resource": "a",
+		"static_properties": ["static_prop"],
+		"with_log": true
+	  }
+	],
+	"singletons": [
+	  {
+		"resource": "a/s"
+	  }
+	]
+  }
+`
+	testService := CreateTestService(jsonConfig, t.Name())
+	defer testService.Db.Close()
+
+	var notifications int
+	testService.backend.HandleResourceNotification("a", func(ctx context.Context, n backend.Notification) error {
+		notifications++
+		return nil
+	}, core.OperationUpdate)
+
+	a := map[string]interface{}{
+		"static_prop": "static",
+		"name":        "name",
+		"tags":        []string{"one", "two"},
+		"settings":    map[string]string{"locale": "de", "theme": "dark"},
+	}
+	var created A
+	if _, err := testService.client.RawPost("/as", a, &created); err != nil {
+		t.Fatal(err)
+	}
+	item := testService.client.Collection("a").Item(created.AID)
+
+	var result map[string]interface{}
+	_, err := item.JSONPatch([]map[string]interface{}{
+		{"op": "test", "path": "/name", "value": "name"},
+		{"op": "replace", "path": "/name", "value": "new name"},
+		{"op": "remove", "path": "/settings/locale"},
+		{"op": "add", "path": "/tags/1", "value": "one and a half"},
+		{"op": "move", "from": "/settings/theme", "path": "/theme"},
+		{"op": "copy", "from": "/tags/0", "path": "/tags/-"},
+	}, &result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"name":"new name","revision":2,"settings":{},"static_prop":"static","tags":["one","one and a half","two","one"],"theme":"dark"}`
+	delete(result, "a_id")
+	delete(result, "timestamp")
+	if asJSON(result) != expected {
+		t.Fatal("unexpected result:", asJSON(result))
+	}
+
+	// a failing test discards the entire patch
+	status, _ := item.JSONPatch([]map[string]interface{}{
+		{"op": "remove", "path": "/theme"},
+		{"op": "test", "path": "/name", "value": "name"},
+	}, nil)
+	if status != http.StatusConflict {
+		t.Fatal("expected conflict, got", status)
+	}
+	for _, operations := range [][]map[string]interface{}{
+		{{"op": "remove", "path": "/unknown"}},
+		{{"op": "add", "path": "/tags/7", "value": "seven"}},
+		{{"op": "replace", "path": "", "value": []string{}}},
+		{{"op": "move", "from": "/settings", "path": "/settings/nested"}},
+		{{"op": "remove", "path": "/static_prop"}},
+		{{"op": "replace", "path": "/a_id", "value": uuid.New()}},
+		{{"op": "unknown", "path": "/name"}},
+	} {
+		status, _ := item.JSONPatch(operations, nil)
+		if status != http.StatusUnprocessableEntity && status != http.StatusBadRequest {
+			t.Fatal("expected failure for", asJSON(operations), "got", status)
+		}
+	}
+	if status, _ := testService.client.RawPatchWithHeader("/as", map[string]string{"Content-Type": "application/json-patch+json"},
+		[]map[string]interface{}{{"op": "remove", "path": "/theme"}}, nil); status != http.StatusBadRequest {
+		t.Fatal("expected bad request for json patch without identifier, got", status)
+	}
+
+	var log []map[string]interface{}
+	if _, err = testService.client.RawGet(item.Path()+"/log", &log); err != nil {
+		t.Fatal(err)
+	}
+	if len(log) != 2 {
+		t.Fatal("unexpected log:", asJSON(log))
+	}
+	testService.backend.ProcessJobsSync(-1)
+	if notifications != 1 {
+		t.Fatal("unexpected notifications:", notifications)
+	}
+
+	// a singleton which does not exist yet is patched from an empty object
+	singleton := item.Subcollection("s").Singleton()
+	if _, err = singleton.JSONPatch([]map[string]interface{}{{"op": "add", "path": "/name", "value": "s"}}, &result); err != nil {
+		t.Fatal(err)
+	}
+	if result["name"] != "s" {
+		t.Fatal("unexpected singleton:", asJSON(result))
+	}
+}
+
+func TestIfMatch(t *testing.T) {
+	jsonConfig := `{
+	"collections": [
+	  {
+		"resource": "a",
+		"default": {"color": "blue"}
+	  }
+	],
+	"singletons": [
+	  {
+		"resource": "a/s"
+	  }
+	]
+  }
+`
+	testService := CreateTestService(jsonConfig, t.Name())
+	defer testService.Db.Close()
+
+	var created A
+	if _, err := testService.client.RawPost("/as", map[string]string{"foo": "foo"}, &created); err != nil {
+		t.Fatal(err)
+	}
+	itemPath := "/as/" + created.AID.String()
+	_, h, err := testService.client.RawGetWithHeader(itemPath, map[string]string{}, &A{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	etag := h.Get("Etag")
+
+	// a matching etag succeeds, a stale one fails with the current etag
+	if _, err = testService.client.RawPutWithHeader(itemPath, map[string]string{"If-Match": etag}, map[string]string{"foo": "bar"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	status, err := testService.client.RawPatchWithHeader(itemPath, map[string]string{"If-Match": etag}, map[string]string{"foo": "baz"}, nil)
+	if status != http.StatusPreconditionFailed {
+		t.Fatal("expected precondition failed, got", status, err)
+	}
+	var result A
+	if _, h, err = testService.client.RawGetWithHeader(itemPath, map[string]string{}, &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.Foo != "bar" || h.Get("Etag") == etag {
+		t.Fatal("unexpected item:", asJSON(result))
+	}
+	etag = h.Get("Etag")
+	if _, err = testService.client.RawPatchWithHeader(itemPath, map[string]string{"If-Match": `"stale", ` + etag}, map[string]string{"foo": "baz"}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// If-Unmodified-Since compares with the timestamp
+	before := created.Timestamp.Add(-time.Hour).Format(http.TimeFormat)
+	if status, _ = testService.client.RawDeleteWithHeader(itemPath, map[string]string{"If-Unmodified-Since": before}); status != http.StatusPreconditionFailed {
+		t.Fatal("expected precondition failed, got", status)
+	}
+	if status, _ = testService.client.RawDeleteWithHeader(itemPath, map[string]string{"If-Match": etag}); status != http.StatusPreconditionFailed {
+		t.Fatal("expected precondition failed, got", status)
+	}
+	after := created.Timestamp.Add(time.Hour).Format(http.TimeFormat)
+	if _, err = testService.client.RawDeleteWithHeader(itemPath, map[string]string{"If-Unmodified-Since": after}); err != nil {
+		t.Fatal(err)
+	}
+
+	// If-Match prevents an upsert from creating a new item
+	newID := uuid.New().String()
+	if status, _ = testService.client.RawPutWithHeader("/as/"+newID, map[string]string{"If-Match": "*"}, map[string]string{}, nil); status != http.StatusPreconditionFailed {
+		t.Fatal("expected precondition failed, got", status)
+	}
+	if _, err = testService.client.RawPut("/as/"+newID, map[string]string{}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// singletons
+	singletonPath := "/as/" + newID + "/s"
+	if _, err = testService.client.RawPut(singletonPath, map[string]string{"name": "s"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, h, err = testService.client.RawGetWithHeader(singletonPath, map[string]string{}, &result); err != nil {
+		t.Fatal(err)
+	}
+	if status, _ = testService.client.RawPutWithHeader(singletonPath, map[string]string{"If-Match": `"stale"`}, map[string]string{"name": "t"}, nil); status != http.StatusPreconditionFailed {
+		t.Fatal("expected precondition failed, got", status)
+	}
+	if _, err = testService.client.RawPutWithHeader(singletonPath, map[string]string{"If-Match": h.Get("Etag")}, map[string]string{"name": "t"}, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIdempotencyKey(t *testing.T) {
+	jsonConfig := `{
+	"collections": [
+	  {
+		"resource": "a",
+		"permits": [
+		  {
+			"role": "userrole",
+			"operations": ["create"]
+		  }
+		]
+	  }
+	]
+  }
+`
+	testService := CreateTestService(jsonConfig, t.Name())
+	defer testService.Db.Close()
+
+	post := func(c client.Client, key string, body string) (int, A) {
+		var result A
+		status, _ := c.RawPostBlob("/as", map[string]string{"Idempotency-Key": key}, []byte(body), &result)
+		return status, result
+	}
+
+	status, first := post(testService.client, "key", `{"foo":"foo"}`)
+	if status != http.StatusCreated {
+		t.Fatal("unexpected status:", status)
+	}
+	// a retry replays the response
+	status, retry := post(testService.client, "key", `{"foo":"foo"}`)
+	if status != http.StatusCreated || retry.AID != first.AID {
+		t.Fatal("unexpected retry:", status, asJSON(retry))
+	}
+	// the same key for a different request fails
+	if status, _ = post(testService.client, "key", `{"foo":"bar"}`); status != http.StatusUnprocessableEntity {
+		t.Fatal("expected unprocessable entity, got", status)
+	}
+	// keys are per identity
+	userClient := testService.clientNoAuth.WithRole("userrole")
+	status, other := post(userClient, "key", `{"foo":"foo"}`)
+	if status != http.StatusCreated || other.AID == first.AID {
+		t.Fatal("unexpected result for other identity:", status, asJSON(other))
+	}
+	var all []A
+	if _, err := testService.client.RawGet("/as", &all); err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 2 {
+		t.Fatal("unexpected items:", asJSON(all))
+	}
+
+	// after the idempotency window, the key can be used again
+	_, err := testService.Db.Exec(fmt.Sprintf(`UPDATE %s."_idempotency_" SET timestamp = timestamp - interval '25 hours';`, testService.Db.Schema))
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, expired := post(testService.client, "key", `{"foo":"bar"}`)
+	if status != http.StatusCreated || expired.AID == first.AID || expired.Foo != "bar" {
+		t.Fatal("unexpected result after expiry:", status, asJSON(expired))
+	}
+
+	// a claim without response blocks retries until its lease has passed
+	_, err = testService.Db.Exec(fmt.Sprintf(`UPDATE %s."_idempotency_" SET status = 0, response = NULL;`, testService.Db.Schema))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status, _ = post(testService.client, "key", `{"foo":"bar"}`); status != http.StatusConflict {
+		t.Fatal("expected conflict, got", status)
+	}
+	_, err = testService.Db.Exec(fmt.Sprintf(`UPDATE %s."_idempotency_" SET timestamp = timestamp - interval '10 minutes';`, testService.Db.Schema))
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, reclaimed := post(testService.client, "key", `{"foo":"bar"}`)
+	if status != http.StatusCreated || reclaimed.AID == expired.AID {
+		t.Fatal("unexpected result after lease:", status, asJSON(reclaimed))
+	}
+}
+
+func TestMergePatch(t *testing.T) {
+	a := map[string]interface{}{
+		"external_id":           t.Name(),
+		"static_prop":           "",
+		"searchable_prop":       "",
+		"other_searchable_prop": "",
+		"foo":                   "foo",
+		"settings":              map[string]interface{}{"locale": "de", "theme": "dark"},
+	}
+	var created A
+	if _, err := testService.client.RawPost("/as", a, &created); err != nil {
+		t.Fatal(err)
+	}
+	item := testService.client.Collection("a").Item(created.AID)
+
+	var result map[string]interface{}
+	_, err := item.MergePatch(map[string]interface{}{
+		"foo":      nil,
+		"bar":      "bar",
+		"settings": map[string]interface{}{"locale": nil, "timezone": "CET"},
+	}, &result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := result["foo"]; ok || result["bar"] != "bar" || asJSON(result["settings"]) != `{"theme":"dark","timezone":"CET"}` {
+		t.Fatal("unexpected result:", asJSON(result))
+	}
+
+	// the default patch keeps null values
+	if _, err = item.Patch(map[string]interface{}{"bar": nil}, &result); err != nil {
+		t.Fatal(err)
+	}
+	if value, ok := result["bar"]; !ok || value != nil {
+		t.Fatal("unexpected result:", asJSON(result))
+	}
+}
+
+func TestSoftDelete(t *testing.T) {
+	jsonConfig := `{
+	"collections": [
+	  {
+		"resource": "fleet",
+		"soft_delete": true,
+		"external_index": "code",
+		"permits": [
+		  {
+			"role": "userrole",
+			"operations": ["read", "list", "delete"]
+		  }
+		]
+	  },
+	  {
+		"resource": "fleet/user"
+	  }
+	],
+	"singletons": [
+	  {
+		"resource": "fleet/settings"
+	  }
+	],
+	"blobs": [
+	  {
+		"resource": "fleet/logo"
+	  }
+	]
+  }
+`
+	testService := CreateTestService(jsonConfig, t.Name())
+	defer testService.Db.Close()
+
+	type Fleet struct {
+		FleetID   uuid.UUID `json:"fleet_id"`
+		Name      string    `json:"name"`
+		Code      string    `json:"code,omitempty"`
+		DeletedAt string    `json:"deleted_at,omitempty"`
+	}
+	type User struct {
+		UserID  uuid.UUID `json:"user_id"`
+		FleetID uuid.UUID `json:"fleet_id"`
+	}
+	var fleet Fleet
+	if _, err := testService.client.RawPost("/fleets", Fleet{Name: "f1", Code: "c1"}, &fleet); err != nil {
+		t.Fatal(err)
+	}
+	fleetPath := "/fleets/" + fleet.FleetID.String()
+	var user, otherUser User
+	if _, err := testService.client.RawPost(fleetPath+"/users", map[string]string{}, &user); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := testService.client.RawPost(fleetPath+"/users", map[string]string{}, &otherUser); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := testService.client.RawPut(fleetPath+"/settings", map[string]string{"color": "blue"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	userPath := fleetPath + "/users/" + user.UserID.String()
+	var logo map[string]interface{}
+	if _, err := testService.client.RawPostBlob(fleetPath+"/logos", nil, []byte("logo"), &logo); err != nil {
+		t.Fatal(err)
+	}
+	logoPath := fleetPath + "/logos/" + logo["logo_id"].(string)
+
+	// a deleted user stays deleted when its fleet is restored
+	if _, err := testService.client.RawDelete(fleetPath + "/users/" + otherUser.UserID.String()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := testService.client.RawDelete(fleetPath); err != nil {
+		t.Fatal(err)
+	}
+
+	// deleted items and their children are hidden
+	var fleets []Fleet
+	var users []User
+	if status, _ := testService.client.RawGet(fleetPath, nil); status != http.StatusNotFound {
+		t.Fatal("expected not found, got", status)
+	}
+	if _, err := testService.client.RawGet("/fleets", &fleets); err != nil || len(fleets) != 0 {
+		t.Fatal("unexpected fleets:", asJSON(fleets), err)
+	}
+	if _, err := testService.client.RawGet("/fleets/all/users", &users); err != nil || len(users) != 0 {
+		t.Fatal("unexpected users:", asJSON(users), err)
+	}
+	if status, _ := testService.client.RawGet(fleetPath+"/settings", nil); status != http.StatusNotFound {
+		t.Fatal("expected not found, got", status)
+	}
+	if status, _ := testService.client.RawPost(fleetPath+"/users", map[string]string{}, nil); status != http.StatusNotFound {
+		t.Fatal("expected not found, got", status)
+	}
+	if status, _ := testService.client.RawPut(fleetPath, map[string]string{"name": "f2"}, nil); status != http.StatusConflict {
+		t.Fatal("expected conflict, got", status)
+	}
+
+	// blobs are hidden with their parent, they are not soft deleted themselves
+	var logos []map[string]interface{}
+	if status, _ := testService.client.RawGet(logoPath, nil); status != http.StatusNotFound {
+		t.Fatal("expected not found, got", status)
+	}
+	if _, err := testService.client.RawGet("/fleets/all/logos", &logos); err != nil || len(logos) != 0 {
+		t.Fatal("unexpected logos:", asJSON(logos), err)
+	}
+	if status, _ := testService.client.RawPostBlob(fleetPath+"/logos", nil, []byte("other"), nil); status != http.StatusNotFound {
+		t.Fatal("expected not found, got", status)
+	}
+
+	// only admins can see deleted items
+	if _, err := testService.client.RawGet("/fleets?include_deleted=true", &fleets); err != nil || len(fleets) != 1 || fleets[0].DeletedAt == "" {
+		t.Fatal("unexpected fleets:", asJSON(fleets), err)
+	}
+	if _, err := testService.client.RawGet(fleetPath+"?include_deleted=true", &fleet); err != nil || fleet.DeletedAt == "" {
+		t.Fatal("unexpected fleet:", asJSON(fleet), err)
+	}
+	if _, err := testService.client.RawGet(fleetPath+"?include_deleted=true&children=users", nil); err != nil {
+		t.Fatal(err)
+	}
+	userClient := testService.clientNoAuth.WithRole("userrole")
+	if status, _ := userClient.RawGet("/fleets?include_deleted=true", nil); status != http.StatusUnauthorized {
+		t.Fatal("expected unauthorized, got", status)
+	}
+
+	// the external index of a deleted item can be used again, the item then cannot be restored
+	var other Fleet
+	if _, err := testService.client.RawPost("/fleets", Fleet{Name: "f3", Code: "c1"}, &other); err != nil {
+		t.Fatal(err)
+	}
+	if status, _ := testService.client.RawPost(fleetPath+"/restore", nil, nil); status != http.StatusConflict {
+		t.Fatal("expected conflict, got", status)
+	}
+	if _, err := testService.client.RawDelete("/fleets/" + other.FleetID.String()); err != nil {
+		t.Fatal(err)
+	}
+
+	// children can only be restored together with their parent
+	if status, _ := testService.client.RawPost(userPath+"/restore", nil, nil); status != http.StatusConflict {
+		t.Fatal("expected conflict, got", status)
+	}
+	var restored Fleet
+	if _, err := userClient.RawPost(fleetPath+"/restore", nil, &restored); err != nil || restored.Name != "f1" || restored.DeletedAt != "" {
+		t.Fatal("unexpected fleet:", asJSON(restored), err)
+	}
+	if status, _ := testService.client.RawPost(fleetPath+"/restore", nil, nil); status != http.StatusNotFound {
+		t.Fatal("expected not found, got", status)
+	}
+	if _, err := testService.client.RawGet("/fleets/all/users", &users); err != nil || len(users) != 1 || users[0].UserID != user.UserID {
+		t.Fatal("unexpected users:", asJSON(users), err)
+	}
+	var settings map[string]interface{}
+	if _, err := testService.client.RawGet(fleetPath+"/settings", &settings); err != nil || settings["color"] != "blue" {
+		t.Fatal("unexpected settings:", asJSON(settings), err)
+	}
+	if _, err := testService.client.RawPost(fleetPath+"/users/"+otherUser.UserID.String()+"/restore", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	var logoData []byte
+	if _, err := testService.client.RawGet(logoPath, &logoData); err != nil || string(logoData) != "logo" {
+		t.Fatal("unexpected logo:", string(logoData), err)
+	}
+
+	// deleted items are purged after the retention
+	if _, err := testService.client.RawDelete("/fleets"); err != nil {
+		t.Fatal(err)
+	}
+	_, err := testService.Db.Exec(fmt.Sprintf(`UPDATE %s."fleet" SET deleted_at = deleted_at - interval '31 days';`, testService.Db.Schema))
+	if err != nil {
+		t.Fatal(err)
+	}
+	testService.backend.ProcessJobsSync(-1)
+	if _, err := testService.client.RawGet("/fleets?include_deleted=true", &fleets); err != nil || len(fleets) != 0 {
+		t.Fatal("unexpected fleets:", asJSON(fleets), err)
+	}
+	var count int
+	err = testService.Db.QueryRow(fmt.Sprintf(`SELECT count(*) FROM %s."fleet/user";`, testService.Db.Schema)).Scan(&count)
+	if err != nil || count != 0 {
+		t.Fatal("unexpected users:", count, err)
+	}
+}
+
+func TestRetention(t *testing.T) {
+	jsonConfig := `{
+	"collections": [
+	  {
+		"resource": "device",
+		"with_log": true,
+		"retention": {
+		  "max_age_seconds": 3600,
+		  "notify": true
+		}
+	  },
+	  {
+		"resource": "device/data"
+	  },
+	  {
+		"resource": "session",
+		"static_properties": ["expires_at"],
+		"retention": {
+		  "expires_at": "expires_at"
+		}
+	  }
+	]
+  }
+`
+	testService := CreateTestService(jsonConfig, t.Name())
+	defer testService.Db.Close()
+
+	var notifications []uuid.UUID
+	testService.backend.HandleResourceNotification("device", func(ctx context.Context, n backend.Notification) error {
+		notifications = append(notifications, n.ResourceID)
+		return nil
+	}, core.OperationDelete)
+
+	now := time.Now().UTC()
+	create := func(path string, key string, body map[string]string) string {
+		var result map[string]interface{}
+		if _, err := testService.client.RawPost(path, body, &result); err != nil {
+			t.Fatal(err)
+		}
+		return result[key].(string)
+	}
+	expired := create("/devices", "device_id", map[string]string{"timestamp": now.Add(-2 * time.Hour).Format(time.RFC3339)})
+	current := create("/devices", "device_id", map[string]string{"timestamp": now.Add(-30 * time.Minute).Format(time.RFC3339)})
+	create("/devices/"+expired+"/datas", "data_id", map[string]string{})
+	create("/sessions", "session_id", map[string]string{"expires_at": now.Add(-time.Minute).Format(time.RFC3339)})
+	valid := create("/sessions", "session_id", map[string]string{"expires_at": now.Add(time.Hour).Format(time.RFC3339)})
+	forever := create("/sessions", "session_id", map[string]string{"expires_at": "never"})
+
+	testService.backend.ProcessJobsSync(-1)
+	testService.backend.ProcessJobsSync(-1)
+
+	var devices, data, sessions []map[string]interface{}
+	if _, err := testService.client.RawGet("/devices", &devices); err != nil || len(devices) != 1 || devices[0]["device_id"] != current {
+		t.Fatal("unexpected devices:", asJSON(devices), err)
+	}
+	if _, err := testService.client.RawGet("/devices/all/datas", &data); err != nil || len(data) != 0 {
+		t.Fatal("unexpected data:", asJSON(data), err)
+	}
+	if _, err := testService.client.RawGet("/sessions", &sessions); err != nil || len(sessions) != 2 {
+		t.Fatal("unexpected sessions:", asJSON(sessions), err)
+	}
+	for _, session := range sessions {
+		if id := session["session_id"]; id != valid && id != forever {
+			t.Fatal("unexpected sessions:", asJSON(sessions))
+		}
+	}
+	if len(notifications) != 1 || notifications[0].String() != expired {
+		t.Fatal("unexpected notifications:", notifications)
+	}
+	// expired items leave a tombstone in the log
+	var log []map[string]interface{}
+	if _, err := testService.client.RawGet("/devices/"+expired+"/log", &log); err != nil || len(log) != 2 ||
+		!strings.Contains(asJSON(log[0]["_log"]), `"operation":"delete"`) || log[0]["revision"] != 2.0 {
+		t.Fatal("unexpected log:", asJSON(log), err)
+	}
+}
+
+func TestPointInTime(t *testing.T) {
+	jsonConfig := `{
+	"collections": [
+	  {
+		"resource": "device",
+		"with_log": true
+	  },
+	  {
+		"resource": "vehicle"
+	  }
+	]
+  }
+`
+	testService := CreateTestService(jsonConfig, t.Name())
+	defer testService.Db.Close()
+
+	type Device struct {
+		DeviceID uuid.UUID `json:"device_id"`
+		Name     string    `json:"name"`
+		Revision int       `json:"revision"`
+	}
+	before := time.Now().UTC()
+	var device Device
+	if _, err := testService.client.RawPost("/devices", Device{Name: "v1"}, &device); err != nil {
+		t.Fatal(err)
+	}
+	first := time.Now().UTC()
+	device.Name = "v2"
+	if _, err := testService.client.RawPut("/devices", device, &device); err != nil {
+		t.Fatal(err)
+	}
+	second := time.Now().UTC()
+	if _, err := testService.client.RawPost("/devices", Device{Name: "other"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	devicePath := "/devices/" + device.DeviceID.String()
+
+	for query, name := range map[string]string{
+		"revision=1": "v1",
+		"revision=2": "v2",
+		"as_of=" + first.Format(time.RFC3339Nano):  "v1",
+		"as_of=" + second.Format(time.RFC3339Nano): "v2",
+	} {
+		var result Device
+		if _, err := testService.client.RawGet(devicePath+"?"+query, &result); err != nil || result.Name != name {
+			t.Fatal("unexpected device for", query, asJSON(result), err)
+		}
+	}
+	for _, query := range []string{"revision=3", "as_of=" + before.Format(time.RFC3339Nano)} {
+		if status, _ := testService.client.RawGet(devicePath+"?"+query, nil); status != http.StatusNotFound {
+			t.Fatal("expected not found for", query, "got", status)
+		}
+	}
+
+	// the list shows the state of the collection at as_of
+	var devices []Device
+	if _, err := testService.client.RawGet("/devices?as_of="+first.Format(time.RFC3339Nano), &devices); err != nil ||
+		len(devices) != 1 || devices[0].Name != "v1" {
+		t.Fatal("unexpected devices:", asJSON(devices), err)
+	}
+	if _, err := testService.client.RawGet("/devices?as_of="+second.Format(time.RFC3339Nano), &devices); err != nil ||
+		len(devices) != 1 || devices[0].Name != "v2" || devices[0].Revision != 2 {
+		t.Fatal("unexpected devices:", asJSON(devices), err)
+	}
+	if _, err := testService.client.RawGet("/devices", &devices); err != nil || len(devices) != 2 {
+		t.Fatal("unexpected devices:", asJSON(devices), err)
+	}
+
+	for _, path := range []string{
+		devicePath + "?revision=1&as_of=" + first.Format(time.RFC3339Nano),
+		devicePath + "?revision=0",
+		"/vehicles?as_of=" + first.Format(time.RFC3339Nano),
+	} {
+		if status, _ := testService.client.RawGet(path, nil); status != http.StatusBadRequest {
+			t.Fatal("expected bad request for", path, "got", status)
+		}
+	}
+}
+
+func TestRestoreRevision(t *testing.T) {
+	jsonConfig := `{
+	"collections": [
+	  {
+		"resource": "device",
+		"with_log": true,
+		"static_properties": ["kind"]
+	  }
+	]
+  }
+`
+	testService := CreateTestService(jsonConfig, t.Name())
+	defer testService.Db.Close()
+
+	var notifications int
+	testService.backend.HandleResourceNotification("device", func(ctx context.Context, n backend.Notification) error {
+		notifications++
+		return nil
+	}, core.OperationUpdate)
+
+	type Device struct {
+		DeviceID  uuid.UUID `json:"device_id"`
+		Kind      string    `json:"kind"`
+		Name      string    `json:"name"`
+		Revision  int       `json:"revision"`
+		Timestamp time.Time `json:"timestamp"`
+	}
+	var device, updated Device
+	if _, err := testService.client.RawPost("/devices", Device{Kind: "sensor", Name: "v1"}, &device); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := testService.client.RawPut("/devices", Device{DeviceID: device.DeviceID, Kind: "actor", Name: "v2"}, &updated); err != nil {
+		t.Fatal(err)
+	}
+	logPath := "/devices/" + device.DeviceID.String() + "/log"
+
+	var restored Device
+	if _, err := testService.client.RawPost(logPath+"/1/restore", nil, &restored); err != nil {
+		t.Fatal(err)
+	}
+	if restored.Name != "v1" || restored.Kind != "sensor" || restored.Revision != 3 || !restored.Timestamp.Equal(updated.Timestamp) {
+		t.Fatal("unexpected device:", asJSON(restored))
+	}
+	var log []Device
+	if _, err := testService.client.RawGet(logPath, &log); err != nil || len(log) != 3 {
+		t.Fatal("unexpected log:", asJSON(log), err)
+	}
+	testService.backend.ProcessJobsSync(-1)
+	if notifications != 2 {
+		t.Fatal("unexpected notifications:", notifications)
+	}
+
+	for path, expected := range map[string]int{
+		logPath + "/4/restore":                               http.StatusNotFound,
+		logPath + "/zero/restore":                            http.StatusBadRequest,
+		"/devices/" + uuid.New().String() + "/log/1/restore": http.StatusNotFound,
+	} {
+		if status, _ := testService.client.RawPost(path, nil, nil); status != expected {
+			t.Fatal("expected", expected, "for", path, "got", status)
+		}
+	}
+}
+
+func TestRevisionDiff(t *testing.T) {
+	jsonConfig := `{
+	"collections": [
+	  {
+		"resource": "device",
+		"with_log": true
+	  }
+	]
+  }
+`
+	testService := CreateTestService(jsonConfig, t.Name())
+	defer testService.Db.Close()
+
+	var device map[string]interface{}
+	_, err := testService.client.RawPost("/devices", map[string]interface{}{
+		"name": "v1", "tags": []string{"a"}, "settings": map[string]interface{}{"a/b": 1, "c": 2}}, &device)
+	if err != nil {
+		t.Fatal(err)
+	}
+	devicePath := "/devices/" + device["device_id"].(string)
+	_, err = testService.client.RawPut(devicePath, map[string]interface{}{
+		"name": "v2", "tags": []string{"a", "b"}, "settings": map[string]interface{}{"a/b": 3, "c": 2}, "color": "red"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type Diff struct {
+		From          int                      `json:"from"`
+		To            int                      `json:"to"`
+		FromTimestamp time.Time                `json:"from_timestamp"`
+		ToTimestamp   time.Time                `json:"to_timestamp"`
+		Patch         []map[string]interface{} `json:"patch"`
+	}
+	var diff Diff
+	if _, err = testService.client.RawGet(devicePath+"/log/diff?from=1&to=2", &diff); err != nil {
+		t.Fatal(err)
+	}
+	expected := `[{"op":"add","path":"/color","value":"red"},{"op":"replace","path":"/name","value":"v2"},` +
+		`{"op":"replace","path":"/settings/a~1b","value":3},{"op":"replace","path":"/tags","value":["a","b"]}]`
+	if diff.From != 1 || diff.To != 2 || diff.ToTimestamp.Before(diff.FromTimestamp) || asJSON(diff.Patch) != expected {
+		t.Fatal("unexpected diff:", asJSON(diff))
+	}
+	if _, err = testService.client.RawGet(devicePath+"/log/diff?from=2&to=1", &diff); err != nil {
+		t.Fatal(err)
+	}
+	if len(diff.Patch) != 4 || diff.Patch[0]["op"] != "remove" || diff.Patch[0]["path"] != "/color" {
+		t.Fatal("unexpected diff:", asJSON(diff))
+	}
+	if _, err = testService.client.RawGet(devicePath+"/log/diff?from=2&to=2", &diff); err != nil || len(diff.Patch) != 0 {
+		t.Fatal("unexpected diff:", asJSON(diff), err)
+	}
+
+	for query, expected := range map[string]int{
+		"from=1":         http.StatusBadRequest,
+		"from=0&to=1":    http.StatusBadRequest,
+		"from=1&to=2&x=": http.StatusBadRequest,
+		"from=1&to=3":    http.StatusNotFound,
+	} {
+		if status, _ := testService.client.RawGet(devicePath+"/log/diff?"+query, nil); status != expected {
+			t.Fatal("expected", expected, "for", query, "got", status)
+		}
+	}
+}
+
+func TestLogAuthor(t *testing.T) {
+	jsonConfig := `{
+	"collections": [
+	  {
+		"resource": "device",
+		"with_log": true,
+		"static_properties": ["kind"]
+	  }
+	]
+  }
+`
+	testService := CreateTestService(jsonConfig, t.Name())
+	defer testService.Db.Close()
+
+	alice := testService.client.WithContext(access.ContextWithIdentity(context.Background(), "alice"))
+	bob := testService.client.WithContext(access.ContextWithIdentity(context.Background(), "bob"))
+
+	type Change struct {
+		Author    string `json:"author"`
+		Operation string `json:"operation"`
+	}
+	type Device struct {
+		DeviceID uuid.UUID `json:"device_id"`
+		Kind     string    `json:"kind"`
+		Name     string    `json:"name"`
+		Revision int       `json:"revision"`
+		Log      *Change   `json:"_log,omitempty"`
+	}
+	var device Device
+	if _, err := alice.RawPost("/devices", Device{Kind: "sensor", Name: "v1"}, &device); err != nil {
+		t.Fatal(err)
+	}
+	devicePath := "/devices/" + device.DeviceID.String()
+	if _, err := bob.RawPut(devicePath, map[string]interface{}{"kind": "sensor", "name": "v2"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bob.RawPut(devicePath+"/kind/actor", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	beforeDelete := time.Now().UTC()
+	if _, err := alice.RawDelete(devicePath); err != nil {
+		t.Fatal(err)
+	}
+
+	var log []Device
+	if _, err := testService.client.RawGet(devicePath+"/log", &log); err != nil {
+		t.Fatal(err)
+	}
+	expected := []struct {
+		author, operation string
+	}{{"alice", "delete"}, {"bob", "update_property"}, {"bob", "update"}, {"alice", "create"}}
+	if len(log) != len(expected) {
+		t.Fatal("unexpected log:", asJSON(log))
+	}
+	for i, entry := range log {
+		if entry.Log == nil || *entry.Log != (Change{expected[i].author, expected[i].operation}) || entry.Revision != len(log)-i {
+			t.Fatal("unexpected log:", asJSON(log))
+		}
+	}
+	// the tombstone holds the last state of the device
+	if log[0].Name != "v2" || log[0].Kind != "actor" {
+		t.Fatal("unexpected tombstone:", asJSON(log[0]))
+	}
+
+	if _, err := testService.client.RawGet(devicePath+"/log?author=bob", &log); err != nil || len(log) != 2 {
+		t.Fatal("unexpected log:", asJSON(log), err)
+	}
+
+	// deleted devices do not exist after their deletion
+	var result Device
+	if _, err := testService.client.RawGet(devicePath+"?as_of="+beforeDelete.Format(time.RFC3339Nano), &result); err != nil ||
+		result.Kind != "actor" || result.Revision != 3 {
+		t.Fatal("unexpected device:", asJSON(result), err)
+	}
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	if status, _ := testService.client.RawGet(devicePath+"?as_of="+now, nil); status != http.StatusNotFound {
+		t.Fatal("expected not found, got", status)
+	}
+	// the tombstone is not a revision of the device
+	if status, _ := testService.client.RawGet(devicePath+"?revision=4", nil); status != http.StatusNotFound {
+		t.Fatal("expected not found, got", status)
+	}
+	var devices []Device
+	if _, err := testService.client.RawGet("/devices?as_of="+now, &devices); err != nil || len(devices) != 0 {
+		t.Fatal("unexpected devices:", asJSON(devices), err)
+	}
+
+	// the tombstone cannot be restored, an earlier revision creates the device again
+	if status, _ := testService.client.RawPost(devicePath+"/log/4/restore", nil, nil); status != http.StatusNotFound {
+		t.Fatal("expected not found, got", status)
+	}
+	result = Device{}
+	status, err := testService.client.RawPost(devicePath+"/log/3/restore", nil, &result)
+	if err != nil || status != http.StatusCreated || result.DeviceID != device.DeviceID || result.Kind != "actor" {
+		t.Fatal("unexpected device:", status, asJSON(result), err)
+	}
+
+	// columns of the log, soft delete and full text search cannot be properties
+	for _, config := range []string{
+		`{"collections":[{"resource":"device","with_log":true,"static_properties":["author"]}]}`,
+		`{"collections":[{"resource":"device","with_log":true,"external_index":"operation"}]}`,
+		`{"collections":[{"resource":"device","soft_delete":true,"searchable_properties":["deleted_at"]}]}`,
+		`{"collections":[{"resource":"device","full_text":["name"],"static_properties":["fulltext"]}]}`,
+	} {
+		if _, err := backend.DryRun(&backend.Builder{Config: config, DB: testService.Db}); err == nil {
+			t.Fatal("expected invalid configuration:", config)
+		}
+	}
+}
+
+func TestChildTombstones(t *testing.T) {
+	jsonConfig := `{
+	"collections": [
+	  {
+		"resource": "device"
+	  },
+	  {
+		"resource": "device/sensor",
+		"with_log": true
+	  },
+	  {
+		"resource": "fleet",
+		"soft_delete": true
+	  },
+	  {
+		"resource": "fleet/car",
+		"with_log": true
+	  }
+	]
+  }
+`
+	testService := CreateTestService(jsonConfig, t.Name())
+	defer testService.Db.Close()
+
+	create := func(path string, key string) string {
+		var result map[string]interface{}
+		if _, err := testService.client.RawPost(path, map[string]string{}, &result); err != nil {
+			t.Fatal(err)
+		}
+		return result[key].(string)
+	}
+	// operations returns the operations in the log of path with their revisions, latest first
+	operations := func(path string) string {
+		var log []map[string]interface{}
+		if _, err := testService.client.RawGet(path+"/log", &log); err != nil {
+			t.Fatal(err)
+		}
+		var result []string
+		for _, entry := range log {
+			change, _ := entry["_log"].(map[string]interface{})
+			result = append(result, fmt.Sprintf("%v:%v", change["operation"], entry["revision"]))
+		}
+		return strings.Join(result, ",")
+	}
+
+	// children removed by the database cascade leave tombstones
+	devicePath := "/devices/" + create("/devices", "device_id")
+	sensorPath := devicePath + "/sensors/" + create(devicePath+"/sensors", "sensor_id")
+	if _, err := testService.client.RawDelete(devicePath); err != nil {
+		t.Fatal(err)
+	}
+	if log := operations(sensorPath); log != "delete:2,create:1" {
+		t.Fatal("unexpected log:", log)
+	}
+
+	// soft deleted children leave tombstones, and follow them in the log when they are restored
+	fleetPath := "/fleets/" + create("/fleets", "fleet_id")
+	carPath := fleetPath + "/cars/" + create(fleetPath+"/cars", "car_id")
+	if _, err := testService.client.RawDelete(fleetPath); err != nil {
+		t.Fatal(err)
+	}
+	if log := operations(carPath); log != "delete:2,create:1" {
+		t.Fatal("unexpected log:", log)
+	}
+	if _, err := testService.client.RawPost(fleetPath+"/restore", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	var car map[string]interface{}
+	if _, err := testService.client.RawGet(carPath, &car); err != nil || car["revision"] != 3.0 {
+		t.Fatal("unexpected car:", asJSON(car), err)
+	}
+	if log := operations(carPath); log != "create:3,delete:2,create:1" {
+		t.Fatal("unexpected log:", log)
+	}
+}
+
+func TestTypedProperties(t *testing.T) {
+	jsonConfig := `{
+	"collections": [
+	  {
+		"resource": "sensor",
+		"with_log": true,
+		"static_properties": ["label", {"name":"active","type":"boolean"}],
+		"searchable_properties": [{"name":"battery","type":"integer"}, {"name":"level","type":"numeric"}, {"name":"seen_at","type":"timestamp"}]
+	  }
+	]
+  }
+`
+	testService := CreateTestService(jsonConfig, t.Name())
+	defer testService.Db.Close()
+
+	seenAt := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	var sensors []map[string]interface{}
+	for i, battery := range []int{5, 20, 100} {
+		var sensor map[string]interface{}
+		_, err := testService.client.RawPost("/sensors", map[string]interface{}{
+			"label":   "s" + strconv.Itoa(i),
+			"active":  battery > 10,
+			"battery": battery,
+			"level":   float64(battery) / 4,
+			"seen_at": seenAt.Add(time.Duration(i) * time.Hour).In(time.FixedZone("CET", 3600)).Format(time.RFC3339),
+		}, &sensor)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sensors = append(sensors, sensor)
+	}
+	if sensors[0]["battery"] != 5.0 || sensors[0]["level"] != 1.25 || sensors[0]["active"] != false ||
+		sensors[0]["seen_at"] != seenAt.Format(time.RFC3339) || sensors[0]["label"] != "s0" {
+		t.Fatal("unexpected sensor:", asJSON(sensors[0]))
+	}
+
+	// missing typed properties are null
+	var sensor map[string]interface{}
+	if _, err := testService.client.RawPost("/sensors", map[string]interface{}{"label": "empty"}, &sensor); err != nil {
+		t.Fatal(err)
+	}
+	if sensor["battery"] != nil || sensor["active"] != nil || sensor["label"] != "empty" {
+		t.Fatal("unexpected sensor:", asJSON(sensor))
+	}
+
+	// filters and sorting compare numbers and times, not strings
+	for query, expected := range map[string][]string{
+		"filter=" + url.QueryEscape("battery>10") + "&sort=battery":                      {"s1", "s2"},
+		"filter=" + url.QueryEscape("battery<=20") + "&sort=-battery":                    {"s1", "s0"},
+		"filter=" + url.QueryEscape("level>5"):                                           {"s2"},
+		"filter=" + url.QueryEscape("active=true") + "&sort=label":                       {"s1", "s2"},
+		"filter=" + url.QueryEscape("battery IN (5,100)") + "&sort=label":                {"s0", "s2"},
+		"filter=" + url.QueryEscape("battery IS NULL"):                                   {"empty"},
+		"filter=" + url.QueryEscape("seen_at>2021-03-01T13:30:00+01:00") + "&sort=label": {"s1", "s2"},
+		"filter=" + url.QueryEscape("seen_at IS NOT NULL") + "&sort=-seen_at&limit=1":    {"s2"},
+	} {
+		var result []map[string]interface{}
+		if _, err := testService.client.RawGet("/sensors?"+query, &result); err != nil {
+			t.Fatal(query, err)
+		}
+		var labels []string
+		for _, sensor := range result {
+			labels = append(labels, sensor["label"].(string))
+		}
+		if strings.Join(labels, ",") != strings.Join(expected, ",") {
+			t.Fatal("unexpected sensors for", query, labels)
+		}
+	}
+
+	// typed values are validated on write
+	sensorPath := "/sensors/" + sensors[0]["sensor_id"].(string)
+	for _, body := range []map[string]interface{}{
+		{"battery": "five"},
+		{"battery": 5.5},
+		{"active": "yes"},
+		{"seen_at": "yesterday"},
+	} {
+		if status, _ := testService.client.RawPost("/sensors", body, nil); status != http.StatusBadRequest {
+			t.Fatal("expected bad request for", asJSON(body), "got", status)
+		}
+	}
+	if status, _ := testService.client.RawGet("/sensors?filter="+url.QueryEscape("battery>five"), nil); status != http.StatusBadRequest {
+		t.Fatal("expected bad request, got", status)
+	}
+
+	// property updates are typed as well
+	if _, err := testService.client.RawPut(sensorPath+"/battery/42", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if status, _ := testService.client.RawPut(sensorPath+"/battery/many", nil, nil); status != http.StatusBadRequest {
+		t.Fatal("expected bad request, got", status)
+	}
+	if _, err := testService.client.RawGet(sensorPath, &sensor); err != nil || sensor["battery"] != 42.0 {
+		t.Fatal("unexpected sensor:", asJSON(sensor), err)
+	}
+
+	// the log keeps the types
+	var log []map[string]interface{}
+	if _, err := testService.client.RawGet(sensorPath+"/log", &log); err != nil || len(log) != 2 ||
+		log[0]["battery"] != 42.0 || log[1]["battery"] != 5.0 || log[1]["active"] != false {
+		t.Fatal("unexpected log:", asJSON(log), err)
+	}
+}
+
+func TestExternalIndices(t *testing.T) {
+	jsonConfig := `{
+	"collections": [
+	  {
+		"resource": "fleet"
+	  },
+	  {
+		"resource": "fleet/user",
+		"external_index": "identity",
+		"external_indices": [{"properties":["nickname"],"scope":"parent"}, {"properties":["vendor","serial"]}]
+	  }
+	]
+  }
+`
+	testService := CreateTestService(jsonConfig, t.Name())
+	defer testService.Db.Close()
+
+	var fleets []string
+	for i := 0; i < 2; i++ {
+		var fleet map[string]interface{}
+		if _, err := testService.client.RawPost("/fleets", map[string]interface{}{}, &fleet); err != nil {
+			t.Fatal(err)
+		}
+		fleets = append(fleets, fleet["fleet_id"].(string))
+	}
+
+	var alice map[string]interface{}
+	_, err := testService.client.RawPost("/fleets/"+fleets[0]+"/users", map[string]interface{}{
+		"identity": "alice@test.com",
+		"nickname": "al",
+		"vendor":   "acme",
+		"serial":   "1",
+	}, &alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the nickname is unique within the fleet only, vendor and serial only as combination
+	for _, c := range []struct {
+		fleet  string
+		body   map[string]interface{}
+		status int
+	}{
+		{fleets[0], map[string]interface{}{"identity": "alice@test.com"}, http.StatusConflict},
+		{fleets[0], map[string]interface{}{"nickname": "al"}, http.StatusConflict},
+		{fleets[1], map[string]interface{}{"nickname": "al"}, http.StatusCreated},
+		{fleets[1], map[string]interface{}{"vendor": "acme", "serial": "1"}, http.StatusConflict},
+		{fleets[1], map[string]interface{}{"vendor": "acme", "serial": "2"}, http.StatusCreated},
+		{fleets[1], map[string]interface{}{"vendor": "acme"}, http.StatusCreated},
+		{fleets[1], map[string]interface{}{}, http.StatusCreated},
+	} {
+		if status, _ := testService.client.RawPost("/fleets/"+c.fleet+"/users", c.body, nil); status != c.status {
+			t.Fatal("expected", c.status, "for", asJSON(c.body), "got", status)
+		}
+	}
+
+	// each external index has a lookup route
+	for _, path := range []string{
+		"/fleets/all/users/identity:alice@test.com",
+		"/fleets/" + fleets[0] + "/users/nickname:al",
+		"/fleets/all/users/vendor:acme/serial:1",
+	} {
+		var user map[string]interface{}
+		if _, err := testService.client.RawGet(path, &user); err != nil || user["user_id"] != alice["user_id"] {
+			t.Fatal("unexpected user for", path, asJSON(user), err)
+		}
+	}
+	for path, expected := range map[string]int{
+		"/fleets/" + fleets[1] + "/users/identity:alice@test.com": http.StatusNotFound,
+		"/fleets/all/users/nickname:al":                           http.StatusBadRequest,
+		"/fleets/all/users/vendor:acme/serial:3":                  http.StatusNotFound,
+	} {
+		if status, _ := testService.client.RawGet(path, nil); status != expected {
+			t.Fatal("expected", expected, "for", path, "got", status)
+		}
+	}
+
+	// and all properties of external indices are searchable
+	var users []map[string]interface{}
+	if _, err := testService.client.RawGet("/fleets/all/users?search=nickname=al", &users); err != nil || len(users) != 2 {
+		t.Fatal("unexpected users:", asJSON(users), err)
+	}
+	if _, err := testService.client.RawGet("/fleets/all/users?search=vendor=acme", &users); err != nil || len(users) != 3 {
+		t.Fatal("unexpected users:", asJSON(users), err)
+	}
+}
+
+func TestUpsertByExternalIndex(t *testing.T) {
+	jsonConfig := `{
+	"collections": [
+	  {
+		"resource": "device",
+		"with_log": true,
+		"external_index": "thing",
+		"external_indices": [{"properties":["vendor","serial"]}]
+	  }
+	]
+  }
+`
+	testService := CreateTestService(jsonConfig, t.Name())
+	defer testService.Db.Close()
+
+	var notifications []core.Operation
+	testService.backend.HandleResourceNotification("device", func(ctx context.Context, n backend.Notification) error {
+		notifications = append(notifications, n.Operation)
+		return nil
+	}, core.OperationCreate, core.OperationUpdate)
+
+	// a put replaces the entire device, hence it needs all external indices
+	device := func(thing, name string) map[string]interface{} {
+		return map[string]interface{}{"thing": thing, "vendor": "acme", "serial": thing, "name": name}
+	}
+
+	// the first put creates the device, the second updates it
+	var created, updated map[string]interface{}
+	status, err := testService.client.RawPut("/devices/thing:t1", device("t1", "v1"), &created)
+	if err != nil || status != http.StatusCreated || created["thing"] != "t1" {
+		t.Fatal("unexpected device:", status, asJSON(created), err)
+	}
+	status, err = testService.client.RawPut("/devices/thing:t1", device("t1", "v2"), &updated)
+	if err != nil || status != http.StatusOK || updated["device_id"] != created["device_id"] || updated["name"] != "v2" {
+		t.Fatal("unexpected device:", status, asJSON(updated), err)
+	}
+
+	// the values come from the body with the parameter by
+	if _, err := testService.client.RawPut("/devices?by=thing", device("t1", "v3"), &updated); err != nil || updated["device_id"] != created["device_id"] {
+		t.Fatal("unexpected device:", asJSON(updated), err)
+	}
+	if _, err := testService.client.RawPut("/devices?by=vendor,serial", device("t1", "v4"), &updated); err != nil ||
+		updated["device_id"] != created["device_id"] || updated["name"] != "v4" {
+		t.Fatal("unexpected device:", asJSON(updated), err)
+	}
+
+	// revision checks still apply
+	body := device("t1", "v5")
+	body["revision"] = 1
+	if status, _ := testService.client.RawPut("/devices/thing:t1", body, nil); status != http.StatusConflict {
+		t.Fatal("expected conflict, got", status)
+	}
+	for path, body := range map[string]map[string]interface{}{
+		"/devices?by=name":  {"name": "v5"},
+		"/devices?by=thing": {"name": "v5"},
+		"/devices/thing:t1": {"thing": "t2"},
+	} {
+		if status, _ := testService.client.RawPut(path, body, nil); status != http.StatusBadRequest {
+			t.Fatal("expected bad request for", path, "got", status)
+		}
+	}
+	if status, _ := testService.client.RawPatch("/devices/thing:t9", map[string]interface{}{"name": "v1"}, nil); status != http.StatusNotFound {
+		t.Fatal("expected not found, got", status)
+	}
+
+	// concurrent upserts of the same thing create one device only
+	var wg sync.WaitGroup
+	statuses := make([]int, 5)
+	for i := range statuses {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			statuses[i], _ = testService.client.RawPut("/devices/thing:t3", device("t3", strconv.Itoa(i)), nil)
+		}(i)
+	}
+	wg.Wait()
+	var devices []map[string]interface{}
+	if _, err := testService.client.RawGet("/devices?search=thing=t3", &devices); err != nil || len(devices) != 1 {
+		t.Fatal("unexpected devices:", asJSON(devices), err)
+	}
+	counts := map[int]int{}
+	for _, status := range statuses {
+		counts[status]++
+	}
+	if counts[http.StatusCreated] != 1 || counts[http.StatusOK] != 4 {
+		t.Fatal("unexpected statuses:", statuses)
+	}
+
+	testService.backend.ProcessJobsSync(-1)
+	creates := 0
+	for _, operation := range notifications {
+		if operation == core.OperationCreate {
+			creates++
+		}
+	}
+	if len(notifications) != 9 || creates != 2 {
+		t.Fatal("unexpected notifications:", notifications)
+	}
 }
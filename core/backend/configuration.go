@@ -19,48 +19,62 @@ type Configuration struct {
 	Blobs       []blobConfiguration       `json:"blobs"`
 	Relations   []relationConfiguration   `json:"relations"`
 	Shortcuts   []shortcutConfiguration   `json:"shortcuts"`
+	Migrations  []migrationConfiguration  `json:"migrations"`
 }
 
 // collectionConfiguration describes a collection resource
 type collectionConfiguration struct {
-	Resource                      string          `json:"resource"`
-	ExternalIndex                 string          `json:"external_index"`
-	StaticProperties              []string        `json:"static_properties"`
-	SearchableProperties          []string        `json:"searchable_properties"`
-	Permits                       []access.Permit `json:"permits"`
-	Description                   string          `json:"description"`
-	SchemaID                      string          `json:"schema_id"`
-	WithLog                       bool            `json:"with_log"`
-	Default                       json.RawMessage `json:"default"`
-	WithCompanionFile             bool            `json:"with_companion_file"`
-	CompanionPresignedURLValidity int             `json:"companion_presigned_url_validity"`
-	needsKSS                      bool            // true of this collection or any subcollection or subblob needs kss
+	Resource                      string                       `json:"resource"`
+	ExternalIndex                 string                       `json:"external_index"`
+	ExternalIndices               []externalIndexConfiguration `json:"external_indices"`
+	StaticProperties              []propertyConfiguration      `json:"static_properties"`
+	SearchableProperties          []propertyConfiguration      `json:"searchable_properties"`
+	FullText                      []string                     `json:"full_text"`
+	Permits                       []access.Permit              `json:"permits"`
+	Description                   string                       `json:"description"`
+	SchemaID                      string                       `json:"schema_id"`
+	WithLog                       bool                         `json:"with_log"`
+	Default                       json.RawMessage              `json:"default"`
+	WithCompanionFile             bool                         `json:"with_companion_file"`
+	CompanionPresignedURLValidity int                          `json:"companion_presigned_url_validity"`
+	SoftDelete                    bool                         `json:"soft_delete"`
+	SoftDeleteRetentionDays       int                          `json:"soft_delete_retention_days"`
+	Retention                     *retentionConfiguration      `json:"retention"`
+	needsKSS                      bool                         // true of this collection or any subcollection or subblob needs kss
+}
+
+// retentionConfiguration describes when the items of a collection expire
+type retentionConfiguration struct {
+	MaxAgeSeconds int    `json:"max_age_seconds"`
+	ExpiresAt     string `json:"expires_at"`
+	Notify        bool   `json:"notify"`
 }
 
 // singletonConfiguration describes a singleton resource
 type singletonConfiguration struct {
-	Resource             string          `json:"resource"`
-	Permits              []access.Permit `json:"permits"`
-	Description          string          `json:"description"`
-	SchemaID             string          `json:"schema_id"`
-	StaticProperties     []string        `json:"static_properties"`
-	SearchableProperties []string        `json:"searchable_properties"`
-	WithLog              bool            `json:"with_log"`
-	Default              json.RawMessage `json:"default"`
+	Resource             string                  `json:"resource"`
+	Permits              []access.Permit         `json:"permits"`
+	Description          string                  `json:"description"`
+	SchemaID             string                  `json:"schema_id"`
+	StaticProperties     []propertyConfiguration `json:"static_properties"`
+	SearchableProperties []propertyConfiguration `json:"searchable_properties"`
+	WithLog              bool                    `json:"with_log"`
+	Default              json.RawMessage         `json:"default"`
 }
 
 // blobConfiguration describes a blob collection resource
 type blobConfiguration struct {
-	Resource             string          `json:"resource"`
-	ExternalIndex        string          `json:"external_index"`
-	StaticProperties     []string        `json:"static_properties"`
-	SearchableProperties []string        `json:"searchable_properties"`
-	MaxAgeCache          int             `json:"max_age_cache"`
-	Mutable              bool            `json:"mutable"`
-	Permits              []access.Permit `json:"permits"`
-	Description          string          `json:"description"`
-	StoredExternally     bool            `json:"stored_externally"`
-	needsKSS             bool            // true of this blob or any subcollection or subblob needs kss
+	Resource             string                       `json:"resource"`
+	ExternalIndex        string                       `json:"external_index"`
+	ExternalIndices      []externalIndexConfiguration `json:"external_indices"`
+	StaticProperties     []string                     `json:"static_properties"`
+	SearchableProperties []string                     `json:"searchable_properties"`
+	MaxAgeCache          int                          `json:"max_age_cache"`
+	Mutable              bool                         `json:"mutable"`
+	Permits              []access.Permit              `json:"permits"`
+	Description          string                       `json:"description"`
+	StoredExternally     bool                         `json:"stored_externally"`
+	needsKSS             bool                         // true of this blob or any subcollection or subblob needs kss
 }
 
 // relationConfiguration is a n:m relation from
@@ -0,0 +1,69 @@
+// Copyright 2021 Dalarub & Ettrich GmbH - All Rights Reserved
+// Unauthorized copying of this file, via any medium is strictly prohibited
+// Proprietary and confidential
+// info@dalarub.com
+//
+
+package backend
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/google/uuid"
+)
+
+// paginationCursor is the position of the last item of a page in the
+// (timestamp, id, revision) ordering of collection lists. It is handed out
+// to clients as an opaque string in the "Pagination-Next-Cursor" header.
+// The cursor also records the direction and the scope of the list it belongs
+// to, so that it is not applied to a different list by mistake.
+type paginationCursor struct {
+	Timestamp time.Time `json:"t"`
+	ID        uuid.UUID `json:"i"`
+	Revision  int       `json:"r"`
+	Ascending bool      `json:"a,omitempty"`
+	Scope     string    `json:"s,omitempty"`
+}
+
+// encode returns the opaque string representation of the cursor
+func (c *paginationCursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodePaginationCursor parses a cursor previously returned by encode()
+func decodePaginationCursor(s string) (*paginationCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	c := &paginationCursor{}
+	if err = json.Unmarshal(data, c); err != nil || c.Timestamp.IsZero() {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	c.Timestamp = c.Timestamp.UTC()
+	return c, nil
+}
+
+// paginationScope returns a short hash of what selects the items of a list: the route variables and all
+// query parameters except those which only shape the pages
+func paginationScope(vars map[string]string, query url.Values) string {
+	scope := url.Values{}
+	for key, array := range query {
+		switch key {
+		case "cursor", "page", "limit", "count", "order", "metaonly", "fields", "with_companion_urls":
+		default:
+			scope[key] = array
+		}
+	}
+	for key, value := range vars {
+		scope[":"+key] = []string{value}
+	}
+	hash := sha256.Sum256([]byte(scope.Encode()))
+	return base64.RawURLEncoding.EncodeToString(hash[:8])
+}
@@ -163,6 +163,28 @@ non-zero revision number which does not match the item's current revision, then
 the conflicting newer version of the object is returned with an error status (409 - Conflict).
 A PUT or PATCH request with a revision of zero, or no revision at all, will not be checked for possible conflicts.
 
+# Patching
+
+A PATCH request with a json body merges the body into the existing item, property by property. Arrays are replaced as
+a whole, and properties cannot be removed. For finer control, PATCH also honours two other content types.
+
+With "Content-Type: application/merge-patch+json" the body is a json merge patch (RFC 7396). It works like the default,
+but a null value removes the property:
+
+	PATCH /users/f879572d-ac69-4020-b7f8-a9b3e628fd9d
+	{"settings":{"locale":null}}
+
+With "Content-Type: application/json-patch+json" the body is a json patch (RFC 6902), a list of operations which are
+applied in order. Supported operations are add, remove, replace, move, copy and test:
+
+	PATCH /users/f879572d-ac69-4020-b7f8-a9b3e628fd9d
+	[{"op":"test","path":"/name","value":"Jane"},{"op":"add","path":"/tags/-","value":"admin"}]
+
+A json patch can only address an item by its path, not by the identifier in the body. If a test operation fails, the
+request is discarded with 409 - Conflict, any other operation which cannot be applied yields 422 - Unprocessable Entity.
+All patches are subject to schema validation and interceptors, and they create revisions, logs and notifications just
+like any other update. The client supports them with Item.JSONPatch() and Item.MergePatch().
+
 # Wildcard Queries
 
 You can replace any id in a path segment with the keyword "all". For example, if some administrators wants
@@ -185,6 +207,37 @@ In addition, they are also added when older versions of objects are read from th
 are especially useful in combination with schema validation, as they make it possible to add new required properties
 without having to migrate all existing objects in the database.
 
+# External Indices
+
+An external index is a unique string property, for example the identity of a user or the thing name of a device from an
+external system. Collections and blobs declare one with "external_index", or several with "external_indices". An external
+index can also be a combination of properties which is unique as a whole, and it can be unique only within the parent
+resource instead of globally:
+
+	"resource": "fleet/user",
+	"external_indices": ["identity", {"properties":["nickname"],"scope":"parent"}, {"properties":["vendor","serial"]}]
+
+Empty values of collection indices are not indexed, hence items without the property do not conflict. For blobs, external
+indices are mandatory. Each external index gets a lookup route, which returns the same as the GET request on the item:
+
+	GET /fleets/{fleet_id}/users/nickname:{nickname}
+	GET /fleets/all/users/vendor:{vendor}/serial:{serial}
+
+The parent identifiers of a lookup may be "all", unless the index is scoped to the parent. The properties of all external
+indices are searchable, see the chapter on searching and filtering below. Adding a unique index to an existing collection
+fails if the existing items are not unique.
+
+Systems which only know the external index of an item can create or update it without knowing its primary identifier, either
+with a PUT or PATCH request on the item by external index, or with a PUT request on the collection and the query parameter "by":
+
+	PUT /devices/thing:{thing}
+	PUT /devices?by=thing
+	PUT /fleets/{fleet_id}/users?by=vendor,serial
+
+The values of the external index come from the path, or from the body with "by". If there is no item with these values yet, it
+is created, otherwise it is updated. Concurrent requests for the same values are safe, only one of them creates the item and the
+others update it. Everything else works like a regular PUT or PATCH, including revision checks and notifications.
+
 # Static Properties
 
 In the example above, we have extended the user and the device collections with an external index. Likewise it is possible to extend
@@ -204,12 +257,35 @@ to store the provisioning_status for IoT devices.
 Static properties can be made searchable by adding them to the "searchable_properties" array instead. This activates a filter
 in the collection get route with the name of the property. See the chapter on query parameters and pagination below.
 
+Static and searchable properties are strings by default. In collections and singletons, a property can declare a type
+instead of just a name:
+
+	"searchable_properties": ["serial", {"name":"battery","type":"integer"}, {"name":"seen_at","type":"timestamp"}]
+
+The supported types are "string", "integer", "numeric", "boolean" and "timestamp" (RFC3339). Typed properties get a matching
+SQL column, hence filters with range operators and sorting compare numbers and times correctly. Writes with a value of the
+wrong type fail with 400 - Bad Request. Unlike string properties, typed properties are null when they are missing. Changing
+the type of an existing property does not change the SQL column, this requires a migration, see the chapter on migrations below.
+
 # Sorting and Timestamp
 
 Collections of resources are sorted by the timestamp, with latest first. For additional flexibility, it is possible
 to overwrite the timestamp in a POST or PUT request. If you for example import workout activities of a user, you may choose to
 use the start time of each activity as timestamp.
 
+Collection lists can be sorted by other properties with the sort query parameter. It takes a comma-separated list of
+properties, each optionally prefixed with '-' for descending or '+' for ascending order (the default):
+
+	GET /users?sort=-searchable_prop,name
+
+Identifiers, static and searchable properties, the external index, the timestamp and the revision are sorted as columns,
+everything else is sorted on the JSON properties. JSON values are compared like in Postgres' jsonb, i.e. numbers numerically,
+strings lexically, and values of different types by type, with strings before numbers before booleans. Items which lack the
+property come last in ascending order. Searchable properties and the external index are backed by database indices, hence they
+are the fast choice for large collections. Items with identical sort values are ordered by their primary identifier, which makes
+paging stable. Sorting works the same on relation routes and with the "all" wildcard. The sort parameter replaces the order
+parameter and cannot be combined with cursors.
+
 # Query Parameters and Pagination
 
 The GET request on single resources - i.e. not on entire collections - can be customized with the "children" query parameter.
@@ -248,8 +324,71 @@ _ represents one, single character
 	GET /users?filter=identity~%@test.com
 	returns all users with an email which ends with @test.com
 
+Besides equality and pattern, filters support these operators:
+
+	property!=value      not equal. Items lacking the property are different from any value
+	property>value       greater than, also >=, < and <=. The value must either be a number or an
+	                     RFC3339 timestamp, the property is compared as number or as timestamp accordingly.
+	                     Items whose property cannot be interpreted that way do not match.
+	property~*pattern    case-insensitive pattern (SQL ILIKE)
+	property IN (a,b,c)  property equals one of the listed values
+	property IS NULL     property is missing, null or - for static properties - empty
+	property IS NOT NULL property has a value
+
+	GET /users?filter=age>=18&filter=email~*%25@TEST.COM
+	returns all users of age 18 or older with an email ending with @test.com in any case
+
+The property ends at the first operator. Only the values of = and != may contain '=', because a filter like a~b=c used
+to compare the property a~b, and it is rejected as ambiguous.
+
+Properties of nested JSON objects are addressed with dotted paths, and JSON arrays can be searched for elements
+with the @> (contains) operator:
+
+	GET /devices?filter=hardware.revision>=3
+	returns all devices with {"hardware":{"revision":3}} or higher
+
+	GET /users?filter=tags@>beta
+	returns all users with "beta" in their tags array
+
+The contains operator matches numbers and booleans as well as strings, i.e. tags@>3 matches both [3] and ["3"]. Dotted paths
+also work with the sort parameter.
+
+Malformed filters, for example a comparison with a value which is neither a number nor a timestamp, are rejected with
+a bad request error explaining the problem.
+
 If you specify multiple filters, they filter on top of each other (i.e. with logical AND).
 
+Alternatives are expressed with groups of filters. All filter_or parameters with the same name form one group, and an item
+matches the group if it matches at least one of its filters. Groups are named "filter_or" or "filter_or.<name>":
+
+	GET /users?filter_or=status=failed&filter_or=status=overdue
+	returns all users which have either failed or are overdue
+
+	GET /users?filter=age>=18&filter_or.a=status=failed&filter_or.a=status=overdue&filter_or.b=country=DE&filter_or.b=country=AT
+	returns all adult users which have either failed or are overdue, and which live either in Germany or in Austria
+
+The client supports groups with Collection.WithFilterOr().
+
+For free text, a collection can be configured for full text search. The "full_text" array lists the properties which form the
+searchable document, either JSON properties or static properties. Typed static properties are searched as text, timestamps
+in the format 2021-03-01T12:00:00Z:
+
+	{
+	  "resource": "article",
+	  "full_text": ["title", "abstract"]
+	}
+
+The backend maintains a generated text search column together with an index for these properties. The query parameter q
+then selects the items which match a query, most relevant first:
+
+	GET /articles?q=postgres -mysql
+	returns all articles which contain "postgres" but not "mysql", ordered by relevance
+
+The query follows the syntax of web search engines: words are combined with AND, "or" combines alternatives, quoted text
+searches for phrases and a leading minus excludes a word. Words are not stemmed. The q parameter can be combined with
+filters and with sort, which then replaces the ordering by relevance. It cannot be combined with cursor or order. Changing the
+full_text array of an existing collection does not update the existing search column, which has to be dropped manually.
+
 Filters can be combined with the wildcard 'all' keyword. For instance, it is possible to get all the devices of a user by filtering
 on the user_id property
 
@@ -278,9 +417,114 @@ The maximum allowed limit is 100, which is also the default limit. Combining pag
 avoids page drift. A well-behaving application would get the first page without any filter, and then use the timestamp
 reported in the "Pagination-Until" header as until-parameter for querying pages further down.
 
+For large or fast-growing collections, cursor-based pagination is the better choice. Whenever a response contains a full page,
+it carries an additional header
+
+	"Pagination-Next-Cursor"  an opaque cursor pointing to the last item in the response
+
+Passing this value back as ?cursor=c returns the items right after that position, following the same order as the
+original request. Cursors do not drift when items are added or deleted, and their cost does not grow with the page number. They
+can be combined with all other query parameters except page. A cursor belongs to the order and the filters of the request which
+returned it, passing it with a different order or different filters fails with 400 - Bad Request. Cursor responses carry neither "Pagination-Total-Count" nor
+"Pagination-Page-Count". Counting can also be switched off for numbered pages with ?count=false, which saves a full scan of
+the matching items on large collections.
+
+Responses can be trimmed to the properties a client actually needs with the fields query parameter. It takes a comma-separated
+list of properties, dots select properties of nested objects:
+
+	GET /users?fields=name,settings.locale
+
+The identifiers, the timestamp and the revision are always part of the response, as are requested children. The fields parameter
+is supported for collections, singletons, logs and relations, both for single items and for lists. The Etag reflects the trimmed
+response.
+
 For collections it is possible to only retrieve meta data, by specifying the ?onlymeta=true query parameter. Meta data are
 all defining identifiers, the timestamp and each object's revision number.
 
+# Aggregation
+
+Collections have an additional aggregate route, for example
+
+	GET /devices/aggregate?group_by=provisioning_status&count
+
+returns the number of devices per provisioning status:
+
+	[{"count":12,"provisioning_status":"provisioned"},{"count":3,"provisioning_status":"waiting"}]
+
+The route supports the following query parameters:
+
+	?group_by=p1,p2    groups by the listed properties. Without group_by, the entire collection forms one group
+	?count             counts the items of each group. This is the default if no other aggregate is requested
+	?sum=p1,p2         sums up numeric properties, reported as "sum_<property>"
+	?avg=p1,p2         averages numeric properties, reported as "avg_<property>"
+	?min=p1,p2         minimum of properties, reported as "min_<property>"
+	?max=p1,p2         maximum of properties, reported as "max_<property>"
+
+Properties can be identifiers, static and searchable properties, the external index, the timestamp, the revision or - with
+dotted paths - JSON properties. Group values, minima and maxima keep the type of the property, e.g. typed integer properties
+are reported as numbers and the timestamp as time. JSON properties are compared like in Postgres' jsonb, i.e. numbers
+numerically and strings lexically; the minimum and maximum skip JSON null. Sum and average are computed on numbers only,
+values which are not numbers are ignored, and they are not supported for identifiers, booleans and timestamps. The minimum
+of a boolean property is true only if all values are true. The route supports the same filters as the list route,
+including from, until and the wildcard 'all'. The number of groups is limited to 1000, an aggregation with more groups fails
+with 422 - Unprocessable Entity instead of returning incomplete results.
+Aggregation is authorized with the "list" operation. The client supports aggregation with Collection.Aggregate().
+
+# Export
+
+Lists are limited to 100 items per page. For analytics and backups, collections have an additional export route which streams
+all matching items in a single response:
+
+	GET /users/export?format=ndjson
+	GET /users/all/devices/export?format=csv&filter=provisioning_status=provisioned
+
+The format is either "ndjson" (the default), one json object per line, or "csv". CSV files have a header row followed by one row
+per item, with the identifiers, the timestamp, the revision and all static properties as columns. The remaining properties form
+a final "properties" column in json, unless the fields query parameter selects individual properties as columns:
+
+	GET /users/export?format=csv&fields=name,settings.locale
+
+The export route supports the same filters as the list route, including from, until, order and the wildcard 'all'. Items are read
+from the database with a server-side cursor in batches, so the memory footprint does not depend on the size of the collection.
+Request interceptors for "list" are applied to each batch like to a page of the list route. Exports require the "export" operation in a permit, or the "admin" role.
+
+# Import
+
+The counterpart of the export route is the import route, which creates many items with a single request:
+
+	POST /users/import
+	POST /users/all/devices/import?notify=true
+
+The body is either a json array of objects or newline delimited json with one object per line. Each item is created as if it
+was posted individually: it is validated against the schema, default properties are applied, and request interceptors for
+"create" are called. Items which carry a primary identifier are created with that identifier, all others get a new one. With
+the wildcard 'all', each item must carry its parent identifiers.
+
+Items are written in transactions of 100 items, the query parameter batch_size selects a different size of up to 1000. A
+failing item does not affect the other items of its batch. The response reports the result of each item:
+
+	[{"index":0,"status":201,"id":"f879572d-ac69-4020-b7f8-a9b3e628fd9d"},{"index":1,"status":409,"error":"constraint violation"}]
+
+The "index" is the position of the item in the body starting with 0, for json arrays the position in the array, for newline
+delimited json the position among the non-empty lines. Imports do not send notifications, unless the query parameter notify=true is set. Imports require the
+"create" operation in a permit. The client supports imports with Collection.Import().
+
+# Idempotency Keys
+
+Clients on unreliable networks may retry a POST request without knowing whether the first attempt created an item.
+To avoid duplicates, POST requests to collections can carry an Idempotency-Key header with a unique string of up to
+255 characters, for example a UUID:
+
+	POST /users
+	Idempotency-Key: 6bd6c3a4-4b5e-4f7e-9c1f-0c6f4c1f9a52
+
+The response of the first request is stored per key and requester identity, i.e. per authorization. A retry with the
+same key and the same request replays the stored response with the additional header "Idempotent-Replayed: true". A
+retry with the same key but a different path or body is rejected with 422 - Unprocessable Entity, and a retry while the
+first request is still running gets 409 - Conflict. A request which did not finish within 5 minutes, for example because
+the server crashed, no longer blocks retries. Server errors are not stored, those requests can be retried.
+Responses are kept for 24 hours, the Builder's IdempotencyWindow selects a different duration.
+
 # Primary Resource Identifier
 
 The primary resource identifier is not mandatory when creating resources. If the creation request (POST or PUT) contains
@@ -299,6 +543,99 @@ an extra route
 which will return all versions of the device object ever created, with a timestamp when that creation or modification did
 happen. Querying the log supports all the standard collection query parameters, including pagination and filtering.
 
+Each log entry also records the change in an envelope "_log": who made the change in "author", which is the authenticated
+identity, the "request_id" of the change, and the "operation", which is one of "create", "update", "update_property" or
+"delete", for example
+
+	{"device_id":"...", "name":"v2", "revision":2, "_log":{"author":"jane@example.com","request_id":"...","operation":"update"}}
+
+With a log, "author", "request_id" and "operation" cannot be static or searchable properties, likewise "deleted_at" with
+soft delete and "fulltext" with full text search. Deleting an item leaves a tombstone with the last state of the item as
+a final revision, and so do its children with log which are deleted together with it. The query parameter "author"
+returns only the changes of a specific identity:
+
+	/devices/{device_id}/log?author=jane@example.com GET
+
+The log also answers how an object looked in the past. The query parameter "revision" returns a specific revision,
+and "as_of" returns the object as it was at a given time:
+
+	/devices/{device_id}?revision=3 GET
+	/devices/{device_id}?as_of=2021-03-01T12:00:00Z GET
+
+For audits, "as_of" also works on the list route, which then shows every item in its latest revision written before
+that time. Items which were deleted by then are not found, and neither is the revision of a tombstone. The timestamp
+of historic objects is the time when the revision was written. Full text search cannot be combined with "as_of".
+
+A bad edit is undone by writing a previous revision back:
+
+	/devices/{device_id}/log/{revision}/restore POST
+
+This is the same as a PUT of the historic object. It creates a new revision with the item's current timestamp, hence
+schema validation, request interceptors and the "update" notification apply as usual. It requires the "read" and the
+"update" operation in a permit. A tombstone cannot be restored, but an earlier revision of a deleted item can: this
+creates the item again with its identifier, which also requires the "create" operation and sends a "create"
+notification. Soft deleted items are restored with their restore route first.
+
+Two revisions are compared with
+
+	/devices/{device_id}/log/diff?from=3&to=7 GET
+
+which returns the json patch (RFC 6902) that turns revision "from" into revision "to", together with the times when
+both revisions were written:
+
+	{"from":3,"to":7,"from_timestamp":"2021-03-01T12:00:00Z","to_timestamp":"2021-03-02T08:30:00Z",
+	 "patch":[{"op":"replace","path":"/name","value":"Jane"}]}
+
+# Soft Delete
+
+Deleting an item deletes it for good, including all its children. If you specify "soft_delete":true for a collection in
+the configuration json, a delete only marks the item and its child collections and singletons as deleted. Deleted items
+are hidden from all routes, as if they did not exist. Admins can see them with the query parameter include_deleted=true on
+read and list routes, the property "deleted_at" then holds the time of deletion. A deleted item is brought back with
+
+	/devices/{device_id}/restore POST
+
+which also restores the children that were deleted together with it. Restore requires the "delete" operation in a permit
+and sends a "create" notification. Both the deletion and the restore of an item create a new revision, also for its
+children. Children of a deleted item cannot be created or restored on their own, and a PUT to a deleted identifier
+fails with 409 - Conflict. Blobs are not soft deleted themselves, but blobs of a deleted item are hidden together with
+it and come back when it is restored.
+
+Deleted items do not occupy the values of their external indices, new items can use them again. Restoring an item whose
+values are in use fails with 409 - Conflict.
+
+Deleted items are purged after 30 days, "soft_delete_retention_days" selects a different retention. Purging is done
+hourly through the job pipeline, hence the service must process jobs. Purging also removes the blobs and companion files
+of the items.
+
+# Retention
+
+Collections which grow without bound, like sensor data or sessions, can delete their items automatically:
+
+	{
+	  "resource": "device/data",
+	  "retention": {
+	    "max_age_seconds": 2592000
+	  }
+	},
+	{
+	  "resource": "session",
+	  "static_properties": ["expires_at"],
+	  "retention": {
+	    "expires_at": "expires_at",
+	    "notify": true
+	  }
+	}
+
+With "max_age_seconds", items expire that many seconds after their timestamp. With "expires_at", items expire at the
+time held by the named static or searchable property, which must be a RFC3339 time or of type "timestamp". Items with
+an empty or invalid expiry time never expire. If both are set, items expire with whatever comes first.
+
+Expired items are deleted hourly through the job pipeline, in transactions of up to 1000 items. Like all deletes, this
+removes the children and companion files of the items, and leaves tombstones in the log of collections with log.
+Deleting expired items does not send notifications, unless "notify" is true; then every expired item gets a "delete"
+notification.
+
 # Notifications
 
 The backend supports notifications through the Notifier interface specified at construction time.
@@ -337,6 +674,33 @@ established.
 Relations can also be given an explicit Resource name just like any other collection, which allows multiple different
 relations from the the same resource types. The resource name then becomes a prefix to access the relation.
 
+# Batch
+
+Several write operations across collections, singletons and relations can be executed atomically with a single request:
+
+	POST /kurbisio/batch
+
+	[
+	  {"operation":"create", "path":"/fleets", "body":{"name":"fleet 1"}},
+	  {"operation":"create", "path":"/fleets/{{0.fleet_id}}/users", "body":{"name":"user 1"}},
+	  {"operation":"relate", "path":"/users/{{1.user_id}}/devices/f879572d-ac69-4020-b7f8-a9b3e628fd9d"}
+	]
+
+The operations are "create" (POST on a collection), "upsert" and "patch" (PUT and PATCH on a collection or an item),
+"delete" (DELETE on an item), "relate" and "unrelate" (PUT and DELETE on a relation). Strings in paths and bodies can
+reference properties of the response of a previous operation with {{N.property}}, where N is the position of that operation
+starting with 0. Nested properties are separated by dots. A string which consists of a single reference takes the type of
+the referenced value.
+
+All operations are executed in a single transaction. Each operation is handled by its regular route, hence it is authorized
+individually and request interceptors apply as usual. If an operation fails, nothing is committed and the response carries
+the status of the failing operation:
+
+	{"operation":1,"status":404,"error":"..."}
+
+Otherwise the response is a json array with the status and the body of each operation. Notifications are only sent
+for successful batches. A batch is limited to 1000 operations.
+
 # Blobs
 
 Blobs are collections of binary resources. They will be served to the client as-is. You can use blobs
@@ -386,8 +750,8 @@ on If-None-Match and Etag below.
 If AuthorizationEnabled is set to true, the backend supports role based access control to its resources.
 By default, only the "admin" role has a permit to access resources. A permit object for each resource
 authorizes specific roles to execute specific operations. The different operations are: "create", "read", "update",
-"delete", "list" and "clear". The "list"-operation is the retrieval of the entire collection, "clear" deletes the entire
-collection.
+"delete", "list", "clear" and "export". The "list"-operation is the retrieval of the entire collection, "clear" deletes the entire
+collection and "export" streams the entire collection with the export route.
 
 "admin viewer" also has right to access all resources in read only mode. Only read and list operations are permitted.
 
@@ -454,6 +818,18 @@ the received Etag of a request into the If-None-Match header of a subsequent req
 simply response to that subsequent with a 304 Not Modified in case the resource was not changed. In case
 the resource was changed, the request will be answered as usual.
 
+# If-Match and If-Unmodified-Since
+
+The Etag of a single item can also be used for optimistic concurrency. PUT, PATCH and DELETE requests on collection items,
+singletons and mutable blobs obey the If-Match header: if the item's current Etag does not match, the request is discarded
+with 412 - Precondition Failed, and the response carries the current Etag. An If-Match for an item which does not exist
+yet also fails, hence it prevents an upsert from creating a new item. The Etag to use is the one of a plain GET
+request, i.e. without the fields or children query parameters.
+
+Alternatively, the If-Unmodified-Since header discards a request if the item's timestamp is later than the given
+http date. Since collection items only change their timestamp on explicit request, If-Match is the better choice
+for them. Both headers work independently of the revision property described above.
+
 # Externally stored data
 
 Collections allow to store a file with each individual collection item. Unlike blobs which should
@@ -507,6 +883,74 @@ key which defines the duration in seconds for which the URL will be valid
 
 # Deleting a resource also delete the associated companion file if it exist
 
+# Migrations
+
+Whenever the configuration changes, the backend adds new tables, columns and indices to the database. Everything else,
+like renaming a resource or changing the type of a property, is done with a versioned list of "migrations":
+
+	"migrations": [
+	  {
+	    "version": 1,
+	    "description": "devices become things",
+	    "steps": [
+	      {"op": "rename_resource", "resource": "fleet/device", "to": "fleet/thing"},
+	      {"op": "retype_property", "resource": "fleet/thing", "property": "battery", "type": "integer"},
+	      {"op": "drop_property", "resource": "fleet/thing", "property": "legacy_id"}
+	    ]
+	  },
+	  {
+	    "version": 2,
+	    "steps": [
+	      {"op": "rename_property", "resource": "fleet/thing", "property": "sn", "to": "serial"},
+	      {"op": "backfill", "resource": "fleet/thing", "property": "model", "from": "model_name"},
+	      {"op": "backfill", "resource": "fleet/thing", "property": "region", "value": "eu"}
+	    ]
+	  }
+	]
+
+The supported steps are:
+
+	rename_resource  renames the last segment of a resource, including its log, its children, its identifier column and its indices
+	drop_resource    drops a resource, its log and its children
+	rename_property  renames the column of a static or searchable property or an external index
+	drop_property    drops the column of a static or searchable property or an external index
+	retype_property  changes the type of a static or searchable property. Values which cannot be converted fail the migration
+	backfill         sets a static or searchable property of all items which lack it, either from a first level property of
+	                 the JSON document ("from") or to a constant value ("value")
+
+Migrations are applied in the order of their versions, when the backend starts with a new configuration. They run under
+the same advisory lock as the schema update, hence only one instance of the service migrates the database. All steps
+except backfills run before the tables of the new configuration are created, backfills run afterwards. Each version is
+applied only once and recorded in the registry, applied versions must therefore stay in the configuration unchanged.
+
+# Dry Run
+
+A new configuration can be checked against a database before it is deployed, without changing the database. An admin
+posts the configuration to
+
+	/kurbisio/dry-run
+
+and receives the plan, which compares it with the live schema and with the configuration of the running backend:
+
+	{
+	  "tables": {"added": ["fleet/thing/log"], "orphaned": ["fleet/gadget"]},
+	  "columns": {"added": ["fleet/thing.serial"], "orphaned": ["fleet/thing.sn"]},
+	  "indices": {"added": ["fleet/thing.searchable_property_thing_serial"], "orphaned": []},
+	  "routes": {"added": ["GET /fleets/{fleet_id}/things/serial:{serial}"], "orphaned": ["GET /fleets/{fleet_id}/gadgets"]},
+	  "migrations": [3],
+	  "sql": ["CREATE table IF NOT EXISTS ...;", "ALTER TABLE ...;"]
+	}
+
+Added objects do not exist yet, orphaned objects exist but are not used by the configuration anymore. Orphaned objects
+are never dropped, except by migrations. Columns and indices are listed only for tables which exist on both sides. The
+sql contains the statements which would change the schema. Pending migrations are listed by version, but they are not
+part of the other changes.
+
+The same plan is available in Go as backend.DryRun(), which compares with the configuration that was deployed last,
+and as command line tool:
+
+	go run github.com/relabs-tech/kurbisio/cmd/kurbisio -schema fleet -config fleet.json
+
 # Statistics
 
 Statistics about the backend can be retrieved by doing a GET request to:
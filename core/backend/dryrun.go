@@ -0,0 +1,483 @@
+// Copyright 2021 Dalarub & Ettrich GmbH - All Rights Reserved
+// Unauthorized copying of this file, via any medium is strictly prohibited
+// Proprietary and confidential
+// info@dalarub.com
+//
+
+package backend
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/goccy/go-json"
+
+	"github.com/gorilla/handlers"
+	"github.com/gorilla/mux"
+	"github.com/relabs-tech/kurbisio/core/access"
+	"github.com/relabs-tech/kurbisio/core/csql"
+	"github.com/relabs-tech/kurbisio/core/logger"
+	"github.com/relabs-tech/kurbisio/core/schema"
+)
+
+// Plan is the result of a dry run. It describes how a configuration would change the database
+// schema and the routes of a backend.
+type Plan struct {
+	// Tables, Columns and Indices compare the configuration with the live database schema. Columns
+	// and indices are only listed for tables which exist on both sides.
+	Tables  Changes `json:"tables"`
+	Columns Changes `json:"columns"`
+	Indices Changes `json:"indices"`
+	// Routes compares the configuration with the deployed configuration
+	Routes Changes `json:"routes"`
+	// Migrations are the versions of the pending migrations. They are not part of the other changes.
+	Migrations []int `json:"migrations"`
+	// SQL are the statements which would update the database schema
+	SQL []string `json:"sql"`
+}
+
+// Changes lists what a configuration would add, and what it would leave orphaned. Orphaned
+// objects exist, but the configuration does not use them anymore. They are never dropped.
+type Changes struct {
+	Added    []string `json:"added"`
+	Orphaned []string `json:"orphaned"`
+}
+
+// registryTable is created by the registry and not by the configuration
+const registryTable = "_registry_"
+
+// DryRun compares the configuration of the builder with the live schema of its database, and with
+// the configuration which was deployed last. It neither changes the database nor the router of the
+// builder. The configuration's JSON schemas are taken from the builder, too.
+func DryRun(bb *Builder) (*Plan, error) {
+	if bb.DB == nil {
+		return nil, fmt.Errorf("DB is missing")
+	}
+	var (
+		jsonValidator *schema.Validator
+		err           error
+	)
+	if bb.JSONSchemasFS != nil {
+		jsonValidator, err = schema.NewValidatorFromFS(*bb.JSONSchemasFS)
+	} else {
+		jsonValidator, err = schema.NewValidator(bb.JSONSchemas, bb.JSONSchemasRefs)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot create json validator: %v", err)
+	}
+
+	var deployedConfig json.RawMessage
+	if _, err = readRegistry(bb.DB, "_backend_:configuration", &deployedConfig); err != nil {
+		return nil, err
+	}
+	return dryRun(bb.DB, jsonValidator, bb.Config, string(deployedConfig))
+}
+
+func (b *Backend) handleDryRun(router *mux.Router) {
+	logger.Default().Debugln("dry run")
+	logger.Default().Debugln("  handle dry run route: /kurbisio/dry-run POST")
+	router.Handle("/kurbisio/dry-run", handlers.CompressHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logger.FromContext(r.Context()).Infoln("called route for", r.URL, r.Method)
+		b.dryRunWithAuth(w, r)
+	}))).Methods(http.MethodOptions, http.MethodPost)
+}
+
+// dryRunWithAuth compares the configuration in the request body with the configuration of this backend
+func (b *Backend) dryRunWithAuth(w http.ResponseWriter, r *http.Request) {
+	rlog := logger.FromContext(r.Context())
+	if b.authorizationEnabled {
+		auth := access.AuthorizationFromContext(r.Context())
+		if !auth.HasRole("admin") {
+			http.Error(w, "not authorized", http.StatusUnauthorized)
+			return
+		}
+	}
+
+	config, err := io.ReadAll(r.Body)
+	if err != nil {
+		rlog.WithError(err).Errorln("Error 4822: cannot read body")
+		http.Error(w, "Error 4822: cannot read body", http.StatusBadRequest)
+		return
+	}
+	plan, err := dryRun(b.db, b.jsonValidator, string(config), b.configJSON)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	jsonData, _ := json.Marshal(plan)
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Write(jsonData)
+}
+
+// execSchema runs a query which updates the database schema. In a dry run, the query is only recorded.
+func (b *Backend) execSchema(query string) error {
+	if b.dryRun {
+		b.schemaStatements = append(b.schemaStatements, query)
+		return nil
+	}
+	_, err := b.db.Exec(query)
+	return err
+}
+
+// dryRun creates the plan for config. The routes are compared with deployedConfig, if there is one.
+func dryRun(db *csql.DB, jsonValidator *schema.Validator, config, deployedConfig string) (*Plan, error) {
+	b, err := newDryRunBackend(db, jsonValidator, config)
+	if err != nil {
+		return nil, err
+	}
+	deployedRoutes := map[string]bool{}
+	if deployedConfig != "" {
+		deployed, err := newDryRunBackend(db, jsonValidator, deployedConfig)
+		if err != nil {
+			return nil, fmt.Errorf("deployed configuration: %v", err)
+		}
+		deployedRoutes = dryRunRoutes(deployed.router)
+	}
+
+	live, err := liveSchemaObjects(db)
+	if err != nil {
+		return nil, err
+	}
+	expected := newSchemaObjects()
+	plan := &Plan{Migrations: []int{}, SQL: []string{}}
+	for _, query := range b.schemaStatements {
+		for _, statement := range splitSQL(query, ';') {
+			if expected.add(statement, live) {
+				plan.SQL = append(plan.SQL, statement+";")
+			}
+		}
+	}
+	// the registry is part of every backend
+	expected.tables[registryTable] = true
+	for column := range live.columns {
+		if strings.HasPrefix(column, registryTable+".") {
+			expected.columns[column] = true
+		}
+	}
+
+	plan.Tables = compareSets(expected.tables, live.tables)
+	shared := func(object string) bool {
+		table := object[:strings.LastIndex(object, ".")]
+		return expected.tables[table] && live.tables[table]
+	}
+	plan.Columns = compareSets(filterSet(expected.columns, shared), filterSet(live.columns, shared))
+	plan.Indices = compareSets(filterSet(expected.indexSet(), shared), filterSet(live.indexSet(), shared))
+	plan.Routes = compareSets(dryRunRoutes(b.router), deployedRoutes)
+
+	for _, migration := range b.config.Migrations {
+		for _, step := range migration.Steps {
+			if err = step.validate(); err != nil {
+				return nil, fmt.Errorf("migration %d: %v", migration.Version, err)
+			}
+		}
+		timestamp, err := readRegistry(db, migrationRegistryPrefix+":"+strconv.Itoa(migration.Version), nil)
+		if err != nil {
+			return nil, err
+		}
+		if timestamp.IsZero() {
+			plan.Migrations = append(plan.Migrations, migration.Version)
+		}
+	}
+	return plan, nil
+}
+
+// newDryRunBackend creates a backend for config which records its schema updates instead of executing
+// them. Its router is a new one, it has no housekeeping and it does not process jobs. Invalid
+// configurations, which make New panic, are returned as errors.
+func newDryRunBackend(db *csql.DB, jsonValidator *schema.Validator, config string) (b *Backend, err error) {
+	var configuration Configuration
+	if err = json.Unmarshal([]byte(config), &configuration); err != nil {
+		return nil, fmt.Errorf("parse error in backend configuration: %s", err)
+	}
+	configValidator, err := schema.NewValidator([]string{ConfigSchemaJSON}, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err = configValidator.ValidateString(config, "https://kurbis.io/schemas/config.json"); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %v", err)
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			b, err = nil, fmt.Errorf("invalid configuration: %v", r)
+		}
+	}()
+	router := mux.NewRouter()
+	router.UseEncodedPath()
+	b = &Backend{
+		config:                   configuration,
+		configJSON:               config,
+		db:                       db,
+		router:                   router,
+		collectionFunctions:      make(map[string]*collectionFunctions),
+		relations:                make(map[string]string),
+		batchRoutes:              make(map[string]batchRoute),
+		softDeletes:              make(map[string]time.Duration),
+		logColumns:               make(map[string][]string),
+		housekeeping:             make(map[string][]housekeepingTask),
+		callbacks:                make(map[string]jobHandler),
+		rateLimits:               make(map[string]rateLimit),
+		interceptors:             make(map[string]requestHandler),
+		collectionsAndSingletons: make(map[string]bool),
+		jsonValidator:            jsonValidator,
+		updateSchema:             true,
+		dryRun:                   true,
+	}
+	access.HandleAuthorizationRoute(router)
+	b.handleResourceRoutes()
+	b.handleStatistics(router)
+	b.handleVersion(router)
+	b.handleJobs(router)
+	b.handleIdempotency()
+	b.handleBatch(router)
+	b.handleDryRun(router)
+	return b, nil
+}
+
+// dryRunRoutes returns all routes of router as "{method} {path template}", without OPTIONS
+func dryRunRoutes(router *mux.Router) map[string]bool {
+	routes := map[string]bool{}
+	router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil { // route for all methods
+			routes[path] = true
+			return nil
+		}
+		for _, method := range methods {
+			if method != http.MethodOptions {
+				routes[method+" "+path] = true
+			}
+		}
+		return nil
+	})
+	return routes
+}
+
+// readRegistry reads a value of the registry without creating the registry, like the registry
+// package would. A missing registry is the same as a missing value.
+func readRegistry(db *csql.DB, key string, value interface{}) (time.Time, error) {
+	var (
+		exists    bool
+		rawValue  json.RawMessage
+		timestamp time.Time
+	)
+	err := db.QueryRow("SELECT EXISTS (SELECT 1 FROM information_schema.tables WHERE table_schema=$1 AND table_name=$2);",
+		sqlIdentifier(db.Schema), registryTable).Scan(&exists)
+	if err != nil || !exists {
+		return timestamp, err
+	}
+	err = db.QueryRow(`SELECT value, timestamp FROM `+db.Schema+`."`+registryTable+`" WHERE key=$1;`, key).Scan(&rawValue, &timestamp)
+	if err == csql.ErrNoRows {
+		return timestamp, nil
+	}
+	if err != nil {
+		return timestamp, fmt.Errorf("cannot read key '%s': %s", key, err.Error())
+	}
+	if value != nil {
+		err = json.Unmarshal(rawValue, value)
+	}
+	return timestamp, err
+}
+
+// schemaObjects are the tables, columns and indices of a database schema. Columns are "{table}.{column}".
+type schemaObjects struct {
+	tables  map[string]bool
+	columns map[string]bool
+	indices map[string]string // index name to table, index names are unique within the schema
+}
+
+func newSchemaObjects() *schemaObjects {
+	return &schemaObjects{
+		tables:  map[string]bool{},
+		columns: map[string]bool{},
+		indices: map[string]string{},
+	}
+}
+
+// indexSet returns the indices as "{table}.{index}"
+func (s *schemaObjects) indexSet() map[string]bool {
+	set := map[string]bool{}
+	for index, table := range s.indices {
+		set[table+"."+index] = true
+	}
+	return set
+}
+
+var (
+	sqlIdentifierPattern  = `("[^"]+"|[^\s."(]+)`
+	sqlCreateTablePattern = regexp.MustCompile(`(?is)^CREATE\s+table\s+IF\s+NOT\s+EXISTS\s+[^\s.]+\.` + sqlIdentifierPattern + `\s*\((.*)\)$`)
+	sqlAddColumnPattern   = regexp.MustCompile(`(?is)^ALTER\s+TABLE\s+[^\s.]+\.` + sqlIdentifierPattern + `\s+ADD\s+COLUMN\s+IF\s+NOT\s+EXISTS\s+` + sqlIdentifierPattern)
+	sqlCreateIndexPattern = regexp.MustCompile(`(?is)^CREATE\s+(?:UNIQUE\s+)?index\s+IF\s+NOT\s+EXISTS\s+` + sqlIdentifierPattern + `\s+ON\s+[^\s.]+\.` + sqlIdentifierPattern)
+)
+
+// add adds the objects which statement creates, and returns whether the statement would change the
+// live schema. Statements which are not understood are assumed to change it.
+func (s *schemaObjects) add(statement string, live *schemaObjects) bool {
+	if match := sqlCreateTablePattern.FindStringSubmatch(statement); match != nil {
+		table := sqlIdentifier(match[1])
+		s.tables[table] = true
+		for _, definition := range splitSQL(match[2], ',') {
+			name := strings.FieldsFunc(definition, func(r rune) bool { return r == ' ' || r == '\n' || r == '\t' || r == '(' })[0]
+			switch strings.ToUpper(name) {
+			case "FOREIGN", "UNIQUE", "PRIMARY", "CONSTRAINT", "CHECK", "EXCLUDE":
+			default:
+				s.columns[table+"."+sqlIdentifier(name)] = true
+			}
+		}
+		return !live.tables[table]
+	}
+	if match := sqlAddColumnPattern.FindStringSubmatch(statement); match != nil {
+		column := sqlIdentifier(match[1]) + "." + sqlIdentifier(match[2])
+		s.columns[column] = true
+		return !live.columns[column]
+	}
+	if match := sqlCreateIndexPattern.FindStringSubmatch(statement); match != nil {
+		index := sqlIdentifier(match[1])
+		s.indices[index] = sqlIdentifier(match[2])
+		_, exists := live.indices[index]
+		return !exists
+	}
+	return true
+}
+
+// liveSchemaObjects reads the tables, columns and indices of the database schema. Indices which
+// implement primary keys and unique constraints are part of their tables and hence omitted.
+func liveSchemaObjects(db *csql.DB) (*schemaObjects, error) {
+	live := newSchemaObjects()
+	schema := sqlIdentifier(db.Schema) // the schema is created unquoted
+	rows, err := db.Query("SELECT table_name FROM information_schema.tables WHERE table_schema=$1 AND table_type='BASE TABLE';", schema)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var table string
+		if err = rows.Scan(&table); err != nil {
+			return nil, err
+		}
+		live.tables[table] = true
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = db.Query("SELECT table_name, column_name FROM information_schema.columns WHERE table_schema=$1;", schema)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var table, column string
+		if err = rows.Scan(&table, &column); err != nil {
+			return nil, err
+		}
+		if live.tables[table] {
+			live.columns[table+"."+column] = true
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = db.Query(`SELECT i.indexname, i.tablename FROM pg_indexes i WHERE i.schemaname=$1 AND NOT EXISTS
+(SELECT 1 FROM pg_constraint c JOIN pg_namespace n ON n.oid=c.connamespace WHERE n.nspname=$1 AND c.conname=i.indexname);`, schema)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var index, table string
+		if err = rows.Scan(&index, &table); err != nil {
+			return nil, err
+		}
+		live.indices[index] = table
+	}
+	return live, rows.Err()
+}
+
+// sqlIdentifier returns the name of a SQL identifier. Unquoted identifiers are folded to lower case,
+// and postgres truncates all identifiers to 63 bytes.
+func sqlIdentifier(identifier string) string {
+	if len(identifier) > 1 && strings.HasPrefix(identifier, `"`) && strings.HasSuffix(identifier, `"`) {
+		identifier = identifier[1 : len(identifier)-1]
+	} else {
+		identifier = strings.ToLower(identifier)
+	}
+	if len(identifier) > 63 {
+		identifier = identifier[:63]
+	}
+	return identifier
+}
+
+// splitSQL splits SQL at separator, unless the separator is quoted or in parentheses. The parts are
+// trimmed, empty parts are omitted.
+func splitSQL(query string, separator rune) []string {
+	var (
+		parts  []string
+		quote  rune
+		depth  int
+		start  int
+		output = func(end int) {
+			if part := strings.TrimSpace(query[start:end]); part != "" {
+				parts = append(parts, part)
+			}
+		}
+	)
+	for i, r := range query {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"':
+			quote = r
+		case r == '(':
+			depth++
+		case r == ')':
+			depth--
+		case r == separator && depth == 0:
+			output(i)
+			start = i + 1
+		}
+	}
+	output(len(query))
+	return parts
+}
+
+// compareSets returns the objects which are only expected as added, and those which only exist as orphaned
+func compareSets(expected, existing map[string]bool) Changes {
+	changes := Changes{Added: []string{}, Orphaned: []string{}}
+	for object := range expected {
+		if !existing[object] {
+			changes.Added = append(changes.Added, object)
+		}
+	}
+	for object := range existing {
+		if !expected[object] {
+			changes.Orphaned = append(changes.Orphaned, object)
+		}
+	}
+	sort.Strings(changes.Added)
+	sort.Strings(changes.Orphaned)
+	return changes
+}
+
+func filterSet(set map[string]bool, keep func(string) bool) map[string]bool {
+	filtered := map[string]bool{}
+	for object := range set {
+		if keep(object) {
+			filtered[object] = true
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,53 @@
+// Copyright 2021 Dalarub & Ettrich GmbH - All Rights Reserved
+// Unauthorized copying of this file, via any medium is strictly prohibited
+// Proprietary and confidential
+// info@dalarub.com
+//
+
+package backend
+
+import (
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/google/uuid"
+)
+
+// exportBatchSize is the number of rows fetched from the server-side cursor of an export at a time
+const exportBatchSize = 1000
+
+// csvValue formats a value of an exported object as a CSV cell. Strings are
+// written as they are, nested objects and arrays as json.
+func csvValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case *string:
+		return *v
+	case *uuid.UUID:
+		return v.String()
+	case *time.Time:
+		return v.UTC().Format(time.RFC3339Nano)
+	case time.Time:
+		return v.UTC().Format(time.RFC3339Nano)
+	case *propertyValue:
+		return csvValue(v.value)
+	}
+	data, _ := json.MarshalWithOption(value, json.DisableHTMLEscape())
+	return string(data)
+}
+
+// lookupPath returns the value of a nested property of object, or nil
+func lookupPath(object map[string]interface{}, path []string) interface{} {
+	var value interface{} = object
+	for _, key := range path {
+		nested, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = nested[key]
+	}
+	return value
+}
@@ -0,0 +1,123 @@
+// Copyright 2021 Dalarub & Ettrich GmbH - All Rights Reserved
+// Unauthorized copying of this file, via any medium is strictly prohibited
+// Proprietary and confidential
+// info@dalarub.com
+//
+
+package backend
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/goccy/go-json"
+)
+
+// externalIndexScopeParent makes an external index unique within the parent resource only
+const externalIndexScopeParent = "parent"
+
+// externalIndexConfiguration is a unique key of a resource made of one or several string properties.
+// In the configuration json it is either the name of a single property, or an object with properties
+// and scope, e.g. {"properties":["nickname"],"scope":"parent"}.
+type externalIndexConfiguration struct {
+	Properties []string `json:"properties"`
+	Scope      string   `json:"scope,omitempty"`
+}
+
+// UnmarshalJSON accepts a plain property name as well as an index object
+func (x *externalIndexConfiguration) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		*x = externalIndexConfiguration{Properties: []string{name}}
+		return nil
+	}
+	type plain externalIndexConfiguration
+	return json.Unmarshal(data, (*plain)(x))
+}
+
+// MarshalJSON writes global single property indices as plain names, like they are usually configured
+func (x externalIndexConfiguration) MarshalJSON() ([]byte, error) {
+	if len(x.Properties) == 1 && x.Scope == "" {
+		return json.Marshal(x.Properties[0])
+	}
+	type plain externalIndexConfiguration
+	return json.Marshal(plain(x))
+}
+
+// name returns the name of the index, which is part of the name of its SQL index
+func (x externalIndexConfiguration) name() string {
+	name := strings.Join(x.Properties, "_")
+	if x.Scope == externalIndexScopeParent {
+		name = "parent_" + name
+	}
+	return name
+}
+
+// route returns the path segments of the lookup route, e.g. serial_number:{serial_number}
+func (x externalIndexConfiguration) route() string {
+	segments := make([]string, len(x.Properties))
+	for i, property := range x.Properties {
+		segments[i] = property + ":{" + property + "}"
+	}
+	return strings.Join(segments, "/")
+}
+
+// indexColumns returns the columns of the unique SQL index. An index scoped to the parent starts with the
+// identifier of the parent.
+func (x externalIndexConfiguration) indexColumns(dependencies []string) []string {
+	var columns []string
+	if x.Scope == externalIndexScopeParent {
+		columns = append(columns, dependencies[len(dependencies)-1]+"_id")
+	}
+	return append(columns, x.Properties...)
+}
+
+// nonEmpty returns the SQL condition for all properties of the index being set
+func (x externalIndexConfiguration) nonEmpty() string {
+	conditions := make([]string, len(x.Properties))
+	for i, property := range x.Properties {
+		conditions[i] = property + " <> ''"
+	}
+	return strings.Join(conditions, " AND ")
+}
+
+// validate checks the index against the other columns of the resource, i.e. identifiers, static and
+// searchable properties, and against the existence of a parent
+func (x externalIndexConfiguration) validate(columns []string, hasParent bool) error {
+	if len(x.Properties) == 0 {
+		return fmt.Errorf("external index without properties")
+	}
+	switch x.Scope {
+	case "":
+	case externalIndexScopeParent:
+		if !hasParent {
+			return fmt.Errorf("external index %s is scoped to a parent, but there is none", x.name())
+		}
+	default:
+		return fmt.Errorf("unknown scope '%s' of external index %s", x.Scope, x.name())
+	}
+	for i, property := range x.Properties {
+		if property == "" || strings.ContainsAny(property, ":/{}") {
+			return fmt.Errorf("invalid property '%s' of external index %s", property, x.name())
+		}
+		for _, column := range columns {
+			if column == property {
+				return fmt.Errorf("external index property %s is already a column", property)
+			}
+		}
+		for _, p := range x.Properties[:i] {
+			if p == property {
+				return fmt.Errorf("duplicate property %s in external index %s", property, x.name())
+			}
+		}
+	}
+	return nil
+}
+
+// externalIndicesOf combines the legacy single external index with the list of external indices
+func externalIndicesOf(externalIndex string, externalIndices []externalIndexConfiguration) []externalIndexConfiguration {
+	if externalIndex == "" {
+		return externalIndices
+	}
+	return append([]externalIndexConfiguration{{Properties: []string{externalIndex}}}, externalIndices...)
+}
@@ -0,0 +1,65 @@
+// Copyright 2021 Dalarub & Ettrich GmbH - All Rights Reserved
+// Unauthorized copying of this file, via any medium is strictly prohibited
+// Proprietary and confidential
+// info@dalarub.com
+//
+
+package backend
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/relabs-tech/kurbisio/core/logger"
+)
+
+// housekeepingEvent is the event type of the periodic housekeeping of resources. The key
+// of the event is the resource.
+const housekeepingEvent = "_housekeeping_"
+
+// housekeepingInterval is the time between two housekeeping runs of a resource
+const housekeepingInterval = time.Hour
+
+// defaultSoftDeleteRetention is how long soft deleted items are kept if the configuration does not specify it
+const defaultSoftDeleteRetention = 30 * 24 * time.Hour
+
+// expireBatchSize is the maximum number of expired items deleted within one transaction
+const expireBatchSize = 1000
+
+// housekeepingTask is a periodic maintenance task of a resource, e.g. purging expired items
+type housekeepingTask func(ctx context.Context) error
+
+// addHousekeeping adds a housekeeping task to a resource. Must be called before handleHousekeeping.
+func (b *Backend) addHousekeeping(resource string, task housekeepingTask) {
+	b.housekeeping[resource] = append(b.housekeeping[resource], task)
+}
+
+// handleHousekeeping executes the housekeeping tasks of each resource through the job pipeline,
+// hence only one instance of the service works on a resource at any time.
+func (b *Backend) handleHousekeeping() {
+	if len(b.housekeeping) == 0 {
+		return
+	}
+	b.HandleEvent(housekeepingEvent, func(ctx context.Context, event Event) error {
+		for _, task := range b.housekeeping[event.Key] {
+			if err := task(ctx); err != nil {
+				return err
+			}
+		}
+		return b.ScheduleEvent(ctx, Event{Type: housekeepingEvent, Key: event.Key}, time.Now().Add(housekeepingInterval))
+	})
+
+	var resources []string
+	for resource := range b.housekeeping {
+		resources = append(resources, resource)
+	}
+	sort.Strings(resources)
+	for _, resource := range resources {
+		logger.Default().Debugln("  housekeeping for", resource)
+		err := b.ScheduleEventIfNotExist(context.Background(), Event{Type: housekeepingEvent, Key: resource}, time.Now())
+		if err != nil {
+			logger.Default().WithError(err).Errorln("cannot schedule housekeeping for", resource)
+		}
+	}
+}
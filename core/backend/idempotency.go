@@ -0,0 +1,154 @@
+// Copyright 2021 Dalarub & Ettrich GmbH - All Rights Reserved
+// Unauthorized copying of this file, via any medium is strictly prohibited
+// Proprietary and confidential
+// info@dalarub.com
+//
+
+package backend
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/goccy/go-json"
+
+	"github.com/relabs-tech/kurbisio/core/access"
+	"github.com/relabs-tech/kurbisio/core/csql"
+	"github.com/relabs-tech/kurbisio/core/logger"
+)
+
+// idempotencyKeyHeader is the request header which makes a POST request idempotent
+const idempotencyKeyHeader = "Idempotency-Key"
+
+// defaultIdempotencyWindow is how long responses are kept if the builder does not specify it
+const defaultIdempotencyWindow = 24 * time.Hour
+
+// idempotencyClaimLease is how long a claimed key without response blocks retries. After the lease, the
+// claim is considered abandoned, for example because the server crashed, and the key can be claimed again.
+const idempotencyClaimLease = 5 * time.Minute
+
+func (b *Backend) handleIdempotency() {
+	if b.updateSchema {
+		err := b.execSchema(`CREATE table IF NOT EXISTS ` + b.db.Schema + `."_idempotency_"
+(identity VARCHAR NOT NULL,
+key VARCHAR NOT NULL,
+request VARCHAR NOT NULL,
+status INTEGER NOT NULL DEFAULT 0,
+content_type VARCHAR NOT NULL DEFAULT '',
+response BYTEA,
+timestamp TIMESTAMP NOT NULL,
+PRIMARY KEY(identity,key)
+);
+CREATE index IF NOT EXISTS idempotency_timestamp_index ON ` + b.db.Schema + `._idempotency_(timestamp);
+`)
+		if err != nil {
+			panic(err)
+		}
+	}
+}
+
+// idempotent executes handler at most once per idempotency key and identity within the idempotency
+// window. A retry of the same request replays the stored response, a retry with a different request
+// fails with http.StatusUnprocessableEntity. Requests without idempotency key are passed through.
+func (b *Backend) idempotent(w http.ResponseWriter, r *http.Request, handler http.HandlerFunc) {
+	key := r.Header.Get(idempotencyKeyHeader)
+	if key == "" {
+		handler(w, r)
+		return
+	}
+	rlog := logger.FromContext(r.Context())
+	if len(key) > 255 {
+		http.Error(w, idempotencyKeyHeader+" exceeds 255 characters", http.StatusBadRequest)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	// the identity is the authorization of the requester, the request is the method, the url and the body
+	authorization, _ := json.Marshal(access.AuthorizationFromContext(r.Context()))
+	identity := fmt.Sprintf("%x", sha1.Sum(authorization))
+	request := fmt.Sprintf("%x", sha1.Sum(append([]byte(r.Method+" "+r.URL.String()+"\n"), body...)))
+	now := time.Now().UTC()
+	expired := now.Add(-b.idempotencyWindow)
+
+	// claim the key, unless somebody else has claimed it within the window or still holds the claim lease
+	var claimed bool
+	err = b.db.QueryRow(`INSERT INTO `+b.db.Schema+`."_idempotency_" (identity,key,request,timestamp) VALUES($1,$2,$3,$4)
+ON CONFLICT (identity,key) DO UPDATE SET request=$3,status=0,content_type='',response=NULL,timestamp=$4
+WHERE _idempotency_.timestamp < $5 OR (_idempotency_.status=0 AND _idempotency_.timestamp < $6) RETURNING true;`,
+		identity, key, request, now, expired, now.Add(-idempotencyClaimLease)).Scan(&claimed)
+	if err == csql.ErrNoRows {
+		var (
+			storedRequest, contentType string
+			status                     int
+			response                   []byte
+		)
+		err = b.db.QueryRow(`SELECT request,status,content_type,response FROM `+b.db.Schema+`."_idempotency_"
+WHERE identity=$1 AND key=$2;`, identity, key).Scan(&storedRequest, &status, &contentType, &response)
+		if err != nil {
+			rlog.WithError(err).Errorf("Error 4813: read idempotency key")
+			http.Error(w, "Error 4813", http.StatusInternalServerError)
+			return
+		}
+		if storedRequest != request {
+			http.Error(w, idempotencyKeyHeader+" was used for a different request", http.StatusUnprocessableEntity)
+			return
+		}
+		if status == 0 {
+			http.Error(w, "a request with this "+idempotencyKeyHeader+" is still in progress", http.StatusConflict)
+			return
+		}
+		if contentType != "" {
+			w.Header().Set("Content-Type", contentType)
+		}
+		w.Header().Set("Idempotent-Replayed", "true")
+		w.WriteHeader(status)
+		w.Write(response)
+		return
+	}
+	if err != nil {
+		rlog.WithError(err).Errorf("Error 4813: claim idempotency key")
+		http.Error(w, "Error 4813", http.StatusInternalServerError)
+		return
+	}
+
+	// release the claim if the handler panics, so that the client can retry
+	completed := false
+	defer func() {
+		if !completed {
+			b.db.Exec(`DELETE FROM `+b.db.Schema+`."_idempotency_" WHERE identity=$1 AND key=$2 AND status=0;`, identity, key)
+		}
+	}()
+	rec := httptest.NewRecorder()
+	handler(rec, r)
+	completed = true
+	for k, v := range rec.Header() {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(rec.Code)
+	w.Write(rec.Body.Bytes())
+
+	if rec.Code >= http.StatusInternalServerError {
+		// server errors are not final, the client shall be able to retry
+		_, err = b.db.Exec(`DELETE FROM `+b.db.Schema+`."_idempotency_" WHERE identity=$1 AND key=$2;`, identity, key)
+	} else {
+		_, err = b.db.Exec(`UPDATE `+b.db.Schema+`."_idempotency_" SET status=$3,content_type=$4,response=$5
+WHERE identity=$1 AND key=$2;`, identity, key, rec.Code, rec.Header().Get("Content-Type"), rec.Body.Bytes())
+	}
+	if err == nil {
+		_, err = b.db.Exec(`DELETE FROM `+b.db.Schema+`."_idempotency_" WHERE timestamp < $1;`, expired)
+	}
+	if err != nil {
+		rlog.WithError(err).Errorf("Error 4814: store idempotent response")
+	}
+}
@@ -87,7 +87,7 @@ func (j *job) event() (Event, context.Context) {
 
 func (b *Backend) handleJobs(router *mux.Router) {
 	if b.updateSchema {
-		_, err := b.db.Exec(`CREATE table IF NOT EXISTS ` + b.db.Schema + `."_job_" 
+		err := b.execSchema(`CREATE table IF NOT EXISTS ` + b.db.Schema + `."_job_" 
 (serial SERIAL,
 job VARCHAR NOT NULL,
 type VARCHAR NOT NULL DEFAULT '',
@@ -111,7 +111,7 @@ CREATE index IF NOT EXISTS jobs_scheduled_at_index ON ` + b.db.Schema + `._job_(
 		if err != nil {
 			panic(err)
 		}
-		_, err = b.db.Exec(`CREATE table IF NOT EXISTS ` + b.db.Schema + `."_schedule_" 
+		err = b.execSchema(`CREATE table IF NOT EXISTS ` + b.db.Schema + `."_schedule_" 
 (serial SERIAL,
 event VARCHAR NOT NULL DEFAULT '',
 scheduled_at TIMESTAMP,
@@ -917,7 +917,7 @@ func taskJobKey(event string) string {
 	return "task: " + event
 }
 
-func (b *Backend) commitWithNotification(ctx context.Context, tx *sql.Tx, resource string, operation core.Operation, resourceID uuid.UUID, payload []byte) error {
+func (b *Backend) commitWithNotification(ctx context.Context, tx transaction, resource string, operation core.Operation, resourceID uuid.UUID, payload []byte) error {
 	rlog := logger.FromContext(ctx)
 	rlog.Debugf("commitWithNotification START")
 	request := notificationJobKey(resource, operation)
@@ -927,15 +927,39 @@ func (b *Backend) commitWithNotification(ctx context.Context, tx *sql.Tx, resour
 		return tx.Commit()
 	}
 
+	rlog.Debugf("commitWithNotification before: addNotification")
+	err := b.addNotification(ctx, tx, resource, operation, resourceID, payload)
+	if err != nil {
+		rlog.Debugf("commitWithNotification before: tx.Rollback()")
+		tx.Rollback()
+		return err
+	}
+	rlog.Debugf("commitWithNotification before: err = tx.Commit()")
+	err = tx.Commit()
+	rlog.Debugf("commitWithNotification after: err = tx.Commit()")
+	if err == nil {
+		b.TriggerJobs()
+		rlog.Debugf("commitWithNotification after: b.TriggerJobs()")
+	}
+	rlog.Debugf("commitWithNotification END")
+	return err
+}
+
+// addNotification adds a notification to tx, if somebody requested it. The notification is processed
+// once tx is committed. Use it for transactions with many notifications, otherwise commitWithNotification.
+func (b *Backend) addNotification(ctx context.Context, tx transaction, resource string, operation core.Operation, resourceID uuid.UUID, payload []byte) error {
+	if _, ok := b.callbacks[notificationJobKey(resource, operation)]; !ok {
+		return nil
+	}
+
 	if len(payload) == 0 {
 		payload = []byte("{}")
 	}
 
 	contextData := logger.SerializeLoggerContext(ctx)
 
-	rlog.Debugf("commitWithNotification before: tx.QueryRow")
 	var serial int
-	err := tx.QueryRow("INSERT INTO "+b.db.Schema+".\"_job_\""+
+	return tx.QueryRow("INSERT INTO "+b.db.Schema+".\"_job_\""+
 		"(job,type,resource,resource_id,payload,timestamp,attempts_left,context)"+
 		"VALUES('notification',$1,$2,$3,$4,$5,4,$6) RETURNING serial;",
 		operation,
@@ -945,19 +969,4 @@ func (b *Backend) commitWithNotification(ctx context.Context, tx *sql.Tx, resour
 		time.Now().UTC(),
 		contextData,
 	).Scan(&serial)
-
-	if err != nil {
-		rlog.Debugf("commitWithNotification before: tx.Rollback()")
-		tx.Rollback()
-		return err
-	}
-	rlog.Debugf("commitWithNotification before: err = tx.Commit()")
-	err = tx.Commit()
-	rlog.Debugf("commitWithNotification after: err = tx.Commit()")
-	if err == nil {
-		b.TriggerJobs()
-		rlog.Debugf("commitWithNotification after: b.TriggerJobs()")
-	}
-	rlog.Debugf("commitWithNotification END")
-	return err
 }
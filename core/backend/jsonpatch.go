@@ -0,0 +1,318 @@
+// Copyright 2021 Dalarub & Ettrich GmbH - All Rights Reserved
+// Unauthorized copying of this file, via any medium is strictly prohibited
+// Proprietary and confidential
+// info@dalarub.com
+//
+
+package backend
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/goccy/go-json"
+)
+
+// content types of PATCH requests, besides the default application/json
+const (
+	jsonPatchContentType  = "application/json-patch+json"  // RFC 6902
+	mergePatchContentType = "application/merge-patch+json" // RFC 7396
+)
+
+// jsonPatchOperation is a single operation of a json patch document (RFC 6902)
+type jsonPatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// applyJSONPatch applies the operations of a json patch document to object. If an operation fails,
+// the returned status is http.StatusConflict for a failed test, and http.StatusUnprocessableEntity
+// otherwise.
+func applyJSONPatch(object map[string]interface{}, operations []jsonPatchOperation) (map[string]interface{}, int, error) {
+	var document interface{} = object
+	for i, operation := range operations {
+		var err error
+		document, err = applyJSONPatchOperation(document, operation)
+		if err == errJSONPatchTestFailed {
+			return nil, http.StatusConflict, fmt.Errorf("operation %d: %s", i, err.Error())
+		}
+		if err != nil {
+			return nil, http.StatusUnprocessableEntity, fmt.Errorf("operation %d: %s", i, err.Error())
+		}
+	}
+	result, ok := document.(map[string]interface{})
+	if !ok {
+		return nil, http.StatusUnprocessableEntity, fmt.Errorf("patched document is not an object")
+	}
+	return result, http.StatusOK, nil
+}
+
+var errJSONPatchTestFailed = fmt.Errorf("test failed")
+
+func applyJSONPatchOperation(document interface{}, operation jsonPatchOperation) (interface{}, error) {
+	path, err := parseJSONPointer(operation.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	var value interface{}
+	switch operation.Op {
+	case "add", "replace", "test":
+		if len(operation.Value) == 0 {
+			return nil, fmt.Errorf("missing value for %s", operation.Op)
+		}
+		if err = json.Unmarshal(operation.Value, &value); err != nil {
+			return nil, err
+		}
+	case "move", "copy":
+		from, err := parseJSONPointer(operation.From)
+		if err != nil {
+			return nil, err
+		}
+		if value, err = lookupJSONPointer(document, from); err != nil {
+			return nil, err
+		}
+		if operation.Op == "copy" {
+			// the copy must not share nested objects with the original
+			data, _ := json.Marshal(value)
+			json.Unmarshal(data, &value)
+		} else {
+			if strings.HasPrefix(operation.Path+"/", operation.From+"/") && operation.Path != operation.From {
+				return nil, fmt.Errorf("cannot move %s into itself", operation.From)
+			}
+			if document, err = removeJSONPointer(document, from); err != nil {
+				return nil, err
+			}
+		}
+	case "remove":
+	default:
+		return nil, fmt.Errorf("unknown op '%s'", operation.Op)
+	}
+
+	switch operation.Op {
+	case "add", "move", "copy":
+		return addJSONPointer(document, path, value)
+	case "remove":
+		return removeJSONPointer(document, path)
+	case "replace":
+		if _, err = lookupJSONPointer(document, path); err != nil {
+			return nil, err
+		}
+		if len(path) == 0 {
+			return value, nil
+		}
+		if document, err = removeJSONPointer(document, path); err != nil {
+			return nil, err
+		}
+		return addJSONPointer(document, path, value)
+	default: // test
+		current, err := lookupJSONPointer(document, path)
+		if err != nil {
+			return nil, err
+		}
+		if !reflect.DeepEqual(current, value) {
+			return nil, errJSONPatchTestFailed
+		}
+		return document, nil
+	}
+}
+
+// parseJSONPointer splits a json pointer (RFC 6901) into its reference tokens
+func parseJSONPointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("invalid path '%s'", pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+// jsonArrayIndex returns the array index of a reference token, or -1 if the token is no valid index
+func jsonArrayIndex(token string, length int) int {
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || i >= length || strconv.Itoa(i) != token {
+		return -1
+	}
+	return i
+}
+
+func lookupJSONPointer(document interface{}, path []string) (interface{}, error) {
+	for _, token := range path {
+		switch node := document.(type) {
+		case map[string]interface{}:
+			value, ok := node[token]
+			if !ok {
+				return nil, fmt.Errorf("no such property '%s'", token)
+			}
+			document = value
+		case []interface{}:
+			i := jsonArrayIndex(token, len(node))
+			if i < 0 {
+				return nil, fmt.Errorf("invalid array index '%s'", token)
+			}
+			document = node[i]
+		default:
+			return nil, fmt.Errorf("cannot resolve '%s'", token)
+		}
+	}
+	return document, nil
+}
+
+// modifyJSONPointer calls modify for the parent of the last token of path, and returns the
+// document with the modified parent. Arrays may change their length, hence parents are
+// updated all the way up.
+func modifyJSONPointer(document interface{}, path []string, modify func(parent interface{}, token string) (interface{}, error)) (interface{}, error) {
+	if len(path) == 1 {
+		return modify(document, path[0])
+	}
+	child, err := lookupJSONPointer(document, path[:1])
+	if err != nil {
+		return nil, err
+	}
+	if child, err = modifyJSONPointer(child, path[1:], modify); err != nil {
+		return nil, err
+	}
+	switch node := document.(type) {
+	case map[string]interface{}:
+		node[path[0]] = child
+	case []interface{}:
+		node[jsonArrayIndex(path[0], len(node))] = child
+	}
+	return document, nil
+}
+
+func addJSONPointer(document interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	return modifyJSONPointer(document, path, func(parent interface{}, token string) (interface{}, error) {
+		switch node := parent.(type) {
+		case map[string]interface{}:
+			node[token] = value
+			return node, nil
+		case []interface{}:
+			if token == "-" {
+				return append(node, value), nil
+			}
+			i := jsonArrayIndex(token, len(node)+1)
+			if i < 0 {
+				return nil, fmt.Errorf("invalid array index '%s'", token)
+			}
+			node = append(node, nil)
+			copy(node[i+1:], node[i:])
+			node[i] = value
+			return node, nil
+		}
+		return nil, fmt.Errorf("cannot add '%s'", token)
+	})
+}
+
+func removeJSONPointer(document interface{}, path []string) (interface{}, error) {
+	if len(path) == 0 {
+		return nil, fmt.Errorf("cannot remove the entire document")
+	}
+	return modifyJSONPointer(document, path, func(parent interface{}, token string) (interface{}, error) {
+		switch node := parent.(type) {
+		case map[string]interface{}:
+			if _, ok := node[token]; !ok {
+				return nil, fmt.Errorf("no such property '%s'", token)
+			}
+			delete(node, token)
+			return node, nil
+		case []interface{}:
+			i := jsonArrayIndex(token, len(node))
+			if i < 0 {
+				return nil, fmt.Errorf("invalid array index '%s'", token)
+			}
+			return append(node[:i], node[i+1:]...), nil
+		}
+		return nil, fmt.Errorf("cannot remove '%s'", token)
+	})
+}
+
+// diffJSON returns the json patch which turns the json document from into the json document to.
+// Objects are compared property by property, arrays of the same length element by element,
+// everything else is replaced as a whole.
+func diffJSON(from, to interface{}) []jsonPatchOperation {
+	operations := []jsonPatchOperation{}
+	diffJSONPointer(from, to, "", &operations)
+	return operations
+}
+
+func diffJSONPointer(from, to interface{}, path string, operations *[]jsonPatchOperation) {
+	if reflect.DeepEqual(from, to) {
+		return
+	}
+	fromObject, fromOK := from.(map[string]interface{})
+	toObject, toOK := to.(map[string]interface{})
+	if fromOK && toOK {
+		var keys []string
+		for key := range fromObject {
+			keys = append(keys, key)
+		}
+		for key := range toObject {
+			if _, ok := fromObject[key]; !ok {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			child := path + "/" + strings.ReplaceAll(strings.ReplaceAll(key, "~", "~0"), "/", "~1")
+			fromValue, inFrom := fromObject[key]
+			toValue, inTo := toObject[key]
+			switch {
+			case !inTo:
+				*operations = append(*operations, jsonPatchOperation{Op: "remove", Path: child})
+			case !inFrom:
+				value, _ := json.Marshal(toValue)
+				*operations = append(*operations, jsonPatchOperation{Op: "add", Path: child, Value: value})
+			default:
+				diffJSONPointer(fromValue, toValue, child, operations)
+			}
+		}
+		return
+	}
+	fromArray, fromOK := from.([]interface{})
+	toArray, toOK := to.([]interface{})
+	if fromOK && toOK && len(fromArray) == len(toArray) {
+		for i := range fromArray {
+			diffJSONPointer(fromArray[i], toArray[i], path+"/"+strconv.Itoa(i), operations)
+		}
+		return
+	}
+	value, _ := json.Marshal(to)
+	*operations = append(*operations, jsonPatchOperation{Op: "replace", Path: path, Value: value})
+}
+
+// mergePatch applies a json merge patch (RFC 7396) to object. Unlike patchObject, null
+// removes a property.
+func mergePatch(object map[string]interface{}, patch map[string]interface{}) {
+	for k, v := range patch {
+		if v == nil {
+			delete(object, k)
+			continue
+		}
+		pc, pcok := v.(map[string]interface{})
+		if !pcok {
+			object[k] = v
+			continue
+		}
+		oc, ocok := object[k].(map[string]interface{})
+		if !ocok {
+			oc = map[string]interface{}{}
+			object[k] = oc
+		}
+		mergePatch(oc, pc)
+	}
+}
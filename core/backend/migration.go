@@ -0,0 +1,386 @@
+// Copyright 2021 Dalarub & Ettrich GmbH - All Rights Reserved
+// Unauthorized copying of this file, via any medium is strictly prohibited
+// Proprietary and confidential
+// info@dalarub.com
+//
+
+package backend
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/goccy/go-json"
+
+	"github.com/relabs-tech/kurbisio/core/logger"
+)
+
+// migrationConfiguration is a versioned list of migration steps. Migrations run in the order of their
+// versions, and each version runs only once per database.
+type migrationConfiguration struct {
+	Version     int             `json:"version"`
+	Description string          `json:"description"`
+	Steps       []migrationStep `json:"steps"`
+}
+
+// migrationStep is a single step of a migration. Which fields are required depends on the operation:
+//
+//	rename_resource  resource, to
+//	drop_resource    resource
+//	rename_property  resource, property, to
+//	drop_property    resource, property
+//	retype_property  resource, property, type
+//	backfill         resource, property, and either from or value
+type migrationStep struct {
+	Op       string          `json:"op"`
+	Resource string          `json:"resource"`
+	Property string          `json:"property,omitempty"`
+	To       string          `json:"to,omitempty"`
+	Type     string          `json:"type,omitempty"`
+	From     string          `json:"from,omitempty"`
+	Value    json.RawMessage `json:"value,omitempty"`
+}
+
+// the phases of a migration. Schema steps run before the resources are created, data steps run
+// afterwards, because they need the columns of the new configuration.
+const (
+	migrationPhaseSchema = iota
+	migrationPhaseData
+)
+
+// migrationRegistryPrefix is the registry prefix of applied migrations. The key is the version.
+const migrationRegistryPrefix = "_migrations_"
+
+// phase returns the phase in which the step runs
+func (s migrationStep) phase() int {
+	if s.Op == "backfill" {
+		return migrationPhaseData
+	}
+	return migrationPhaseSchema
+}
+
+// pendingMigrations returns the migrations of the configuration which have not been applied yet
+func (b *Backend) pendingMigrations() []migrationConfiguration {
+	if !b.updateSchema {
+		return nil
+	}
+	registry := b.Registry.Accessor(migrationRegistryPrefix)
+	var pending []migrationConfiguration
+	for i, migration := range b.config.Migrations {
+		if i > 0 && migration.Version <= b.config.Migrations[i-1].Version {
+			logger.Default().Errorf("migration %d: versions must be increasing", migration.Version)
+			panic("invalid configuration")
+		}
+		for _, step := range migration.Steps {
+			if err := step.validate(); err != nil {
+				logger.Default().Errorf("migration %d: %v", migration.Version, err)
+				panic("invalid configuration")
+			}
+		}
+		timestamp, err := registry.Read(strconv.Itoa(migration.Version), nil)
+		if err != nil {
+			panic(err)
+		}
+		if timestamp.IsZero() {
+			pending = append(pending, migration)
+		}
+	}
+	return pending
+}
+
+// validate checks that the step has all fields its operation requires
+func (s migrationStep) validate() error {
+	if s.Resource == "" {
+		return fmt.Errorf("%s without resource", s.Op)
+	}
+	switch s.Op {
+	case "rename_resource":
+		i := strings.LastIndex(s.Resource, "/")
+		if s.To == "" || s.To == s.Resource || i != strings.LastIndex(s.To, "/") || s.Resource[:i+1] != s.To[:i+1] {
+			return fmt.Errorf("rename_resource from %s to '%s': only the last segment of a resource can be renamed", s.Resource, s.To)
+		}
+	case "drop_resource":
+	case "rename_property":
+		if s.Property == "" || s.To == "" {
+			return fmt.Errorf("rename_property of %s needs property and to", s.Resource)
+		}
+	case "drop_property":
+		if s.Property == "" {
+			return fmt.Errorf("drop_property of %s needs property", s.Resource)
+		}
+	case "retype_property":
+		if _, ok := propertySQLTypes[s.Type]; !ok || s.Property == "" {
+			return fmt.Errorf("retype_property of %s needs property and a valid type", s.Resource)
+		}
+	case "backfill":
+		if s.Property == "" || (s.From == "") == (len(s.Value) == 0) {
+			return fmt.Errorf("backfill of %s needs property, and either from or value", s.Resource)
+		}
+	default:
+		return fmt.Errorf("unknown op '%s'", s.Op)
+	}
+	return nil
+}
+
+// migrate runs the steps of the pending migrations which belong to phase, each migration in its own
+// transaction. After the data phase, the migrations are recorded as applied. All steps can be repeated
+// safely, in case a migration fails halfway between the two phases.
+func (b *Backend) migrate(pending []migrationConfiguration, phase int) {
+	registry := b.Registry.Accessor(migrationRegistryPrefix)
+	for _, migration := range pending {
+		if phase == migrationPhaseSchema {
+			logger.Default().Infof("migrate database to version %d: %s", migration.Version, migration.Description)
+		}
+		tx, err := b.db.Begin()
+		if err != nil {
+			panic(err)
+		}
+		for i, step := range migration.Steps {
+			if step.phase() != phase {
+				continue
+			}
+			if err = b.migrateStep(tx, step); err != nil {
+				tx.Rollback()
+				logger.Default().WithError(err).Errorf("migration %d, step %d (%s %s) failed", migration.Version, i, step.Op, step.Resource)
+				panic(fmt.Sprintf("migration %d failed: %v", migration.Version, err))
+			}
+		}
+		if err = tx.Commit(); err != nil {
+			panic(err)
+		}
+		if phase == migrationPhaseData {
+			if err = registry.Write(strconv.Itoa(migration.Version), migration); err != nil {
+				panic(err)
+			}
+		}
+	}
+}
+
+func (b *Backend) migrateStep(tx *sql.Tx, step migrationStep) error {
+	schema := b.db.Schema
+	switch step.Op {
+	case "rename_resource":
+		// the resource, its log and all its children are renamed, together with the identifier column
+		oldThis := step.Resource[strings.LastIndex(step.Resource, "/")+1:]
+		newThis := step.To[strings.LastIndex(step.To, "/")+1:]
+		tables, err := migrationTables(tx, schema, step.Resource, true)
+		if err != nil || len(tables) == 0 {
+			return err
+		}
+		if exists, err := migrationTables(tx, schema, step.To, false); err != nil || len(exists) > 0 {
+			if err == nil {
+				err = fmt.Errorf("resource %s already exists", step.To)
+			}
+			return err
+		}
+		for _, table := range tables {
+			if err = migrationRenameColumn(tx, schema, table, oldThis+"_id", newThis+"_id"); err != nil {
+				return err
+			}
+			if err = migrationRenameIndices(tx, schema, table, oldThis, newThis); err != nil {
+				return err
+			}
+			_, err = tx.Exec(fmt.Sprintf("ALTER TABLE %s.\"%s\" RENAME TO \"%s\";", schema, table, step.To+strings.TrimPrefix(table, step.Resource)))
+			if err != nil {
+				return err
+			}
+		}
+	case "drop_resource":
+		tables, err := migrationTables(tx, schema, step.Resource, true)
+		if err != nil {
+			return err
+		}
+		for _, table := range tables {
+			if _, err = tx.Exec(fmt.Sprintf("DROP TABLE IF EXISTS %s.\"%s\" CASCADE;", schema, table)); err != nil {
+				return err
+			}
+		}
+	case "rename_property":
+		for _, table := range []string{step.Resource, step.Resource + "/log"} {
+			if err := migrationRenameColumn(tx, schema, table, step.Property, step.To); err != nil {
+				return err
+			}
+		}
+	case "drop_property":
+		for _, table := range []string{step.Resource, step.Resource + "/log"} {
+			if _, err := tx.Exec(fmt.Sprintf("ALTER TABLE IF EXISTS %s.\"%s\" DROP COLUMN IF EXISTS \"%s\" CASCADE;", schema, table, step.Property)); err != nil {
+				return err
+			}
+		}
+	case "retype_property":
+		// typed properties are null when they are missing, string properties are empty
+		column := "\"" + step.Property + "\""
+		var alter string
+		if property := (propertyConfiguration{Name: step.Property, Type: step.Type}); property.typed() {
+			sqlType := propertySQLTypes[step.Type]
+			alter = fmt.Sprintf("ALTER COLUMN %s DROP DEFAULT, ALTER COLUMN %s DROP NOT NULL, ALTER COLUMN %s TYPE %s USING NULLIF(%s::text,'')::%s",
+				column, column, column, sqlType, column, sqlType)
+		} else {
+			alter = fmt.Sprintf("ALTER COLUMN %s TYPE varchar USING coalesce(%s::text,''), ALTER COLUMN %s SET DEFAULT '', ALTER COLUMN %s SET NOT NULL",
+				column, column, column, column)
+		}
+		for _, table := range []string{step.Resource, step.Resource + "/log"} {
+			exists, err := migrationColumnExists(tx, schema, table, step.Property)
+			if err != nil {
+				return err
+			}
+			if !exists {
+				continue
+			}
+			if _, err = tx.Exec(fmt.Sprintf("ALTER TABLE %s.\"%s\" %s;", schema, table, alter)); err != nil {
+				return err
+			}
+		}
+	case "backfill":
+		// only items without a value are filled, either from a first level property of the json document or
+		// with a constant value
+		propertyType := b.propertyType(step.Resource, step.Property)
+		column := "\"" + step.Property + "\""
+		empty := column + "=''"
+		if propertyType != "" {
+			empty = column + " IS NULL"
+		}
+		var queryParameters []interface{}
+		var value string
+		if step.From != "" {
+			queryParameters = append(queryParameters, step.From)
+			switch propertyType {
+			case "":
+				value = "coalesce(properties->>$1,'')"
+			case "timestamp":
+				value = "(properties->>$1)::timestamptz AT TIME ZONE 'UTC'"
+			default:
+				value = "(properties->>$1)::" + propertySQLTypes[propertyType]
+			}
+		} else {
+			var v interface{}
+			if err := json.Unmarshal(step.Value, &v); err != nil {
+				return err
+			}
+			if propertyType != "" {
+				var err error
+				if v, err = propertyToSQL(propertyType, step.Property, v); err != nil {
+					return err
+				}
+			} else if _, ok := v.(string); !ok {
+				return fmt.Errorf("property %s must be of type string", step.Property)
+			}
+			queryParameters = append(queryParameters, v)
+			value = "$1"
+			if propertyType != "" {
+				value += "::" + propertySQLTypes[propertyType]
+			}
+		}
+		_, err := tx.Exec(fmt.Sprintf("UPDATE %s.\"%s\" SET %s=%s WHERE %s;", schema, step.Resource, column, value, empty), queryParameters...)
+		return err
+	}
+	return nil
+}
+
+// propertyType returns the type of a typed static or searchable property of a collection or singleton,
+// or an empty string for string properties
+func (b *Backend) propertyType(resource, name string) string {
+	var properties []propertyConfiguration
+	for _, rc := range b.config.Collections {
+		if rc.Resource == resource {
+			properties = append(properties, rc.StaticProperties...)
+			properties = append(properties, rc.SearchableProperties...)
+		}
+	}
+	for _, rc := range b.config.Singletons {
+		if rc.Resource == resource {
+			properties = append(properties, rc.StaticProperties...)
+			properties = append(properties, rc.SearchableProperties...)
+		}
+	}
+	for _, property := range properties {
+		if property.Name == name && property.typed() {
+			return property.Type
+		}
+	}
+	return ""
+}
+
+// migrationTables returns the existing tables of resource, i.e. the resource itself and its log. With
+// children, it also returns the tables of all child resources. The schema is created unquoted, hence
+// the catalog knows it in lower case.
+func migrationTables(tx *sql.Tx, schema, resource string, children bool) ([]string, error) {
+	query := "SELECT table_name FROM information_schema.tables WHERE table_schema=$1 AND (table_name=$2 OR table_name=$3"
+	if children {
+		query += " OR left(table_name, length($2)+1)=$2 || '/'"
+	}
+	query += ") ORDER BY table_name;"
+	rows, err := tx.Query(query, strings.ToLower(schema), resource, resource+"/log")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var tables []string
+	for rows.Next() {
+		var table string
+		if err = rows.Scan(&table); err != nil {
+			return nil, err
+		}
+		tables = append(tables, table)
+	}
+	return tables, rows.Err()
+}
+
+func migrationColumnExists(tx *sql.Tx, schema, table, column string) (bool, error) {
+	var exists bool
+	err := tx.QueryRow("SELECT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_schema=$1 AND table_name=$2 AND column_name=$3);",
+		strings.ToLower(schema), table, column).Scan(&exists)
+	return exists, err
+}
+
+// migrationRenameColumn renames a column if the table has it
+func migrationRenameColumn(tx *sql.Tx, schema, table, from, to string) error {
+	exists, err := migrationColumnExists(tx, schema, table, from)
+	if err != nil || !exists {
+		return err
+	}
+	_, err = tx.Exec(fmt.Sprintf("ALTER TABLE %s.\"%s\" RENAME COLUMN \"%s\" TO \"%s\";", schema, table, from, to))
+	return err
+}
+
+// migrationIndexPattern matches the names of the indices which a resource creates for its table and its log.
+// The names contain the last segment of the resource, see createCollectionResource and createBlobResource.
+const migrationIndexPattern = `^(sort_index|searchable_property|external_index|full_text|soft_delete)_%s(_|$)`
+
+// migrationRenameIndices renames the indices of table which were named after the resource from, so that
+// the next schema update finds them instead of creating duplicates
+func migrationRenameIndices(tx *sql.Tx, schema, table, from, to string) error {
+	rows, err := tx.Query("SELECT indexname FROM pg_indexes WHERE schemaname=$1 AND tablename=$2;", strings.ToLower(schema), table)
+	if err != nil {
+		return err
+	}
+	var indices []string
+	for rows.Next() {
+		var index string
+		if err = rows.Scan(&index); err != nil {
+			rows.Close()
+			return err
+		}
+		indices = append(indices, index)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	// index names are created unquoted, hence they are lower case
+	pattern := regexp.MustCompile(fmt.Sprintf(migrationIndexPattern, regexp.QuoteMeta(strings.ToLower(from))))
+	for _, index := range indices {
+		match := pattern.FindStringSubmatchIndex(index)
+		if match == nil {
+			continue
+		}
+		renamed := index[:match[3]] + "_" + strings.ToLower(to) + index[match[4]:]
+		if _, err = tx.Exec(fmt.Sprintf("ALTER INDEX %s.\"%s\" RENAME TO \"%s\";", schema, index, renamed)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
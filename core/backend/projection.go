@@ -0,0 +1,87 @@
+// Copyright 2021 Dalarub & Ettrich GmbH - All Rights Reserved
+// Unauthorized copying of this file, via any medium is strictly prohibited
+// Proprietary and confidential
+// info@dalarub.com
+//
+
+package backend
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/goccy/go-json"
+)
+
+// parseFields parses the fields query parameter, a comma separated list of properties.
+// Dots in a property select keys of nested objects, e.g. "settings.locale".
+func parseFields(value string) ([][]string, error) {
+	var fields [][]string
+	for _, field := range strings.Split(value, ",") {
+		path := strings.Split(strings.TrimSpace(field), ".")
+		for _, key := range path {
+			if key == "" {
+				return nil, fmt.Errorf("invalid field '%s'", field)
+			}
+		}
+		fields = append(fields, path)
+	}
+	return fields, nil
+}
+
+// projectJSON reduces a json object, or an array of json objects, to the selected fields.
+// Properties in keep are always retained.
+func projectJSON(data []byte, fields [][]string, keep map[string]bool) ([]byte, error) {
+	var value interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	switch v := value.(type) {
+	case []interface{}:
+		for i, item := range v {
+			if object, ok := item.(map[string]interface{}); ok {
+				v[i] = projectObject(object, fields, keep)
+			}
+		}
+	case map[string]interface{}:
+		value = projectObject(v, fields, keep)
+	}
+	return json.MarshalWithOption(value, json.DisableHTMLEscape())
+}
+
+func projectObject(object map[string]interface{}, fields [][]string, keep map[string]bool) map[string]interface{} {
+	result := map[string]interface{}{}
+	for key := range keep {
+		if value, ok := object[key]; ok {
+			result[key] = value
+		}
+	}
+	for _, path := range fields {
+		projectPath(object, result, path)
+	}
+	return result
+}
+
+func projectPath(source, target map[string]interface{}, path []string) {
+	value, ok := source[path[0]]
+	if !ok {
+		return
+	}
+	if len(path) == 1 {
+		target[path[0]] = value
+		return
+	}
+	nestedSource, ok := value.(map[string]interface{})
+	if !ok {
+		return
+	}
+	nestedTarget, ok := target[path[0]].(map[string]interface{})
+	if !ok {
+		nestedTarget = map[string]interface{}{}
+		target[path[0]] = nestedTarget
+	}
+	projectPath(nestedSource, nestedTarget, path[1:])
+}
@@ -0,0 +1,175 @@
+// Copyright 2021 Dalarub & Ettrich GmbH - All Rights Reserved
+// Unauthorized copying of this file, via any medium is strictly prohibited
+// Proprietary and confidential
+// info@dalarub.com
+//
+
+package backend
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/goccy/go-json"
+)
+
+// propertySQLTypes maps the types of static and searchable properties to their SQL column types
+var propertySQLTypes = map[string]string{
+	"string":    "varchar",
+	"integer":   "bigint",
+	"numeric":   "numeric",
+	"boolean":   "boolean",
+	"timestamp": "timestamp",
+}
+
+// propertyConfiguration is a static or searchable property. In the configuration json it is either the
+// name of a string property, or an object with name and type, e.g. {"name":"battery","type":"integer"}.
+type propertyConfiguration struct {
+	Name string `json:"name"`
+	Type string `json:"type,omitempty"`
+}
+
+// UnmarshalJSON accepts a plain property name as well as a property object
+func (p *propertyConfiguration) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		*p = propertyConfiguration{Name: name}
+		return nil
+	}
+	type plain propertyConfiguration
+	return json.Unmarshal(data, (*plain)(p))
+}
+
+// MarshalJSON writes string properties as plain names, like they are usually configured
+func (p propertyConfiguration) MarshalJSON() ([]byte, error) {
+	if !p.typed() {
+		return json.Marshal(p.Name)
+	}
+	type plain propertyConfiguration
+	return json.Marshal(plain(p))
+}
+
+// typed returns true if the property is not a string
+func (p propertyConfiguration) typed() bool {
+	return p.Type != "" && p.Type != "string"
+}
+
+// sqlColumn returns the SQL column definition of the property. String properties are never null but
+// empty, typed properties are null if they are missing.
+func (p propertyConfiguration) sqlColumn() (string, error) {
+	if !p.typed() {
+		return "varchar NOT NULL DEFAULT ''", nil
+	}
+	sqlType, ok := propertySQLTypes[p.Type]
+	if !ok {
+		return "", fmt.Errorf("unknown type '%s' of property %s", p.Type, p.Name)
+	}
+	return sqlType, nil
+}
+
+// propertyText returns the SQL text of a typed property column for full text search. Generated columns need
+// immutable expressions, hence timestamps are formatted from their parts instead of with a cast or to_char,
+// which depend on the session's settings. The timestamp columns hold UTC.
+func propertyText(propertyType, column string) string {
+	if propertyType != "timestamp" {
+		return column + "::text"
+	}
+	part := func(field string, width int) string {
+		return fmt.Sprintf("lpad(floor(date_part('%s', %s))::int::text, %d, '0')", field, column, width)
+	}
+	return part("year", 4) + "||'-'||" + part("month", 2) + "||'-'||" + part("day", 2) + "||'T'||" +
+		part("hour", 2) + "||':'||" + part("minute", 2) + "||':'||" + part("second", 2) + "||'Z'"
+}
+
+// propertyToSQL converts the json value of a typed property into its SQL value. Null is a missing value.
+func propertyToSQL(propertyType, name string, value interface{}) (interface{}, error) {
+	if value == nil {
+		return nil, nil
+	}
+	var ok bool
+	switch propertyType {
+	case "integer":
+		var f float64
+		if f, ok = value.(float64); ok && f == math.Trunc(f) && math.Abs(f) < 1<<63 {
+			return int64(f), nil
+		}
+		ok = false
+	case "numeric":
+		_, ok = value.(float64)
+	case "boolean":
+		_, ok = value.(bool)
+	case "timestamp":
+		if s, isString := value.(string); isString {
+			if t, err := time.Parse(time.RFC3339, s); err == nil {
+				return t.UTC(), nil
+			}
+		}
+	default:
+		return value, nil
+	}
+	if !ok {
+		return nil, fmt.Errorf("property %s must be of type %s", name, propertyType)
+	}
+	return value, nil
+}
+
+// parseProperty parses the string representation of a typed property, for example from a path or a filter
+func parseProperty(propertyType, name, value string) (interface{}, error) {
+	var (
+		result interface{}
+		err    error
+	)
+	switch propertyType {
+	case "integer":
+		result, err = strconv.ParseInt(value, 10, 64)
+	case "numeric":
+		result, err = strconv.ParseFloat(value, 64)
+	case "boolean":
+		result, err = strconv.ParseBool(value)
+	case "timestamp":
+		var t time.Time
+		t, err = time.Parse(time.RFC3339, value)
+		result = t.UTC()
+	default:
+		result = value
+	}
+	if err != nil {
+		return nil, fmt.Errorf("property %s must be of type %s, got '%s'", name, propertyType, value)
+	}
+	return result, nil
+}
+
+// propertyValue scans a typed property column. It marshals to the matching json type and it
+// can be written back as SQL value, e.g. into the log.
+type propertyValue struct {
+	value interface{}
+}
+
+// Scan implements sql.Scanner
+func (p *propertyValue) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case []byte: // numeric
+		p.value = json.RawMessage(append([]byte{}, v...))
+	case time.Time:
+		p.value = v.UTC()
+	default:
+		p.value = v
+	}
+	return nil
+}
+
+// Value implements driver.Valuer
+func (p propertyValue) Value() (driver.Value, error) {
+	if raw, ok := p.value.(json.RawMessage); ok {
+		return string(raw), nil
+	}
+	return p.value, nil
+}
+
+// MarshalJSON implements json.Marshaler
+func (p propertyValue) MarshalJSON() ([]byte, error) {
+	return json.Marshal(p.value)
+}
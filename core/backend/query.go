@@ -0,0 +1,363 @@
+// Copyright 2021 Dalarub & Ettrich GmbH - All Rights Reserved
+// Unauthorized copying of this file, via any medium is strictly prohibited
+// Proprietary and confidential
+// info@dalarub.com
+//
+
+package backend
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// sortTerm is one property of the sort query parameter
+type sortTerm struct {
+	property   string
+	descending bool
+}
+
+// parseSortTerms parses a sort query parameter of the form "property,-other,+third".
+// A leading '-' sorts descending, a leading '+' or no prefix sorts ascending.
+func parseSortTerms(value string) ([]sortTerm, error) {
+	var terms []sortTerm
+	for _, s := range strings.Split(value, ",") {
+		term := sortTerm{property: strings.TrimSpace(s)}
+		if strings.HasPrefix(term.property, "-") {
+			term.descending = true
+			term.property = term.property[1:]
+		} else {
+			term.property = strings.TrimPrefix(term.property, "+")
+		}
+		if term.property == "" {
+			return nil, fmt.Errorf("empty sort property in '%s'", value)
+		}
+		terms = append(terms, term)
+	}
+	return terms, nil
+}
+
+// filterExpression is a single condition of a filter or search query parameter
+type filterExpression struct {
+	property string
+	operator string
+	values   []string
+}
+
+// filterOperators are the supported binary operators. Longer operators come first, so
+// that the parser picks ">=" over ">".
+var filterOperators = []string{"!=", ">=", "<=", "~*", "@>", "=", ">", "<", "~"}
+
+var filterKeywordRegexp = regexp.MustCompile(`^([^\s=!<>~@]+)\s+(?i:(is\s+not\s+null|is\s+null)|in\s*\((.*)\))\s*$`)
+
+// maxAggregateGroups limits the number of groups returned by the aggregate route
+const maxAggregateGroups = 1000
+
+// SQL patterns for values which can be cast to numeric or timestamptz
+const (
+	numericRegexp   = `'^\s*-?[0-9]+(\.[0-9]+)?([eE][-+]?[0-9]+)?\s*$'`
+	timestampRegexp = `'^[0-9]{4}-[0-9]{2}-[0-9]{2}([ T][0-9]{2}:[0-9]{2}(:[0-9]{2}(\.[0-9]+)?)?)?(Z|[-+][0-9]{2}(:?[0-9]{2})?)?$'`
+)
+
+// parseFilterExpression parses expressions like "property=value", "property>=value",
+// "property~*pattern", "property IN (a,b,c)" or "property IS NULL"
+func parseFilterExpression(s string) (*filterExpression, error) {
+	if match := filterKeywordRegexp.FindStringSubmatch(s); match != nil {
+		e := &filterExpression{property: match[1]}
+		switch {
+		case match[2] != "":
+			e.operator = "IS NULL"
+			if len(strings.Fields(match[2])) == 3 {
+				e.operator = "IS NOT NULL"
+			}
+		case strings.TrimSpace(match[3]) == "":
+			return nil, fmt.Errorf("empty IN list in '%s'", s)
+		default:
+			for _, value := range strings.Split(match[3], ",") {
+				e.values = append(e.values, strings.TrimSpace(value))
+			}
+			e.operator = "IN"
+		}
+		return e, nil
+	}
+
+	i := strings.IndexAny(s, "=!<>~@")
+	if i < 0 {
+		return nil, fmt.Errorf("cannot parse '%s', must be of type property<operator>value with one of the operators %s, or property IN (a,b,...), or property IS [NOT] NULL",
+			s, strings.Join(filterOperators, " "))
+	}
+	if i == 0 {
+		return nil, fmt.Errorf("missing property in '%s'", s)
+	}
+	for _, operator := range filterOperators {
+		if strings.HasPrefix(s[i:], operator) {
+			// a plain '=' used to separate property and value. Input which contains another operator
+			// before the first '=' meant something else then, hence it is rejected rather than reinterpreted.
+			if j := strings.IndexRune(s, '='); j >= i+len(operator) {
+				return nil, fmt.Errorf("ambiguous '%s', the value of operator %s must not contain '='", s, operator)
+			}
+			return &filterExpression{
+				property: s[:i],
+				operator: operator,
+				values:   []string{s[i+len(operator):]},
+			}, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown operator in '%s'", s)
+}
+
+// jsonProperty returns the SQL expression for a property of the properties json. Dots in
+// the property separate the keys of nested objects, e.g. "settings.locale". With asText the
+// expression evaluates to text, otherwise to json. The property is passed as query parameter.
+func jsonProperty(property string, asText bool, queryParameters *[]interface{}) (string, error) {
+	path := strings.Split(property, ".")
+	for _, key := range path {
+		if key == "" {
+			return "", fmt.Errorf("invalid property path '%s'", property)
+		}
+	}
+	operator := "->"
+	if len(path) > 1 {
+		operator = "#>"
+	}
+	if asText {
+		operator += ">"
+	}
+	if len(path) > 1 {
+		*queryParameters = append(*queryParameters, pq.Array(path))
+		return fmt.Sprintf("properties%s($%d::text[])", operator, len(*queryParameters)), nil
+	}
+	*queryParameters = append(*queryParameters, property)
+	return fmt.Sprintf("properties%s($%d::text)", operator, len(*queryParameters)), nil
+}
+
+// isFilterOrParameter returns true if key is the name of a group of alternative filters,
+// i.e. either "filter_or" or "filter_or.<group>"
+func isFilterOrParameter(key string) bool {
+	return key == "filter_or" || (strings.HasPrefix(key, "filter_or.") && len(key) > len("filter_or."))
+}
+
+// collectionFilter collects the search, filter and filter_or query parameters of a request
+type collectionFilter struct {
+	searches []*filterExpression
+	filters  []*filterExpression
+	orGroups map[string][]*filterExpression
+}
+
+// parse parses the values of query parameter key, if key is one of the filter parameters.
+// It returns false for all other parameters.
+func (f *collectionFilter) parse(key string, values []string) (bool, error) {
+	if key != "search" && key != "filter" && !isFilterOrParameter(key) {
+		return false, nil
+	}
+	for _, value := range values {
+		e, err := parseFilterExpression(value)
+		if err != nil {
+			return true, err
+		}
+		switch key {
+		case "search":
+			f.searches = append(f.searches, e)
+		case "filter":
+			f.filters = append(f.filters, e)
+		default:
+			if f.orGroups == nil {
+				f.orGroups = map[string][]*filterExpression{}
+			}
+			f.orGroups[key] = append(f.orGroups[key], e)
+		}
+	}
+	return true, nil
+}
+
+// compile compiles all collected expressions into SQL. Each condition is prefixed with "AND",
+// parameters are appended to queryParameters.
+func (f *collectionFilter) compile(c *filterCompiler, queryParameters *[]interface{}) (string, error) {
+	sqlQuery := ""
+	for i, e := range append(append([]*filterExpression{}, f.searches...), f.filters...) {
+		search := i < len(f.searches)
+		condition, err := c.compile(e, search, queryParameters)
+		if err != nil {
+			if search {
+				return "", fmt.Errorf("parameter 'search': %v", err)
+			}
+			return "", fmt.Errorf("parameter 'filter': %v", err)
+		}
+		sqlQuery += "AND (" + condition + ") "
+	}
+	// or-groups are sorted by name, that keeps the query string stable
+	var orGroupNames []string
+	for key := range f.orGroups {
+		orGroupNames = append(orGroupNames, key)
+	}
+	sort.Strings(orGroupNames)
+	for _, key := range orGroupNames {
+		var conditions []string
+		for _, e := range f.orGroups[key] {
+			condition, err := c.compile(e, false, queryParameters)
+			if err != nil {
+				return "", fmt.Errorf("parameter '%s': %v", key, err)
+			}
+			conditions = append(conditions, "("+condition+")")
+		}
+		sqlQuery += "AND (" + strings.Join(conditions, " OR ") + ") "
+	}
+	return sqlQuery, nil
+}
+
+// filterCompiler compiles filter expressions into parameterised SQL conditions
+type filterCompiler struct {
+	columns       map[string]string // columns which can be filtered directly, and their SQL type
+	searchable    map[string]bool   // columns which can be searched
+	propertyTypes map[string]string // typed property columns, and their property type
+}
+
+// compile returns the SQL condition for the expression e. Parameters are appended to queryParameters.
+// If search is true, only searchable columns are accepted.
+func (c *filterCompiler) compile(e *filterExpression, search bool, queryParameters *[]interface{}) (string, error) {
+	addParameter := func(value interface{}) string {
+		*queryParameters = append(*queryParameters, value)
+		return "$" + strconv.Itoa(len(*queryParameters))
+	}
+
+	sqlType, isColumn := c.columns[e.property]
+	if search && !c.searchable[e.property] {
+		return "", fmt.Errorf("unknown search property '%s'", e.property)
+	}
+	var lhs string
+	var err error
+	if e.operator == "@>" {
+		if isColumn {
+			return "", fmt.Errorf("operator @> is only supported for json properties, not for %s", e.property)
+		}
+		lhs, err = jsonProperty(e.property, false, queryParameters)
+		if err != nil {
+			return "", err
+		}
+		// the value is an element of a json array. It may be a string or - if it can be
+		// parsed as such - a number or a boolean
+		candidates := []string{}
+		if data, err := json.Marshal([]string{e.values[0]}); err == nil {
+			candidates = append(candidates, string(data))
+		}
+		var value interface{}
+		if err := json.Unmarshal([]byte(e.values[0]), &value); err == nil {
+			switch value.(type) {
+			case float64, bool:
+				candidates = append(candidates, "["+e.values[0]+"]")
+			}
+		}
+		return "(" + lhs + ")::jsonb @> ANY(" + addParameter(pq.Array(candidates)) + "::jsonb[])", nil
+	}
+	if propertyType := c.propertyTypes[e.property]; isColumn && propertyType != "" {
+		return c.compileTyped(e, propertyType, addParameter)
+	}
+	if isColumn {
+		lhs = e.property
+		if sqlType == "uuid" {
+			switch e.operator {
+			case "=", "!=", "IN":
+				for _, value := range e.values {
+					if _, err := uuid.Parse(value); err != nil {
+						return "", fmt.Errorf("invalid identifier '%s' for %s", value, e.property)
+					}
+				}
+			case ">", ">=", "<", "<=":
+				return "", fmt.Errorf("operator %s is not supported for identifier %s", e.operator, e.property)
+			case "IS NULL":
+				return "FALSE", nil
+			case "IS NOT NULL":
+				return "TRUE", nil
+			default:
+				lhs += "::text"
+			}
+		}
+	} else {
+		lhs, err = jsonProperty(e.property, true, queryParameters)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	switch e.operator {
+	case "=":
+		return lhs + "=" + addParameter(e.values[0]), nil
+	case "!=":
+		// missing properties are different from any value
+		return lhs + " IS DISTINCT FROM " + addParameter(e.values[0]), nil
+	case "~":
+		return lhs + " LIKE " + addParameter(e.values[0]), nil
+	case "~*":
+		return lhs + " ILIKE " + addParameter(e.values[0]), nil
+	case ">", ">=", "<", "<=":
+		value := e.values[0]
+		if _, err := strconv.ParseFloat(value, 64); err == nil {
+			// values which are not numbers do not match
+			return fmt.Sprintf("(CASE WHEN %s ~ %s THEN (%s)::numeric END)%s%s::numeric",
+				lhs, numericRegexp, lhs, e.operator, addParameter(value)), nil
+		}
+		if _, err := time.Parse(time.RFC3339, value); err == nil {
+			return fmt.Sprintf("(CASE WHEN %s ~ %s THEN (%s)::timestamptz END)%s%s::timestamptz",
+				lhs, timestampRegexp, lhs, e.operator, addParameter(value)), nil
+		}
+		return "", fmt.Errorf("operator %s requires a number or an RFC3339 timestamp, got '%s'", e.operator, value)
+	case "IN":
+		if isColumn && sqlType == "uuid" {
+			return lhs + "=ANY(" + addParameter(pq.Array(e.values)) + "::uuid[])", nil
+		}
+		return lhs + "=ANY(" + addParameter(pq.Array(e.values)) + "::text[])", nil
+	case "IS NULL":
+		if isColumn {
+			// columns are never null, but empty
+			return lhs + "=''", nil
+		}
+		return lhs + " IS NULL", nil
+	case "IS NOT NULL":
+		if isColumn {
+			return lhs + "<>''", nil
+		}
+		return lhs + " IS NOT NULL", nil
+	}
+	return "", fmt.Errorf("unknown operator %s", e.operator)
+}
+
+// compileTyped returns the SQL condition for the expression e on a typed property column. The values are
+// parsed into the type of the property, hence comparisons are numerical, logical or chronological.
+func (c *filterCompiler) compileTyped(e *filterExpression, propertyType string, addParameter func(value interface{}) string) (string, error) {
+	lhs := e.property
+	parameters := []string{}
+	switch e.operator {
+	case "~":
+		return lhs + "::text LIKE " + addParameter(e.values[0]), nil
+	case "~*":
+		return lhs + "::text ILIKE " + addParameter(e.values[0]), nil
+	case "IS NULL", "IS NOT NULL":
+		// typed properties are null if they are missing
+		return lhs + " " + e.operator, nil
+	}
+	for _, value := range e.values {
+		parsed, err := parseProperty(propertyType, e.property, value)
+		if err != nil {
+			return "", err
+		}
+		parameters = append(parameters, addParameter(parsed))
+	}
+	switch e.operator {
+	case "=", ">", ">=", "<", "<=":
+		return lhs + e.operator + parameters[0], nil
+	case "!=":
+		// missing properties are different from any value
+		return lhs + " IS DISTINCT FROM " + parameters[0], nil
+	case "IN":
+		return lhs + " IN (" + strings.Join(parameters, ",") + ")", nil
+	}
+	return "", fmt.Errorf("unknown operator %s", e.operator)
+}
@@ -133,7 +133,7 @@ func (b *Backend) createRelationResource(router *mux.Router, rc relationConfigur
 	createQuery += fmt.Sprintf("ALTER TABLE %s.\"%s\" ADD COLUMN IF NOT EXISTS timestamp timestamp NOT NULL DEFAULT now();", schema, resource)
 
 	if b.updateSchema {
-		_, err := b.db.Exec(createQuery)
+		err := b.execSchema(createQuery)
 		if err != nil {
 			panic(err)
 		}
@@ -199,6 +199,10 @@ func (b *Backend) createRelationResource(router *mux.Router, rc relationConfigur
 		rightItemRoute = rightItemRoute + "/" + core.Plural(r) + "/{" + r + "_id}"
 	}
 
+	// relations can be created and deleted within batch requests
+	b.batchRoutes[leftItemRoute] = batchRouteRelation
+	b.batchRoutes[rightItemRoute] = batchRouteRelation
+
 	rlog.Debugln("  handle routes:", leftListRoute, "GET")
 	rlog.Debugln("  handle routes:", leftItemRoute, "GET,PUT,DELETE")
 	rlog.Debugln("  handle routes:", rightListRoute, "GET")
@@ -439,7 +443,14 @@ func (b *Backend) createRelationResource(router *mux.Router, rc relationConfigur
 		for i := 0; i < len(columns); i++ {
 			queryParameters[i] = params[columns[i]]
 		}
-		res, err := b.db.Exec(insertQuery, queryParameters...)
+		tx, err := b.beginTx(r.Context())
+		if err != nil {
+			rlog.WithError(err).Errorln("Error 4130: BeginTx")
+			http.Error(w, "Error 4130: ", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+		res, err := tx.Exec(insertQuery, queryParameters...)
 		if err != nil {
 			var code pq.ErrorCode
 			if err, ok := err.(*pq.Error); ok {
@@ -464,6 +475,11 @@ func (b *Backend) createRelationResource(router *mux.Router, rc relationConfigur
 			http.Error(w, "Error 4128: ", http.StatusInternalServerError)
 			return
 		}
+		if err = tx.Commit(); err != nil {
+			rlog.WithError(err).Errorln("Error 4131: Commit")
+			http.Error(w, "Error 4131: ", http.StatusInternalServerError)
+			return
+		}
 
 		if count > 0 {
 			w.WriteHeader(http.StatusCreated)
@@ -516,7 +532,14 @@ func (b *Backend) createRelationResource(router *mux.Router, rc relationConfigur
 		for i := 0; i < len(columns); i++ {
 			queryParameters[i] = params[columns[i]]
 		}
-		res, err := b.db.Exec(deleteQuery, queryParameters...)
+		tx, err := b.beginTx(r.Context())
+		if err != nil {
+			rlog.WithError(err).Errorln("Error 4130: BeginTx")
+			http.Error(w, "Error 4130: ", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+		res, err := tx.Exec(deleteQuery, queryParameters...)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
@@ -527,6 +550,11 @@ func (b *Backend) createRelationResource(router *mux.Router, rc relationConfigur
 			http.Error(w, "Error 4129: ", http.StatusInternalServerError)
 			return
 		}
+		if err = tx.Commit(); err != nil {
+			rlog.WithError(err).Errorln("Error 4131: Commit")
+			http.Error(w, "Error 4131: ", http.StatusInternalServerError)
+			return
+		}
 
 		if count > 0 {
 			w.WriteHeader(http.StatusNoContent)
@@ -0,0 +1,84 @@
+// Copyright 2021 Dalarub & Ettrich GmbH - All Rights Reserved
+// Unauthorized copying of this file, via any medium is strictly prohibited
+// Proprietary and confidential
+// info@dalarub.com
+//
+
+package backend
+
+import (
+	"context"
+	"database/sql"
+)
+
+// contextKey is the type for context keys. Go linter does not like plain strings
+type contextKey string
+
+const contextKeyTransaction contextKey = "_transaction_"
+
+// transaction is a database transaction. It is either a real transaction, or a
+// savepoint within a surrounding transaction.
+type transaction interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+	Commit() error
+	Rollback() error
+}
+
+// contextWithTransaction returns a new context with a surrounding transaction. Handlers
+// called with this context do not commit on their own, but within the surrounding
+// transaction. This lets bulk operations reuse the single item handlers.
+func contextWithTransaction(ctx context.Context, tx *sql.Tx) context.Context {
+	return context.WithValue(ctx, contextKeyTransaction, tx)
+}
+
+// beginTx starts a new transaction. If the context carries a surrounding transaction, the
+// new transaction is a savepoint within it: commit releases the savepoint and rollback
+// only reverts the changes made since the savepoint.
+func (b *Backend) beginTx(ctx context.Context) (transaction, error) {
+	tx, ok := ctx.Value(contextKeyTransaction).(*sql.Tx)
+	if !ok {
+		return b.db.BeginTx(ctx, nil)
+	}
+	// savepoints are strictly nested, hence they can share the same name
+	if _, err := tx.Exec("SAVEPOINT nested;"); err != nil {
+		return nil, err
+	}
+	return &savepointTx{Tx: tx}, nil
+}
+
+// savepointTx is a transaction nested within a surrounding transaction
+type savepointTx struct {
+	*sql.Tx
+	done bool
+}
+
+// Commit releases the savepoint
+func (t *savepointTx) Commit() error {
+	if t.done {
+		return sql.ErrTxDone
+	}
+	t.done = true
+	_, err := t.Tx.Exec("RELEASE SAVEPOINT nested;")
+	return err
+}
+
+// Rollback reverts all changes since the savepoint
+func (t *savepointTx) Rollback() error {
+	if t.done {
+		return sql.ErrTxDone
+	}
+	t.done = true
+	_, err := t.Tx.Exec("ROLLBACK TO SAVEPOINT nested; RELEASE SAVEPOINT nested;")
+	return err
+}
+
+// sqlTx returns the database transaction of tx, for a savepoint this is the surrounding transaction.
+// Handlers called with it in their context run as savepoints within tx.
+func sqlTx(tx transaction) *sql.Tx {
+	if t, ok := tx.(*savepointTx); ok {
+		return t.Tx
+	}
+	return tx.(*sql.Tx)
+}
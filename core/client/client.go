@@ -236,6 +236,26 @@ func (r Collection) WithFilter(key string, value string) Collection {
 	return r.WithParameter("filter", key+"="+value)
 }
 
+// WithFilterOr returns a new collection client with a group of alternative filters added.
+// Items match the group if they match at least one of the filter expressions, for example
+//
+//	WithFilterOr("status=failed", "status=overdue")
+//
+// Each call adds a new group, groups and ordinary filters are combined with logical AND.
+func (r Collection) WithFilterOr(expressions ...string) Collection {
+	groups := map[string]bool{}
+	for _, parameter := range r.parameters {
+		if strings.HasPrefix(parameter, "filter_or.") {
+			groups[parameter[:strings.IndexRune(parameter, '=')]] = true
+		}
+	}
+	key := "filter_or." + strconv.Itoa(len(groups)+1)
+	for _, expression := range expressions {
+		r = r.WithParameter(key, expression)
+	}
+	return r
+}
+
 func (r Collection) paths() (collectionPath, singletonPath string) {
 	itemPath := r.prefix
 	for _, resource := range r.resources {
@@ -370,6 +390,44 @@ func (r Collection) List(result interface{}) (int, error) {
 	return r.client.RawGet(r.CollectionPath(), result)
 }
 
+// Aggregate gets aggregated values of the collection, for example the number of items per status with
+//
+//	WithParameter("group_by", "status").WithParameter("count", "true").Aggregate(&result)
+//
+// The operation corresponds to a GET request on the aggregate route of the collection.
+//
+// Expects http.StatusOK as response, otherwise it will
+// flag an error. Returns the actual http status code.
+//
+// result can be []map[string]interface{} or a raw *[]byte.
+func (r Collection) Aggregate(result interface{}) (int, error) {
+	path := r.CollectionPath()
+	query := ""
+	if i := strings.IndexRune(path, '?'); i >= 0 {
+		path, query = path[:i], path[i:]
+	}
+	return r.client.RawGet(path+"/aggregate"+query, result)
+}
+
+// Import creates many items at once. items is either a slice of objects, which is sent as json array,
+// or raw newline delimited json as []byte.
+//
+// The operation corresponds to a POST request on the import route of the collection.
+//
+// Expects http.StatusOK as response, otherwise it will
+// flag an error. Returns the actual http status code. The result reports success
+// or failure for each individual item.
+//
+// result can be []map[string]interface{} or a raw *[]byte.
+func (r Collection) Import(items interface{}, result interface{}) (int, error) {
+	path := r.CollectionPath()
+	query := ""
+	if i := strings.IndexRune(path, '?'); i >= 0 {
+		path, query = path[:i], path[i:]
+	}
+	return r.client.RawPost(path+"/import"+query, items, result)
+}
+
 // Item represents a single item in a collection
 type Item struct {
 	col         Collection
@@ -517,18 +575,50 @@ func (r Item) Patch(body interface{}, result interface{}) (int, error) {
 	return r.col.client.RawPatch(r.Path(), body, result)
 }
 
+// JSONPatch applies a json patch document (RFC 6902) to an item, i.e. a list of
+// operations like {"op":"remove","path":"/settings/locale"}
+//
+// Expects http.StatusOK, http.StatusCreated or http.StatusNoContent as valid responses,
+// otherwise it will flag an error. Returns the actual http status code.
+//
+// operations can also be a []byte, result can also be raw *[]byte.
+// result can be nil.
+func (r Item) JSONPatch(operations interface{}, result interface{}) (int, error) {
+	return r.col.client.RawPatchWithHeader(r.Path(), map[string]string{"Content-Type": "application/json-patch+json"}, operations, result)
+}
+
+// MergePatch applies a json merge patch (RFC 7396) to an item. Other than with Patch,
+// null values remove properties.
+//
+// Expects http.StatusOK, http.StatusCreated or http.StatusNoContent as valid responses,
+// otherwise it will flag an error. Returns the actual http status code.
+//
+// body can also be a []byte, result can also be raw *[]byte.
+// result can be nil.
+func (r Item) MergePatch(body interface{}, result interface{}) (int, error) {
+	return r.col.client.RawPatchWithHeader(r.Path(), map[string]string{"Content-Type": "application/merge-patch+json"}, body, result)
+}
+
 // Page is a requester for one page in a collection
+//
+// Pages follow the "Pagination-Next-Cursor" header of the backend whenever it is
+// available, otherwise they fall back to numbered pages. A page which is shorter than
+// the limit is the last page.
 type Page struct {
 	r          Collection
 	page       int
 	pageCount  int
 	totalCount int
+	cursor     string
+	nextCursor string
+	short      bool
+	exhausted  bool
 }
 
 // FirstPage returns a requester for the first page of a collection
 //
-// Do not specify the page filter when using the page requester, as
-// it manages page itself. You can set all others parameters, including
+// Do not specify the page or cursor filter when using the page requester, as
+// it manages pages itself. You can set all others parameters, including
 // limit.
 func (r Collection) FirstPage() Page {
 	return Page{page: 1, r: r}
@@ -536,7 +626,10 @@ func (r Collection) FirstPage() Page {
 
 // HasData returns true if the page has data (by definition true for the first page)
 func (p Page) HasData() bool {
-	return p.page == 1 || p.page <= p.pageCount
+	if p.exhausted {
+		return false
+	}
+	return p.page == 1 || p.cursor != "" || p.page <= p.pageCount
 }
 
 // TotalCount returns the total number of elements (only available after you have called Get on the page)
@@ -546,11 +639,32 @@ func (p Page) TotalCount() int {
 
 // Get gets one page of the collection
 func (p *Page) Get(result interface{}) (int, error) {
-	path := p.r.WithParameter("page", strconv.Itoa(p.page)).CollectionPath()
-	status, header, err := p.r.client.RawGetWithHeader(path, map[string]string{}, result)
+	var path string
+	if p.cursor != "" {
+		path = p.r.WithParameter("cursor", p.cursor).CollectionPath()
+	} else {
+		path = p.r.WithParameter("page", strconv.Itoa(p.page)).CollectionPath()
+	}
+	var body []byte
+	status, header, err := p.r.client.RawGetWithHeader(path, map[string]string{}, &body)
 	if err != nil {
 		return status, err
 	}
+	var items []json.RawMessage
+	if len(body) > 0 {
+		if err = json.Unmarshal(body, &items); err != nil {
+			return status, err
+		}
+	}
+	if raw, ok := result.(*[]byte); ok {
+		*raw = body
+	} else if result != nil && len(body) > 0 {
+		if err = json.Unmarshal(body, result); err != nil {
+			return status, err
+		}
+	}
+	limit, err := strconv.Atoi(header.Get("Pagination-Limit"))
+	p.short = err == nil && len(items) < limit
 	pageCount, err := strconv.Atoi(header.Get("Pagination-Page-Count"))
 	if err == nil {
 		p.pageCount = pageCount
@@ -559,16 +673,27 @@ func (p *Page) Get(result interface{}) (int, error) {
 	if err == nil {
 		p.totalCount = totalCount
 	}
+	p.nextCursor = header.Get("Pagination-Next-Cursor")
 	return status, nil
 }
 
 // Next returns the next page
 func (p Page) Next() Page {
-	return Page{
-		r:         p.r,
-		page:      p.page + 1,
-		pageCount: p.pageCount,
-	}
+	next := Page{
+		r:          p.r,
+		page:       p.page + 1,
+		pageCount:  p.pageCount,
+		totalCount: p.totalCount,
+	}
+	if p.short {
+		next.exhausted = true
+	} else if p.nextCursor != "" {
+		next.cursor = p.nextCursor
+	} else if p.cursor != "" {
+		// we were following cursors and the backend did not give us another one
+		next.exhausted = true
+	}
+	return next
 }
 
 // RawGet gets the resource from path. Expects http.StatusOK as response, otherwise it will
@@ -826,6 +951,11 @@ func (c Client) RawPostBlob(path string, header map[string]string, blob []byte,
 // body can also be a []byte, result can also be raw *[]byte.
 // result can be nil.
 func (c Client) RawPut(path string, body interface{}, result interface{}) (int, error) {
+	return c.RawPutWithHeader(path, nil, body, result)
+}
+
+// RawPutWithHeader is like RawPut, but with additional request headers, e.g. If-Match
+func (c Client) RawPutWithHeader(path string, header map[string]string, body interface{}, result interface{}) (int, error) {
 
 	var err error
 	j, ok := body.([]byte)
@@ -837,6 +967,9 @@ func (c Client) RawPut(path string, body interface{}, result interface{}) (int,
 	}
 
 	r, _ := http.NewRequestWithContext(c.context(), http.MethodPut, c.url+path, bytes.NewBuffer(j))
+	for key, value := range header {
+		r.Header.Add(key, value)
+	}
 	var res *http.Response
 	var resBody []byte
 	if c.router != nil {
@@ -925,6 +1058,11 @@ func (c Client) RawPutBlob(path string, header map[string]string, blob []byte, r
 // body can also be a []byte, result can also be raw *[]byte.
 // result can be nil.
 func (c Client) RawPatch(path string, body interface{}, result interface{}) (int, error) {
+	return c.RawPatchWithHeader(path, nil, body, result)
+}
+
+// RawPatchWithHeader is like RawPatch, but with additional request headers, e.g. Content-Type
+func (c Client) RawPatchWithHeader(path string, header map[string]string, body interface{}, result interface{}) (int, error) {
 
 	var err error
 	j, ok := body.([]byte)
@@ -936,6 +1074,9 @@ func (c Client) RawPatch(path string, body interface{}, result interface{}) (int
 	}
 
 	r, _ := http.NewRequestWithContext(c.context(), http.MethodPatch, c.url+path, bytes.NewBuffer(j))
+	for key, value := range header {
+		r.Header.Add(key, value)
+	}
 	var res *http.Response
 	var resBody []byte
 	if c.router != nil {
@@ -975,7 +1116,15 @@ func (c Client) RawPatch(path string, body interface{}, result interface{}) (int
 //
 // Returns the actual http status code.
 func (c Client) RawDelete(path string) (int, error) {
+	return c.RawDeleteWithHeader(path, nil)
+}
+
+// RawDeleteWithHeader is like RawDelete, but with additional request headers, e.g. If-Match
+func (c Client) RawDeleteWithHeader(path string, header map[string]string) (int, error) {
 	r, _ := http.NewRequestWithContext(c.context(), http.MethodDelete, c.url+path, nil)
+	for key, value := range header {
+		r.Header.Add(key, value)
+	}
 	var err error
 	var res *http.Response
 	var resBody []byte
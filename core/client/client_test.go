@@ -65,6 +65,12 @@ func TestCient_TestClient(t *testing.T) {
 		t.Fatal("unexpected collection path:", p)
 	}
 
+	collection = client.Collection("parent/child").WithFilterOr("status=failed", "status=overdue").WithFilterOr("a=1", "b=2")
+	if p := collection.CollectionPath(); p != "/parents/all/children?filter_or.1="+url.QueryEscape("status=failed")+"&filter_or.1="+url.QueryEscape("status=overdue")+
+		"&filter_or.2="+url.QueryEscape("a=1")+"&filter_or.2="+url.QueryEscape("b=2") {
+		t.Fatal("unexpected collection path:", p)
+	}
+
 	collection = client.Relation("myrelation").Collection("left/right").WithParent(leftID)
 	if p := collection.CollectionPath(); p != "/myrelation/lefts/"+leftID.String()+"/rights" {
 		t.Fatal("unexpected collection path:", p)
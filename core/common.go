@@ -13,7 +13,7 @@ import (
 	"github.com/goccy/go-json"
 )
 
-// Operation represents a modifying backend storage operation, one of Create, Read, Update, Delete, List, Clear
+// Operation represents a modifying backend storage operation, one of Create, Read, Update, Delete, List, Clear, Export
 type Operation string
 
 // all supported database operations
@@ -25,6 +25,7 @@ const (
 	OperationDelete Operation = "delete"
 	OperationList   Operation = "list"
 	OperationClear  Operation = "clear"
+	OperationExport Operation = "export"
 
 	OperationCompanionUploaded Operation = "companion_uploaded"
 )
@@ -37,7 +38,7 @@ func (o *Operation) UnmarshalJSON(data []byte) error {
 	}
 	*o = Operation(s)
 	switch *o {
-	case OperationCreate, OperationRead, OperationUpdate, OperationDelete, OperationList, OperationClear:
+	case OperationCreate, OperationRead, OperationUpdate, OperationDelete, OperationList, OperationClear, OperationExport:
 		return nil
 	default:
 		return fmt.Errorf("%s is not valid Operation", s)